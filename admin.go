@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const dashboardListLen = 5
+
+// DashboardStats summarises the blog for the admin dashboard.
+type DashboardStats struct {
+	TotalPosts  int
+	Drafts      int
+	TotalWords  int
+	MostViewed  []RecordViews
+	RecentEdits []*Record
+}
+
+type RecordViews struct {
+	Record *Record
+	Views  int
+}
+
+// computeStats aggregates records and their view counts in a single pass
+// plus two sorts, which is plenty fast for a few thousand posts.
+func computeStats(records []*Record, counts map[string]int) *DashboardStats {
+	s := &DashboardStats{TotalPosts: len(records)}
+	viewed := make([]RecordViews, 0, len(records))
+	for _, r := range records {
+		if !r.Published {
+			s.Drafts++
+		}
+		s.TotalWords += len(strings.Fields(r.Content))
+		if n := counts[r.Slug()]; n > 0 {
+			viewed = append(viewed, RecordViews{Record: r, Views: n})
+		}
+	}
+
+	sort.SliceStable(viewed, func(i, j int) bool { return viewed[i].Views > viewed[j].Views })
+	if len(viewed) > dashboardListLen {
+		viewed = viewed[:dashboardListLen]
+	}
+	s.MostViewed = viewed
+
+	recent := make([]*Record, len(records))
+	copy(recent, records)
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].UpdatedAt.After(recent[j].UpdatedAt) })
+	if len(recent) > dashboardListLen {
+		recent = recent[:dashboardListLen]
+	}
+	s.RecentEdits = recent
+	return s
+}
+
+func adminHandler(w http.ResponseWriter, r *http.Request) {
+	records, problems, err := LoadAllRecords()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to load all records: %v", err), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "admin", &TemplateData{Stats: computeStats(records, views.snapshot()), Problems: problems})
+}
+
+// RecordUsage is how much disk a single record file takes.
+type RecordUsage struct {
+	Slug  string `json:"slug"`
+	Bytes int64  `json:"bytes"`
+}
+
+// DiskUsage breaks down what recordsDir holds, in bytes.
+type DiskUsage struct {
+	Records   []RecordUsage `json:"records"`
+	Total     int64         `json:"total"`
+	Revisions int64         `json:"revisions"`
+	Deleted   int64         `json:"deleted"`
+}
+
+// computeDiskUsage sizes every record file, largest first, plus the
+// revisions and deleted directories when they exist.
+func computeDiskUsage() (*DiskUsage, error) {
+	files, err := ioutil.ReadDir(recordsDir)
+	if err != nil {
+		return nil, err
+	}
+	u := &DiskUsage{Records: make([]RecordUsage, 0, len(files))}
+	for _, f := range files {
+		if f.IsDir() || !isRecordFile(f.Name()) {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(recordsDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		u.Records = append(u.Records, RecordUsage{Slug: strings.TrimSuffix(f.Name(), ".json"), Bytes: fi.Size()})
+	}
+	sort.SliceStable(u.Records, func(i, j int) bool { return u.Records[i].Bytes > u.Records[j].Bytes })
+
+	if u.Revisions, err = dirSize(filepath.Join(recordsDir, "revisions")); err != nil {
+		return nil, err
+	}
+	if u.Deleted, err = dirSize(filepath.Join(recordsDir, "deleted")); err != nil {
+		return nil, err
+	}
+	if u.Total, err = dirSize(recordsDir); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// dirSize adds up the files under dir; a missing dir is empty.
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return total, err
+}
+
+func diskUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowed(r, actionManage, nil) {
+		forbiddenJSON(w, "manage the site")
+		return
+	}
+	u, err := computeDiskUsage()
+	if err != nil {
+		errorf("unable to check disk usage: %v", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "unable to check disk usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// rebuildSummary reports what POST /admin/rebuild-index did.
+type rebuildSummary struct {
+	Records       int      `json:"records"`
+	Skipped       int      `json:"skipped"`
+	ViewsPruned   int      `json:"views_pruned"`
+	AliasesPruned int      `json:"aliases_pruned"`
+	Errors        []string `json:"errors"`
+}
+
+// rebuildIndexHandler brings the state derived from the record files (view
+// counts, slug aliases, the title and tag index and the cached sitemaps)
+// back in line with the files, e.g. after records were deleted or restored
+// by hand.
+func rebuildIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !allowed(r, actionManage, nil) {
+		forbiddenJSON(w, "manage the site")
+		return
+	}
+
+	records, problems, err := LoadAllRecords()
+	if err != nil {
+		errorf("unable to rebuild index: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "unable to load records")
+		return
+	}
+	sum := rebuildSummary{Records: len(records), Skipped: len(problems), Errors: make([]string, 0)}
+	for _, p := range problems {
+		sum.Errors = append(sum.Errors, fmt.Sprintf("%s: %v", p.File, p.Err))
+	}
+
+	exists := make(map[string]bool, len(records))
+	for _, rec := range records {
+		exists[rec.Slug()] = true
+	}
+	// a broken file is still a post, don't throw away what belongs to it
+	for _, p := range problems {
+		exists[strings.TrimSuffix(p.File, ".json")] = true
+	}
+
+	sum.ViewsPruned = views.prune(exists)
+	if err := views.flush(viewsFile); err != nil {
+		sum.Errors = append(sum.Errors, "saving view counts: "+err.Error())
+	}
+	if sum.AliasesPruned, err = pruneAliases(exists); err != nil {
+		sum.Errors = append(sum.Errors, "pruning aliases: "+err.Error())
+	}
+	titleIdx.invalidate()
+	sitemaps.reset()
+
+	infof("rebuilt index: %d records, %d skipped, %d view counts and %d aliases pruned",
+		sum.Records, sum.Skipped, sum.ViewsPruned, sum.AliasesPruned)
+	writeJSON(w, http.StatusOK, sum)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	records := make([]*Record, 0)
+	for i := 1; i <= 7; i++ {
+		records = append(records, &Record{
+			Title:     fmt.Sprintf("Post %d", i),
+			Content:   strings.Repeat("word ", i),
+			Published: i%3 != 0,
+			UpdatedAt: day(i),
+		})
+	}
+	counts := map[string]int{"post-2": 10, "post-5": 30, "post-7": 20, "deleted-post": 99}
+
+	s := computeStats(records, counts)
+	if s.TotalPosts != 7 || s.Drafts != 2 || s.TotalWords != 28 {
+		t.Fatalf("unexpected totals: %+v", s)
+	}
+
+	viewed := make([]string, 0)
+	for _, rv := range s.MostViewed {
+		viewed = append(viewed, fmt.Sprintf("%s=%d", rv.Record.Slug(), rv.Views))
+	}
+	if got := strings.Join(viewed, ","); got != "post-5=30,post-7=20,post-2=10" {
+		t.Fatalf("unexpected most viewed: %s", got)
+	}
+
+	if len(s.RecentEdits) != dashboardListLen || s.RecentEdits[0].Title != "Post 7" || s.RecentEdits[4].Title != "Post 3" {
+		t.Fatalf("unexpected recent edits: %v", titles(s.RecentEdits))
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	dir := useTempRecords(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Record{Title: "Only Post", Content: "three little words", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	adminHandler(w, httptest.NewRequest("GET", "/admin", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "1 posts, 0 drafts, 3 words in total") {
+		t.Fatalf("unexpected dashboard:\n%s", w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "broken.json") {
+		t.Fatalf("expected the broken record to be listed:\n%s", w.Body)
+	}
+	for _, action := range []string{"/admin/rebuild-index", "/admin/theme/reload"} {
+		if !strings.Contains(w.Body.String(), `<form method="post" action="`+action+`">`) {
+			t.Fatalf("expected a form posting to %s:\n%s", action, w.Body)
+		}
+	}
+}
+
+func TestDiskUsageHandler(t *testing.T) {
+	dir := useTempRecords(t)
+	files := map[string]string{
+		"small.json":             `{"title":"S"}`,
+		"large.json":             `{"title":"Large","content":"` + strings.Repeat("x", 100) + `"}`,
+		"revisions/large/1.json": `{"title":"L"}`,
+		"deleted/gone.json":      `{}`,
+		"notes.txt":              `ignored`,
+	}
+	var total int64
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		total += int64(len(content))
+	}
+
+	w := httptest.NewRecorder()
+	diskUsageHandler(w, httptest.NewRequest("GET", "/admin/disk-usage", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var u DiskUsage
+	if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Records) != 2 || u.Records[0].Slug != "large" || u.Records[1].Slug != "small" {
+		t.Fatalf("expected large then small, got %+v", u.Records)
+	}
+	if u.Records[1].Bytes != int64(len(files["small.json"])) {
+		t.Fatalf("unexpected size for small: %d", u.Records[1].Bytes)
+	}
+	if u.Total != total || u.Revisions != 13 || u.Deleted != 2 {
+		t.Fatalf("unexpected totals: %+v", u)
+	}
+
+	// the records directory going away is a broken filesystem
+	recordsDir = filepath.Join(dir, "missing")
+	w = httptest.NewRecorder()
+	diskUsageHandler(w, httptest.NewRequest("GET", "/admin/disk-usage", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+}
+
+func TestRebuildIndexHandler(t *testing.T) {
+	dir := useTempRecords(t)
+	oldViews, oldFile := views, viewsFile
+	views, viewsFile = &viewCounter{counts: make(map[string]int)}, filepath.Join(t.TempDir(), "views.json")
+	t.Cleanup(func() { views, viewsFile = oldViews, oldFile })
+
+	if err := (&Record{Title: "Kept", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	views.add("kept")
+	views.add("broken")
+	views.add("deleted-by-hand")
+	for _, a := range [][2]string{{"old-kept", "kept"}, {"old-gone", "gone"}} {
+		if err := addAlias(a[0], a[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	rebuildIndexHandler(w, httptest.NewRequest("POST", "/admin/rebuild-index", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var sum rebuildSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum.Records != 1 || sum.Skipped != 1 || sum.ViewsPruned != 1 || sum.AliasesPruned != 1 || len(sum.Errors) != 1 {
+		t.Fatalf("unexpected summary: %+v", sum)
+	}
+
+	counts := views.snapshot()
+	if len(counts) != 2 || counts["kept"] != 1 || counts["broken"] != 1 {
+		t.Fatalf("unexpected view counts: %v", counts)
+	}
+	if _, err := os.Stat(viewsFile); err != nil {
+		t.Fatalf("expected the view counts to be saved: %v", err)
+	}
+	if aliases, _ := loadAliases(); len(aliases) != 1 || aliases["old-kept"] != "kept" {
+		t.Fatalf("unexpected aliases: %v", aliases)
+	}
+
+	w = httptest.NewRecorder()
+	rebuildIndexHandler(w, httptest.NewRequest("GET", "/admin/rebuild-index", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
+
+func TestRebuildIndexClearsCaches(t *testing.T) {
+	dir := useTempRecords(t)
+	oldViews, oldFile := views, viewsFile
+	views, viewsFile = &viewCounter{counts: make(map[string]int)}, filepath.Join(t.TempDir(), "views.json")
+	t.Cleanup(func() { views, viewsFile = oldViews, oldFile })
+	t.Cleanup(sitemaps.reset)
+	if err := (&Record{Title: "Saved", Published: true, Tags: []string{"go"}}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := titleIdx.tagCounts(); err != nil {
+		t.Fatal(err)
+	}
+	sitemaps.get("/sitemap.xml", func() ([]byte, error) { return []byte("stale"), nil })
+
+	// a post restored by hand, which neither cache has heard of
+	writeRecordAt(t, dir, &Record{Title: "Restored", Published: true, Tags: []string{"go"}}, time.Now())
+
+	w := httptest.NewRecorder()
+	rebuildIndexHandler(w, httptest.NewRequest("POST", "/admin/rebuild-index", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if counts, _ := titleIdx.tagCounts(); counts["go"] != 2 {
+		t.Errorf("expected the restored post to be counted, got %v", counts)
+	}
+	data, _ := sitemaps.get("/sitemap.xml", func() ([]byte, error) { return []byte("fresh"), nil })
+	if string(data) != "fresh" {
+		t.Errorf("expected the cached sitemap to be dropped, got %s", data)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// aliasMu serialises the read-modify-write of the alias file.
+var aliasMu sync.Mutex
+
+// aliasesFile maps the slugs posts used to have to the ones they have now,
+// so old links keep working after a rename. The leading dot keeps
+// AllRecords from loading it as a post.
+func aliasesFile() string {
+	return filepath.Join(recordsDir, ".aliases.json")
+}
+
+func loadAliases() (map[string]string, error) {
+	aliases := make(map[string]string)
+	data, err := ioutil.ReadFile(aliasesFile())
+	if os.IsNotExist(err) {
+		return aliases, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// addAlias records that oldSlug now lives at newSlug. Aliases that pointed
+// at oldSlug are moved along too, so a post renamed twice redirects in one
+// hop from either of its old slugs.
+func addAlias(oldSlug, newSlug string) error {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	aliases, err := loadAliases()
+	if err != nil {
+		return err
+	}
+	for from, to := range aliases {
+		if to == oldSlug {
+			aliases[from] = newSlug
+		}
+	}
+	aliases[oldSlug] = newSlug
+	// the new slug is a real post again, e.g. after renaming back
+	delete(aliases, newSlug)
+
+	return saveAliases(aliases)
+}
+
+func saveAliases(aliases map[string]string) error {
+	data, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(aliasesFile(), data, 0600)
+}
+
+// pruneAliases drops aliases that point at posts that no longer exist, or
+// that a post has since taken over, returning how many were dropped.
+func pruneAliases(exists map[string]bool) (int, error) {
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	aliases, err := loadAliases()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for from, to := range aliases {
+		if !exists[to] || exists[from] {
+			delete(aliases, from)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, saveAliases(aliases)
+}
+
+// resolveAlias returns the slug a renamed post now lives at.
+func resolveAlias(slug string) (string, bool) {
+	aliases, err := loadAliases()
+	if err != nil {
+		errorf("unable to load slug aliases: %v", err)
+		return "", false
+	}
+	to, ok := aliases[slug]
+	return to, ok
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRenamedPostRedirects(t *testing.T) {
+	useTempRecords(t)
+	if err := (&Record{Title: "First Name", Content: "x", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rename := func(from, title string) {
+		t.Helper()
+		form := url.Values{"title": {title}, "content": {"x"}, "published": {"1"}}
+		r := httptest.NewRequest("POST", "/save/"+from, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		saveHandler(w, r)
+		if w.Code != http.StatusFound {
+			t.Fatalf("rename failed: %d %s", w.Code, w.Body)
+		}
+	}
+	rename("first-name", "Second Name")
+	rename("second-name", "Third Name")
+
+	tt := []struct {
+		name     string
+		target   string
+		code     int
+		location string
+	}{
+		{"first", "/show/first-name", http.StatusMovedPermanently, "/show/third-name"},
+		{"second", "/show/second-name", http.StatusMovedPermanently, "/show/third-name"},
+		{"current", "/show/third-name", http.StatusOK, ""},
+		{"unknown", "/show/never-existed", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			showHandler(w, httptest.NewRequest("GET", tc.target, nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tc.location {
+				t.Fatalf("expected location %q, got %q", tc.location, got)
+			}
+		})
+	}
+
+	// the alias file must not show up as a post
+	records, err := AllRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %v", titles(records))
+	}
+}
+
+func TestAddAliasRenameBack(t *testing.T) {
+	useTempRecords(t)
+	if err := addAlias("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addAlias("b", "a"); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := loadAliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases["b"] != "a" {
+		t.Fatalf("expected only b -> a, got %v", aliases)
+	}
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var apiPath = regexp.MustCompile("^/api/records/([a-zA-Z0-9\\-]+)(?:/(publish|unpublish|tags|meta|related|stats))?$")
+
+const maxTagLen = 50
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// apiRecord is the JSON shape of a record, with its slug spelled out.
+type apiRecord struct {
+	Slug string `json:"slug"`
+	*Record
+	// AccessPassword hides the record's passphrase hash, it never leaves
+	// the server
+	AccessPassword *string `json:"access_password,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		errorf("unable to encode response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeBodyError answers a JSON body that couldn't be decoded: 413 when it
+// was over maxPostSize, 400 with msg otherwise.
+func writeBodyError(w http.ResponseWriter, err error, msg string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, msg+": "+err.Error())
+}
+
+func getAPISlug(r *http.Request) string {
+	m := apiPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func apiRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	m := apiPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		writeJSONError(w, http.StatusNotFound, "no such endpoint")
+		return
+	}
+
+	switch m[2] {
+	case "":
+		writes.limit(requireWritable(requireAPIKey(patchRecordHandler)))(w, r)
+	case "publish":
+		writes.limit(requireWritable(requireAPIKey(publishHandler)))(w, r)
+	case "unpublish":
+		writes.limit(requireWritable(requireAPIKey(unpublishHandler)))(w, r)
+	case "tags":
+		writes.limit(requireWritable(requireAPIKey(tagsHandler)))(w, r)
+	case "meta":
+		writes.limit(requireWritable(requireAPIKey(metaHandler)))(w, r)
+	case "related":
+		requireAPIReadKey(relatedHandler)(w, r)
+	case "stats":
+		requireAPIKey(recordStatsHandler)(w, r)
+	}
+}
+
+// readWrite sends GET and HEAD requests to read and the rest to write, for
+// API paths that list with one and change things with the other.
+func readWrite(read, write http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			read(w, r)
+			return
+		}
+		write(w, r)
+	}
+}
+
+// createRequest is the body of POST /api/records.
+type createRequest struct {
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Tags    []string `json:"tags"`
+	Author  string   `json:"author"`
+	// Published defaults to true, as for record files
+	Published *bool `json:"published"`
+}
+
+// validationErrors is the body of a 422 listing everything wrong at once.
+type validationErrors struct {
+	Error  string   `json:"error"`
+	Errors []string `json:"errors"`
+}
+
+// createRecordHandler serves POST /api/records, creating a record from a
+// JSON body.
+func createRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "expected an application/json body")
+		return
+	}
+	if !allowed(r, actionCreate, nil) {
+		forbiddenJSON(w, "create records")
+		return
+	}
+
+	var req createRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxPostSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON body")
+		return
+	}
+	rec := &Record{
+		Title:     strings.TrimSpace(req.Title),
+		Content:   req.Content,
+		Tags:      req.Tags,
+		Author:    strings.TrimSpace(req.Author),
+		Published: req.Published == nil || *req.Published,
+	}
+	if rec.Author == "" {
+		rec.Author = requestUser(r)
+	}
+	errs := validateRecord(rec)
+	if err := validateTags(rec.Tags); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, validationErrors{Error: "invalid record", Errors: errs})
+		return
+	}
+
+	err := CreateRecord(rec, slugCollision == "suffix")
+	if errors.Is(err, ErrSlugExists) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	} else if errors.Is(err, ErrInvalidSlug) {
+		writeJSON(w, http.StatusUnprocessableEntity, validationErrors{Error: "invalid record", Errors: []string{err.Error()}})
+		return
+	} else if err != nil {
+		errorf("unable to create %s: %v", rec.Slug(), err)
+		writeJSONError(w, http.StatusInternalServerError, "unable to create the record")
+		return
+	}
+	announce(baseURL(r), nil, rec)
+	w.Header().Set("Location", "/show/"+rec.Slug())
+	writeJSON(w, http.StatusCreated, apiRecord{Slug: rec.Slug(), Record: rec})
+}
+
+// patchRequest is the body of PATCH /api/records/{slug}. Only the fields
+// present are applied; leaving one out, or sending null, keeps its value.
+type patchRequest struct {
+	Title     *string   `json:"title"`
+	Content   *string   `json:"content"`
+	Tags      *[]string `json:"tags"`
+	Published *bool     `json:"published"`
+}
+
+// patchRecordHandler serves PATCH /api/records/{slug}, changing only the
+// fields the body has.
+func patchRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		w.Header().Set("Allow", http.MethodPatch)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "expected an application/json body")
+		return
+	}
+
+	var req patchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPostSize))
+	// a misspelt field would otherwise be a change silently not made
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON body")
+		return
+	}
+
+	slug := getAPISlug(r)
+	old, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "record not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed(r, actionEdit, old) {
+		forbiddenJSON(w, "edit this record")
+		return
+	}
+
+	rec := *old
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title != old.Title && !immutableSlugs {
+			// a new title means a new slug, as in the edit form
+			rec.StoredSlug = ""
+		}
+		rec.Title = title
+	}
+	if req.Content != nil {
+		rec.Content = *req.Content
+	}
+	if req.Tags != nil {
+		rec.Tags = *req.Tags
+	}
+	if req.Published != nil {
+		rec.Published = *req.Published
+	}
+	errs := validateRecord(&rec)
+	if err := validateTags(rec.Tags); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, validationErrors{Error: "invalid record", Errors: errs})
+		return
+	}
+
+	if rec.Slug() != slug {
+		err = RenameRecord(slug, &rec)
+		if err == nil {
+			views.rename(slug, rec.Slug())
+		}
+	} else {
+		err = rec.Save()
+	}
+	if errors.Is(err, ErrSlugExists) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		errorf("unable to save %s: %v", slug, err)
+		writeJSONError(w, http.StatusInternalServerError, "unable to save the record")
+		return
+	}
+	announce(baseURL(r), old, &rec)
+	writeJSON(w, http.StatusOK, apiRecord{Slug: rec.Slug(), Record: &rec})
+}
+
+func publishHandler(w http.ResponseWriter, r *http.Request) {
+	setPublished(w, r, true)
+}
+
+func unpublishHandler(w http.ResponseWriter, r *http.Request) {
+	setPublished(w, r, false)
+}
+
+func setPublished(w http.ResponseWriter, r *http.Request, published bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	rec, err := LoadRecord(getAPISlug(r))
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "record not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed(r, actionEdit, rec) {
+		forbiddenJSON(w, "edit this record")
+		return
+	}
+
+	if rec.Published == published {
+		state := "unpublished"
+		if published {
+			state = "published"
+		}
+		writeJSONError(w, http.StatusConflict, "record is already "+state)
+		return
+	}
+
+	old := *rec
+	rec.Published = published
+	if err := rec.Save(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	announce(baseURL(r), &old, rec)
+	writeJSON(w, http.StatusOK, apiRecord{Slug: rec.Slug(), Record: rec})
+}
+
+// tagsRequest adds and removes individual tags without touching the rest of
+// the record.
+type tagsRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("tags must not be empty")
+		}
+		if utf8.RuneCountInString(tag) > maxTagLen {
+			return fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLen)
+		}
+	}
+	return nil
+}
+
+// applyTags returns tags with add appended (skipping ones already present)
+// and everything in remove taken out.
+func applyTags(tags, add, remove []string) []string {
+	removed := make(map[string]bool, len(remove))
+	for _, tag := range remove {
+		removed[strings.TrimSpace(tag)] = true
+	}
+
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(tags)+len(add))
+	for _, tag := range append(tags, add...) {
+		tag = strings.TrimSpace(tag)
+		if removed[tag] || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		w.Header().Set("Allow", http.MethodPatch)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "expected an application/json body")
+		return
+	}
+
+	var req tagsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPostSize)).Decode(&req); err != nil {
+		writeBodyError(w, err, "invalid JSON body")
+		return
+	}
+	if err := validateTags(append(req.Add, req.Remove...)); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	rec, err := LoadRecord(getAPISlug(r))
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "record not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed(r, actionEdit, rec) {
+		forbiddenJSON(w, "edit this record")
+		return
+	}
+
+	rec.Tags = applyTags(rec.Tags, req.Add, req.Remove)
+	if err := rec.Save(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, apiRecord{Slug: rec.Slug(), Record: rec})
+}
+
+// bulkDeleteResult reports what happened to one slug of a bulk delete.
+type bulkDeleteResult struct {
+	Slug    string `json:"slug"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+func bulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "expected an application/json body")
+		return
+	}
+
+	var slugs []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPostSize)).Decode(&slugs); err != nil {
+		writeBodyError(w, err, "expected a JSON array of slugs")
+		return
+	}
+
+	// keep going past failures so the caller learns about all of them
+	results := make([]bulkDeleteResult, 0, len(slugs))
+	for _, slug := range slugs {
+		res := bulkDeleteResult{Slug: slug}
+		if !validSlug.MatchString(slug) {
+			res.Error = "invalid slug"
+		} else if rec, err := LoadRecord(slug); err == nil && !allowed(r, actionDelete, rec) {
+			res.Error = "not allowed to delete this record"
+		} else if err := DeleteRecord(slug); errors.Is(err, ErrNotFound) {
+			res.Error = "record not found"
+		} else if err != nil {
+			errorf("bulk delete of %s failed: %v", slug, err)
+			res.Error = "unable to delete record"
+		} else {
+			res.Deleted = true
+			if err := deleteComments(slug); err != nil {
+				errorf("unable to delete the comments on %s: %v", slug, err)
+			}
+			if err := deleteTranslations(slug); err != nil {
+				errorf("unable to delete the translations of %s: %v", slug, err)
+			}
+			if err := deleteRevisions(slug); err != nil {
+				errorf("unable to delete the history of %s: %v", slug, err)
+			}
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, http.StatusOK, map[string][]bulkDeleteResult{"results": results})
+}
+
+// recordList is one page of GET /api/records.
+type recordList struct {
+	Records []apiRecord `json:"records"`
+	// NextCursor is passed back as ?after= for the next page, and is empty
+	// on the last one
+	NextCursor string `json:"next_cursor"`
+}
+
+// listRecordsHandler pages through the published records in slug order.
+// The cursor is the last slug already seen, so only the files after it are
+// read, however deep into the list the client is. With ?order=newest the
+// records come newest first instead, for infinite scroll, and the cursor is
+// a timeCursor.
+func listRecordsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+			return
+		}
+		limit = n
+	}
+	after := q.Get("after")
+
+	var page []apiRecord
+	var next string
+	var err error
+	switch q.Get("order") {
+	case "":
+		if after != "" && !validSlug.MatchString(after) {
+			writeJSONError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		page, next, err = listRecordsAfter(after, limit)
+	case "newest":
+		var c timeCursor
+		if after != "" {
+			if c, err = parseTimeCursor(after); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid cursor")
+				return
+			}
+		}
+		page, next, err = listNewestAfter(c, limit)
+	default:
+		writeJSONError(w, http.StatusBadRequest, `order must be "newest" or left out`)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "unable to list records")
+		errorf("unable to list records: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recordList{Records: page, NextCursor: next})
+}
+
+// apiListed reports whether rec belongs in the records listing, which
+// -public-api may open to anyone: only public, published posts without a
+// passphrase.
+func apiListed(rec *Record) bool {
+	return rec.Published && rec.Listed() && !rec.Protected()
+}
+
+// listRecordsAfter returns up to limit published records whose slugs sort
+// after the cursor, and the cursor for the page after that.
+func listRecordsAfter(after string, limit int) ([]apiRecord, string, error) {
+	// ReadDir returns the entries sorted by name, which is slug order
+	entries, err := os.ReadDir(recordsDir)
+	if os.IsNotExist(err) {
+		return []apiRecord{}, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]apiRecord, 0, limit)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !isRecordFile(name) {
+			continue
+		}
+		slug := strings.TrimSuffix(name, ".json")
+		if slug <= after {
+			continue
+		}
+		rec, err := LoadRecord(slug)
+		if err != nil {
+			warnf("skipping record %s: %v", name, err)
+			continue
+		}
+		if !apiListed(rec) {
+			continue
+		}
+		if len(page) == limit {
+			// there is at least one more record, so another page follows
+			return page, page[limit-1].Slug, nil
+		}
+		page = append(page, apiRecord{Slug: slug, Record: rec})
+	}
+	return page, "", nil
+}
+
+// timeCursor is where a newest-first listing left off: the creation time
+// and slug of the last record seen. Unlike an offset it stays put when new
+// posts are added at the top.
+type timeCursor struct {
+	created time.Time
+	slug    string
+}
+
+func (c timeCursor) String() string {
+	raw := strconv.FormatInt(c.created.UnixNano(), 10) + ":" + c.slug
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseTimeCursor(s string) (timeCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return timeCursor{}, err
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || !validSlug.MatchString(parts[1]) {
+		return timeCursor{}, errors.New("malformed cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return timeCursor{}, err
+	}
+	return timeCursor{created: time.Unix(0, nanos), slug: parts[1]}, nil
+}
+
+// before reports whether rec comes after the cursor in newest-first order,
+// where records created at the same moment are in slug order.
+func (c timeCursor) before(rec *Record) bool {
+	if c.slug == "" {
+		return true
+	}
+	if !rec.CreatedAt.Equal(c.created) {
+		return rec.CreatedAt.Before(c.created)
+	}
+	return rec.Slug() > c.slug
+}
+
+// listNewestAfter returns up to limit published records, newest first, that
+// follow the cursor, and the cursor for the page after that.
+func listNewestAfter(after timeCursor, limit int) ([]apiRecord, string, error) {
+	records, err := publishedRecords()
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].CreatedAt.After(records[j].CreatedAt)
+		}
+		return records[i].Slug() < records[j].Slug()
+	})
+
+	page := make([]apiRecord, 0, limit)
+	for _, rec := range records {
+		if !apiListed(rec) || !after.before(rec) {
+			continue
+		}
+		if len(page) == limit {
+			last := page[limit-1]
+			return page, timeCursor{created: last.CreatedAt, slug: last.Slug}.String(), nil
+		}
+		page = append(page, apiRecord{Slug: rec.Slug(), Record: rec})
+	}
+	return page, "", nil
+}
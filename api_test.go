@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useTestAuth(t *testing.T) {
+	t.Helper()
+	oldUser, oldPass := authUser, authPass
+	oldBasic := basicAuths
+	authUser, authPass = "admin", "secret"
+	basicAuths = newBasicAuthCache(time.Hour)
+	t.Cleanup(func() { authUser, authPass, basicAuths = oldUser, oldPass, oldBasic })
+}
+
+func authRequest(method, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.SetBasicAuth("admin", "secret")
+	return r
+}
+
+// basicLogin has a guard accept user's Basic Auth credentials, as when a
+// browser answers its 401, so pages that don't require them recognise them.
+func basicLogin(t *testing.T, user, pass string) {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/admin", nil)
+	r.SetBasicAuth(user, pass)
+	w := httptest.NewRecorder()
+	requireAuth(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %s to log in, got %d", user, w.Code)
+	}
+}
+
+// useTestAPIKey issues an API key in the test's records directory.
+func useTestAPIKey(t *testing.T) string {
+	t.Helper()
+	key, _, err := createAPIKey("admin", "tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// keyRequest is authRequest for the JSON API, which only takes API keys.
+func keyRequest(method, target, key string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Authorization", "Bearer "+key)
+	return r
+}
+
+func TestPublishEndpoints(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	key := useTestAPIKey(t)
+
+	if err := (&Record{Title: "Toggle Me", Published: false}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name      string
+		r         *http.Request
+		code      int
+		published bool
+	}{
+		{"publish draft", keyRequest("POST", "/api/records/toggle-me/publish", key, nil), http.StatusOK, true},
+		{"publish again", keyRequest("POST", "/api/records/toggle-me/publish", key, nil), http.StatusConflict, true},
+		{"unpublish", keyRequest("POST", "/api/records/toggle-me/unpublish", key, nil), http.StatusOK, false},
+		{"unpublish again", keyRequest("POST", "/api/records/toggle-me/unpublish", key, nil), http.StatusConflict, false},
+		{"missing record", keyRequest("POST", "/api/records/nope/publish", key, nil), http.StatusNotFound, false},
+		{"wrong method", keyRequest("GET", "/api/records/toggle-me/publish", key, nil), http.StatusMethodNotAllowed, false},
+		{"no auth", httptest.NewRequest("POST", "/api/records/toggle-me/publish", nil), http.StatusUnauthorized, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			apiRecordsHandler(w, tc.r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var got apiRecord
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Slug != "toggle-me" || got.Published != tc.published {
+				t.Fatalf("unexpected response %+v", got)
+			}
+			rec, err := LoadRecord("toggle-me")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Published != tc.published {
+				t.Fatalf("expected stored published=%v", tc.published)
+			}
+		})
+	}
+}
+
+func TestApplyTags(t *testing.T) {
+	tt := []struct {
+		name   string
+		tags   []string
+		add    []string
+		remove []string
+		want   []string
+	}{
+		{"add to empty", nil, []string{"go", "web"}, nil, []string{"go", "web"}},
+		{"add existing", []string{"go"}, []string{"go", "web"}, nil, []string{"go", "web"}},
+		{"remove", []string{"go", "tutorial"}, nil, []string{"tutorial"}, []string{"go"}},
+		{"remove missing", []string{"go"}, nil, []string{"rust"}, []string{"go"}},
+		{"add and remove", []string{"tutorial"}, []string{"go", "web"}, []string{"tutorial"}, []string{"go", "web"}},
+		{"trims", nil, []string{" go "}, nil, []string{"go"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := applyTags(tc.tags, tc.add, tc.remove)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTagsEndpoint(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	key := useTestAPIKey(t)
+
+	rec := &Record{Title: "Tagged", Content: "body", Published: true, Tags: []string{"tutorial"}}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name   string
+		method string
+		slug   string
+		body   string
+		ct     string
+		code   int
+	}{
+		{"add and remove", "PATCH", "tagged", `{"add":["go","web"],"remove":["tutorial"]}`, "application/json", http.StatusOK},
+		{"empty tag", "PATCH", "tagged", `{"add":[" "]}`, "application/json", http.StatusUnprocessableEntity},
+		{"long tag", "PATCH", "tagged", `{"add":["` + strings.Repeat("a", maxTagLen+1) + `"]}`, "application/json", http.StatusUnprocessableEntity},
+		{"not a string", "PATCH", "tagged", `{"add":[1]}`, "application/json", http.StatusBadRequest},
+		{"missing record", "PATCH", "nope", `{"add":["go"]}`, "application/json", http.StatusNotFound},
+		{"wrong method", "PUT", "tagged", `{"add":["go"]}`, "application/json", http.StatusMethodNotAllowed},
+		{"not JSON", "PATCH", "tagged", `{"add":["go"]}`, "text/plain", http.StatusUnsupportedMediaType},
+		{"too large", "PATCH", "tagged", `{"add":["` + strings.Repeat("a", int(maxPostSize)) + `"]}`, "application/json", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := keyRequest(tc.method, "/api/records/"+tc.slug+"/tags", key, strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", tc.ct)
+			w := httptest.NewRecorder()
+			apiRecordsHandler(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+		})
+	}
+
+	got, err := LoadRecord("tagged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got.Tags, ",") != "go,web" || got.Content != "body" || !got.Published {
+		t.Fatalf("unexpected record after tag update: %+v", got)
+	}
+}
+
+func TestBulkDeleteHandler(t *testing.T) {
+	useTempRecords(t)
+
+	for _, title := range []string{"Spam One", "Spam Two", "Keeper"} {
+		if err := (&Record{Title: title}).Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body := `["spam-one", "missing", "../keeper", "spam-two"]`
+	r := httptest.NewRequest("POST", "/api/records/delete", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	bulkDeleteHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+
+	var got struct {
+		Results []bulkDeleteResult `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []bulkDeleteResult{
+		{Slug: "spam-one", Deleted: true},
+		{Slug: "missing", Error: "record not found"},
+		{Slug: "../keeper", Error: "invalid slug"},
+		{Slug: "spam-two", Deleted: true},
+	}
+	if len(got.Results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), got.Results)
+	}
+	for i := range want {
+		if got.Results[i] != want[i] {
+			t.Fatalf("result %d: expected %+v, got %+v", i, want[i], got.Results[i])
+		}
+	}
+
+	records, err := AllRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Title != "Keeper" {
+		t.Fatalf("expected only Keeper to remain, got %v", records)
+	}
+}
+
+func TestBulkDeleteHandlerRejects(t *testing.T) {
+	tt := []struct {
+		name   string
+		method string
+		body   string
+		ct     string
+		code   int
+	}{
+		{"get", "GET", "", "", http.StatusMethodNotAllowed},
+		{"not an array", "POST", `{"slug":"x"}`, "application/json", http.StatusBadRequest},
+		{"not JSON", "POST", `["x"]`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"too large", "POST", `["` + strings.Repeat("x", int(maxPostSize)) + `"]`, "application/json", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/api/records/delete", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", tc.ct)
+			w := httptest.NewRecorder()
+			bulkDeleteHandler(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+		})
+	}
+}
+
+func TestListRecordsCursor(t *testing.T) {
+	useTempRecords(t)
+	for _, title := range []string{"Alpha", "Bravo", "Charlie", "Delta", "Echo", "Foxtrot"} {
+		if err := (&Record{Title: title, Published: title != "Charlie" && title != "Foxtrot"}).Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(target string) recordList {
+		t.Helper()
+		w := httptest.NewRecorder()
+		listRecordsHandler(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, w.Code, w.Body)
+		}
+		var l recordList
+		if err := json.Unmarshal(w.Body.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	slugs := func(l recordList) string {
+		s := make([]string, 0, len(l.Records))
+		for _, r := range l.Records {
+			s = append(s, r.Slug)
+		}
+		return strings.Join(s, ",")
+	}
+
+	// the drafts are skipped, and the cursor picks up where the page ended;
+	// a trailing draft doesn't leave an empty last page
+	first := list("/api/records?limit=2")
+	if slugs(first) != "alpha,bravo" || first.NextCursor != "bravo" {
+		t.Fatalf("unexpected first page: %s next=%q", slugs(first), first.NextCursor)
+	}
+	second := list("/api/records?limit=2&after=" + first.NextCursor)
+	if slugs(second) != "delta,echo" || second.NextCursor != "" {
+		t.Fatalf("unexpected second page: %s next=%q", slugs(second), second.NextCursor)
+	}
+	if all := list("/api/records"); slugs(all) != "alpha,bravo,delta,echo" || all.NextCursor != "" {
+		t.Fatalf("unexpected default page: %s next=%q", slugs(all), all.NextCursor)
+	}
+	if past := list("/api/records?after=zulu"); len(past.Records) != 0 || past.NextCursor != "" {
+		t.Fatalf("expected an empty page past the end, got %s", slugs(past))
+	}
+
+	for _, target := range []string{"/api/records?limit=0", "/api/records?limit=abc", "/api/records?limit=1000", "/api/records?after=../x"} {
+		w := httptest.NewRecorder()
+		listRecordsHandler(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestListRecordsNewestCursor(t *testing.T) {
+	dir := useTempRecords(t)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeRecordAt(t, dir, &Record{Title: "Oldest", Published: true}, start)
+	writeRecordAt(t, dir, &Record{Title: "Bravo", Published: true}, start.Add(time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Alpha", Published: true}, start.Add(time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Draft"}, start.Add(2*time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Newest", Published: true}, start.Add(3*time.Hour))
+
+	list := func(target string) recordList {
+		t.Helper()
+		w := httptest.NewRecorder()
+		listRecordsHandler(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, w.Code, w.Body)
+		}
+		var l recordList
+		if err := json.Unmarshal(w.Body.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	slugs := func(l recordList) string {
+		s := make([]string, 0, len(l.Records))
+		for _, r := range l.Records {
+			s = append(s, r.Slug)
+		}
+		return strings.Join(s, ",")
+	}
+
+	first := list("/api/records?order=newest&limit=2")
+	if slugs(first) != "newest,alpha" || first.NextCursor == "" {
+		t.Fatalf("unexpected first page: %s next=%q", slugs(first), first.NextCursor)
+	}
+
+	// a post added at the top doesn't shift the next page
+	writeRecordAt(t, dir, &Record{Title: "Breaking", Published: true}, start.Add(4*time.Hour))
+	second := list("/api/records?order=newest&limit=2&after=" + first.NextCursor)
+	if slugs(second) != "bravo,oldest" || second.NextCursor != "" {
+		t.Fatalf("unexpected second page: %s next=%q", slugs(second), second.NextCursor)
+	}
+
+	for _, target := range []string{
+		"/api/records?order=newest&after=bravo",
+		"/api/records?order=newest&after=" + base64.RawURLEncoding.EncodeToString([]byte("soon:bravo")),
+		"/api/records?order=newest&after=" + base64.RawURLEncoding.EncodeToString([]byte("1:../x")),
+		"/api/records?order=oldest",
+	} {
+		w := httptest.NewRecorder()
+		listRecordsHandler(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestListRecordsHidesRestricted(t *testing.T) {
+	useTempRecords(t)
+	old := publicAPIReads
+	publicAPIReads = true
+	t.Cleanup(func() { publicAPIReads = old })
+	for _, rec := range []*Record{
+		{Title: "Public", Published: true},
+		{Title: "Private", Published: true, Visibility: visibilityPrivate},
+		{Title: "Unlisted", Published: true, Visibility: visibilityUnlisted},
+		{Title: "Protected", Published: true, AccessPassword: "open sesame"},
+		{Title: "Draft"},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := requireAPIReadKey(listRecordsHandler)
+	for _, target := range []string{"/api/records", "/api/records?order=newest"} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, w.Code, w.Body)
+		}
+		var l recordList
+		if err := json.Unmarshal(w.Body.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		if len(l.Records) != 1 || l.Records[0].Slug != "public" {
+			t.Errorf("%s: expected only the public post, got %s", target, w.Body)
+		}
+	}
+}
+
+func TestCreateRecordJSON(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	key := useTestAPIKey(t)
+	h := readWrite(listRecordsHandler, requireAPIKey(createRecordHandler))
+
+	post := func(ct, body string) *http.Request {
+		r := keyRequest("POST", "/api/records", key, strings.NewReader(body))
+		r.Header.Set("Content-Type", ct)
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	h(w, post("application/json", `{"title": "From JSON", "content": "hello", "tags": ["go"]}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+	if loc := w.Header().Get("Location"); loc != "/show/from-json" {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+	var got apiRecord
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Slug != "from-json" || got.Content != "hello" || !got.Published {
+		t.Fatalf("unexpected record %+v", got)
+	}
+	if _, err := LoadRecord("from-json"); err != nil {
+		t.Fatalf("expected the record to be saved: %v", err)
+	}
+
+	tt := []struct {
+		name string
+		r    *http.Request
+		code int
+		msg  string
+	}{
+		{"no title", post("application/json", `{"content": "x"}`), http.StatusUnprocessableEntity, `"errors"`},
+		{"empty tag", post("application/json", `{"title": "Tagged", "tags": [""]}`), http.StatusUnprocessableEntity, `"errors"`},
+		{"duplicate", post("application/json", `{"title": "From JSON"}`), http.StatusConflict, "exists"},
+		{"bad JSON", post("application/json", `{"title": `), http.StatusBadRequest, "invalid JSON"},
+		{"form body", post("application/x-www-form-urlencoded", "title=x"), http.StatusUnsupportedMediaType, "application/json"},
+		{"no auth", httptest.NewRequest("POST", "/api/records", strings.NewReader(`{"title": "Anon"}`)), http.StatusUnauthorized, ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h(w, tc.r)
+			if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.msg) {
+				t.Fatalf("expected %d with %q, got %d: %s", tc.code, tc.msg, w.Code, w.Body)
+			}
+		})
+	}
+
+	w = httptest.NewRecorder()
+	h(w, keyRequest("GET", "/api/records", key, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "from-json") {
+		t.Fatalf("expected GET to still list records, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestPatchRecord(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	rec := &Record{Title: "Patch Me", Content: "original", Tags: []string{"go"}, Published: true}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := LoadRecord("patch-me")
+	key := useTestAPIKey(t)
+
+	patch := func(slug, body string) *httptest.ResponseRecorder {
+		r := keyRequest("PATCH", "/api/records/"+slug, key, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		apiRecordsHandler(w, r)
+		return w
+	}
+
+	w := patch("patch-me", `{"tags": []}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var got apiRecord
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tags) != 0 || got.Content != "original" || !got.Published || !got.UpdatedAt.After(before.UpdatedAt) {
+		t.Fatalf("expected only the tags cleared and UpdatedAt bumped, got %+v", got)
+	}
+
+	if w := patch("patch-me", `{"published": false, "content": null}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	saved, _ := LoadRecord("patch-me")
+	if saved.Published || saved.Content != "original" {
+		t.Fatalf("expected a draft with its content kept, got %+v", saved)
+	}
+
+	if w := patch("patch-me", `{"title": "Patched"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"slug":"patched"`) {
+		t.Fatalf("expected the new title to move the record, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := LoadRecord("patch-me"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the old slug gone, got %v", err)
+	}
+	if err := (&Record{Title: "Taken"}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name string
+		slug string
+		body string
+		code int
+	}{
+		{"unknown field", "patched", `{"publised": true}`, http.StatusBadRequest},
+		{"bad JSON", "patched", `{`, http.StatusBadRequest},
+		{"empty title", "patched", `{"title": " "}`, http.StatusUnprocessableEntity},
+		{"empty tag", "patched", `{"tags": [""]}`, http.StatusUnprocessableEntity},
+		{"slug taken", "patched", `{"title": "Taken"}`, http.StatusConflict},
+		{"missing record", "nope", `{}`, http.StatusNotFound},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := patch(tc.slug, tc.body); w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+		})
+	}
+
+	w = httptest.NewRecorder()
+	apiRecordsHandler(w, keyRequest("GET", "/api/records/patched", key, nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPatch {
+		t.Fatalf("expected 405 allowing PATCH, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+}
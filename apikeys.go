@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// apiKeyPrefix marks the keys this blog issues, so a leaked one is easy to
+// recognise.
+const apiKeyPrefix = "blog_"
+
+// publicAPIReads lets anyone use the read-only API endpoints without a key.
+var publicAPIReads bool
+
+// APIKey is an issued key. Only the SHA-256 of the key is kept; the key
+// itself is shown once, when it is created. A key acts for User, with that
+// account's role; keys from before keys had users act for the user from
+// the flags or environment.
+type APIKey struct {
+	ID       string    `json:"id"`
+	Label    string    `json:"label"`
+	User     string    `json:"user,omitempty"`
+	Hash     string    `json:"hash"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+}
+
+// actsFor is the user the key acts for.
+func (k *APIKey) actsFor() string {
+	if k.User == "" {
+		return authUser
+	}
+	return k.User
+}
+
+// apiKeyMu serialises the read-modify-write of the keys file.
+var apiKeyMu sync.Mutex
+
+// apiKeysFile lives next to the records; the leading dot keeps AllRecords
+// from loading it as a post.
+func apiKeysFile() string {
+	return filepath.Join(recordsDir, ".apikeys.json")
+}
+
+func loadAPIKeys() ([]*APIKey, error) {
+	keys := make([]*APIKey, 0)
+	data, err := ioutil.ReadFile(apiKeysFile())
+	if os.IsNotExist(err) {
+		return keys, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func saveAPIKeys(keys []*APIKey) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(apiKeysFile(), data, 0600)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIKey issues a new key acting for user with the given label,
+// returning the key itself, which is not stored anywhere.
+func createAPIKey(user, label string) (string, *APIKey, error) {
+	user, label = strings.TrimSpace(user), strings.TrimSpace(label)
+	if user == "" {
+		return "", nil, errors.New("an API key needs a user to act for")
+	}
+	if label == "" {
+		return "", nil, errors.New("an API key needs a label")
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + secret
+
+	apiKeyMu.Lock()
+	defer apiKeyMu.Unlock()
+	keys, err := loadAPIKeys()
+	if err != nil {
+		return "", nil, err
+	}
+	k := &APIKey{ID: secret[:12], Label: label, User: user, Hash: hashAPIKey(key), Created: time.Now()}
+	if err := saveAPIKeys(append(keys, k)); err != nil {
+		return "", nil, err
+	}
+	return key, k, nil
+}
+
+// revokeAPIKey deletes the key with the given ID.
+func revokeAPIKey(id string) error {
+	apiKeyMu.Lock()
+	defer apiKeyMu.Unlock()
+	keys, err := loadAPIKeys()
+	if err != nil {
+		return err
+	}
+	for i, k := range keys {
+		if k.ID == id {
+			return saveAPIKeys(append(keys[:i], keys[i+1:]...))
+		}
+	}
+	return fmt.Errorf("%w: API key %s", ErrNotFound, id)
+}
+
+// apiKeyTouchEvery is how stale a key's LastUsed may get before using the
+// key saves it again, so a busy client doesn't rewrite the keys file on
+// every request.
+const apiKeyTouchEvery = time.Minute
+
+// useAPIKey reports whether key is a current key, and if so records that it
+// was just used. Every stored hash is compared, in constant time, so how
+// long the lookup takes gives nothing away.
+func useAPIKey(key string) (*APIKey, error) {
+	hash := []byte(hashAPIKey(key))
+
+	apiKeyMu.Lock()
+	defer apiKeyMu.Unlock()
+	keys, err := loadAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	var found *APIKey
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare(hash, []byte(k.Hash)) == 1 {
+			found = k
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	now := time.Now()
+	if now.Sub(found.LastUsed) < apiKeyTouchEvery {
+		return found, nil
+	}
+	found.LastUsed = now
+	if err := saveAPIKeys(keys); err != nil {
+		errorf("unable to record use of API key %s: %v", found.ID, err)
+	}
+	return found, nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+// requireAPIKey guards a JSON API handler: the request needs a valid key in
+// an Authorization: Bearer header. Session cookies aren't accepted, since
+// the API has no CSRF protection, and neither are passwords, which belong
+// to people rather than to the scripts calling the API.
+func requireAPIKey(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := bearerToken(r); ok {
+			k, err := useAPIKey(key)
+			if err != nil {
+				errorf("unable to check API key: %v", err)
+				writeJSONError(w, http.StatusInternalServerError, "unable to check API key")
+				return
+			}
+			if k != nil {
+				h(w, withAPIKey(r, k))
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="blog"`)
+		writeJSONError(w, http.StatusUnauthorized, "a valid API key is required")
+	}
+}
+
+// requireAPIReadKey is requireAPIKey for read-only endpoints, which
+// -public-api leaves open to everyone.
+func requireAPIReadKey(h http.HandlerFunc) http.HandlerFunc {
+	guarded := requireAPIKey(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if publicAPIReads {
+			h(w, r)
+			return
+		}
+		guarded(w, r)
+	}
+}
+
+// runAPIKeyCommand runs "blog apikey ...", which manages the API keys from
+// the command line.
+func runAPIKeyCommand(args []string, out io.Writer) error {
+	usage := errors.New("usage: apikey create <user> <label> | apikey revoke <id> | apikey list")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "create":
+		if len(args) < 3 {
+			return usage
+		}
+		key, k, err := createAPIKey(args[1], strings.Join(args[2:], " "))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "created key %s (%s) for %s; it won't be shown again:\n%s\n", k.ID, k.Label, k.User, key)
+	case "revoke":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := revokeAPIKey(args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "revoked key %s\n", args[1])
+	case "list":
+		keys, err := loadAPIKeys()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tLABEL\tUSER\tCREATED\tLAST USED")
+		for _, k := range keys {
+			used := "never"
+			if !k.LastUsed.IsZero() {
+				used = k.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Label, k.actsFor(), k.Created.Format(time.RFC3339), used)
+		}
+		return tw.Flush()
+	default:
+		return usage
+	}
+	return nil
+}
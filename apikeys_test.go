@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIKeys(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	useTestSessions(t)
+	if err := CreateRecord(&Record{Title: "Keyed"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	key, k, err := createAPIKey("admin", "deploy script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(apiKeysFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), key) {
+		t.Fatal("the keys file must only hold hashes")
+	}
+
+	session := sessionCookieFrom(t, login(t, "/"))
+	call := func(action, auth string, c *http.Cookie) int {
+		r := httptest.NewRequest("POST", "/api/records/keyed/"+action, nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		if c != nil {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		apiRecordsHandler(w, r)
+		return w.Code
+	}
+
+	tt := []struct {
+		name   string
+		action string
+		auth   string
+		cookie *http.Cookie
+		code   int
+	}{
+		{"valid key", "publish", "Bearer " + key, nil, http.StatusOK},
+		{"lower case scheme", "unpublish", "bearer " + key, nil, http.StatusOK},
+		{"wrong key", "publish", "Bearer " + key + "x", nil, http.StatusUnauthorized},
+		{"empty key", "publish", "Bearer ", nil, http.StatusUnauthorized},
+		{"session only", "publish", "", session, http.StatusUnauthorized},
+		{"password", "publish", "Basic YWRtaW46c2VjcmV0", nil, http.StatusUnauthorized}, // admin:secret
+		{"nothing", "publish", "", nil, http.StatusUnauthorized},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := call(tc.action, tc.auth, tc.cookie); code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, code)
+			}
+		})
+	}
+
+	keys, err := loadAPIKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID != k.ID || keys[0].LastUsed.IsZero() {
+		t.Fatalf("expected the key's last use to be recorded, got %+v", keys)
+	}
+
+	// keys from before keys had users act for the configured user
+	if got := (&APIKey{ID: "legacy"}).actsFor(); got != "admin" {
+		t.Fatalf("expected a key without a user to act for admin, got %q", got)
+	}
+
+	if err := revokeAPIKey(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := call("publish", "Bearer "+key, nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked key to be refused, got %d", code)
+	}
+	if err := revokeAPIKey(k.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound revoking twice, got %v", err)
+	}
+}
+
+func TestRequireAPIReadKey(t *testing.T) {
+	useTempRecords(t)
+	h := requireAPIReadKey(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, public := range []bool{false, true} {
+		old := publicAPIReads
+		publicAPIReads = public
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", "/api/records", nil))
+		publicAPIReads = old
+
+		want := http.StatusUnauthorized
+		if public {
+			want = http.StatusOK
+		}
+		if w.Code != want {
+			t.Errorf("public=%v: expected %d, got %d", public, want, w.Code)
+		}
+	}
+}
+
+func TestAPIKeyCommand(t *testing.T) {
+	useTempRecords(t)
+
+	var out bytes.Buffer
+	if err := runAPIKeyCommand([]string{"create", "admin", "ci", "bot"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), apiKeyPrefix) {
+		t.Fatalf("expected the new key to be printed, got %q", out.String())
+	}
+	keys, err := loadAPIKeys()
+	if err != nil || len(keys) != 1 || keys[0].Label != "ci bot" || keys[0].User != "admin" {
+		t.Fatalf("expected one key for admin labelled \"ci bot\", got %+v %v", keys, err)
+	}
+
+	out.Reset()
+	if err := runAPIKeyCommand([]string{"list"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), keys[0].ID) || !strings.Contains(out.String(), "never") {
+		t.Fatalf("expected the key in the listing, got %q", out.String())
+	}
+
+	if err := runAPIKeyCommand([]string{"revoke", keys[0].ID}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{nil, {"create"}, {"create", "admin"}, {"create", " ", "ci"}, {"create", "admin", " "}, {"rotate"}} {
+		if err := runAPIKeyCommand(args, &out); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func TestAPIKeyLastUsedIsThrottled(t *testing.T) {
+	useTempRecords(t)
+	key, _, err := createAPIKey("admin", "busy script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastUsed := func() time.Time {
+		t.Helper()
+		keys, err := loadAPIKeys()
+		if err != nil || len(keys) != 1 {
+			t.Fatalf("unable to load the key: %v", err)
+		}
+		return keys[0].LastUsed
+	}
+
+	if _, err := useAPIKey(key); err != nil {
+		t.Fatal(err)
+	}
+	first := lastUsed()
+	if first.IsZero() {
+		t.Fatal("expected the first use to be recorded")
+	}
+	if _, err := useAPIKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if !lastUsed().Equal(first) {
+		t.Fatal("expected a use within a minute not to rewrite the keys file")
+	}
+
+	keys, _ := loadAPIKeys()
+	keys[0].LastUsed = first.Add(-2 * apiKeyTouchEvery)
+	if err := saveAPIKeys(keys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := useAPIKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if !lastUsed().After(first.Add(-apiKeyTouchEvery)) {
+		t.Fatal("expected a stale last use to be brought up to date")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// LoadRecords reads a file holding a JSON array of records, such as a dump
+// exported from another blog. Every record is checked like a record file
+// would be; the first bad one fails the whole load, naming its position.
+func LoadRecords(path string) ([]*Record, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("%s: expected an array of records: %w", path, err)
+	}
+	records := make([]*Record, 0, len(items))
+	for i, item := range items {
+		r, err := parseRecordFile(item)
+		if err != nil {
+			if ve, ok := err.(*ValidationError); ok {
+				ve.File = fmt.Sprintf("%s[%d]", path, i)
+			}
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// runImportCommand runs "blog import <file>", which saves every record in
+// an array file as a record file of its own. Records whose slug is already
+// taken are skipped rather than overwritten.
+func runImportCommand(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: import <file>")
+	}
+	records, err := LoadRecords(args[0])
+	if err != nil {
+		return err
+	}
+	imported := 0
+	for _, r := range records {
+		err := CreateRecord(r, false)
+		if errors.Is(err, ErrSlugExists) {
+			fmt.Fprintf(out, "skipped %s: it already exists\n", r.Slug())
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", r.Slug(), err)
+		}
+		imported++
+	}
+	fmt.Fprintf(out, "imported %d of %d records\n", imported, len(records))
+	return nil
+}
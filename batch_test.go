@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRecordsRoundTrip(t *testing.T) {
+	dir := useTempRecords(t)
+	dump := filepath.Join(t.TempDir(), "dump.json")
+	err := ioutil.WriteFile(dump, []byte(`[
+		{"title": "First Post", "content": "hello", "created_at": "2020-01-02T03:04:05Z", "tags": ["go"]},
+		{"title": "Second Post", "content": "draft", "published": false, "slug": "second"}
+	]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := LoadRecords(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !records[0].Published || records[1].Published || records[1].Slug() != "second" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	var out bytes.Buffer
+	if err := runImportCommand([]string{dump}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "imported 2 of 2") {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("expected a file per record, got %d", len(files))
+	}
+	first, err := LoadRecord("first-post")
+	if err != nil || first.Content != "hello" || !first.CreatedAt.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) || first.Tags[0] != "go" {
+		t.Fatalf("first post didn't survive the import: %+v, %v", first, err)
+	}
+	if second, err := LoadRecord("second"); err != nil || second.Title != "Second Post" || second.Published {
+		t.Fatalf("second post didn't survive the import: %+v, %v", second, err)
+	}
+
+	// importing again leaves the records alone
+	out.Reset()
+	if err := runImportCommand([]string{dump}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "skipped first-post") || !strings.Contains(out.String(), "imported 0 of 2") {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+}
+
+func TestLoadRecordsInvalid(t *testing.T) {
+	tt := []struct {
+		name, data, want string
+	}{
+		{"not an array", `{"title": "One"}`, "expected an array"},
+		{"bad record", `[{"title": "Fine"}, {"title": ""}]`, "[1]"},
+		{"wrong type", `[{"title": "Fine", "published": "yes"}]`, "[0]"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dump.json")
+			if err := ioutil.WriteFile(path, []byte(tc.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadRecords(path); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected an error mentioning %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
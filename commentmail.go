@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// commentNotifyTo is the address mailed about new comments, if any.
+var commentNotifyTo = envOr("BLOG_COMMENT_NOTIFY_TO", "")
+
+// moderationLinkMaxAge is how long the links in a notification work.
+const moderationLinkMaxAge = 7 * 24 * time.Hour
+
+// moderationToken lets whoever holds it take action ("approve" or "reject")
+// on the comment id on slug until expires. It is only honoured while the
+// comment is pending, so it can be used once.
+func moderationToken(slug, id, action string, expires time.Time) string {
+	return signValue(strings.Join([]string{"moderate", slug, id, action, strconv.FormatInt(expires.Unix(), 10)}, " "))
+}
+
+// checkModerationToken reports whether token allows action on the comment
+// id on slug at now.
+func checkModerationToken(token, slug, id, action string, now time.Time) bool {
+	v, err := verifyValue(token)
+	if err != nil {
+		return false
+	}
+	prefix := strings.Join([]string{"moderate", slug, id, action}, " ") + " "
+	if !strings.HasPrefix(v, prefix) {
+		return false
+	}
+	expires, err := strconv.ParseInt(strings.TrimPrefix(v, prefix), 10, 64)
+	return err == nil && now.Before(time.Unix(expires, 0))
+}
+
+// moderationURL is the link in a notification taking action on c.
+func moderationURL(base, slug string, c *Comment, action string, now time.Time) string {
+	token := moderationToken(slug, c.ID, action, now.Add(moderationLinkMaxAge))
+	return base + "/admin/comments/" + slug + "/" + c.ID + "/" + action + "?token=" + url.QueryEscape(token)
+}
+
+var commentMailTemplate = template.Must(template.New("comment-mail").Parse(`{{ .Comment.Author }} commented on "{{ .Record.Title }}":
+
+{{ .Comment.Body }}
+
+{{ if .ApproveURL -}}
+Approve: {{ .ApproveURL }}
+Reject: {{ .RejectURL }}
+
+These links work once, for a week.
+{{- else -}}
+The comment is shown at {{ .URL }}
+{{- end }}
+`))
+
+type commentMailData struct {
+	Record     *Record
+	Comment    *Comment
+	URL        string
+	ApproveURL string
+	RejectURL  string
+}
+
+// commentMessage is the mail telling the blog's owner about c on rec, with
+// links approving or rejecting it when it awaits moderation.
+func commentMessage(base string, rec *Record, c *Comment, now time.Time) ([]byte, error) {
+	data := &commentMailData{Record: rec, Comment: c, URL: base + "/show/" + rec.Slug() + "#comment-" + c.ID}
+	if c.Status == commentPending {
+		data.ApproveURL = moderationURL(base, rec.Slug(), c, "approve", now)
+		data.RejectURL = moderationURL(base, rec.Slug(), c, "reject", now)
+	}
+	var body bytes.Buffer
+	if err := commentMailTemplate.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", smtpFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", commentNotifyTo)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "New comment on "+rec.Title))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// notifyComment queues the mail about c on rec, if anyone wants it.
+func notifyComment(base string, rec *Record, c *Comment) {
+	if commentNotifyTo == "" || !mailEnabled() {
+		return
+	}
+	msg, err := commentMessage(base, rec, c, time.Now())
+	if err != nil {
+		errorf("unable to write the mail about comment %s on %s: %v", c.ID, rec.Slug(), err)
+		return
+	}
+	mails.enqueue("comment "+c.ID+" on "+rec.Slug(), []string{commentNotifyTo}, msg)
+}
+
+// withModerationToken sends requests carrying a token from a notification
+// to byToken, and the rest, from the moderation queue, to h.
+func withModerationToken(byToken, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "" {
+			byToken(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// moderateByTokenHandler serves the links in a notification. GET asks to
+// confirm, so mail scanners following links don't moderate anything, and
+// POST takes the action.
+func moderateByTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := moderationPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	slug, id, action := m[1], m[2], m[3]
+	expired := func() {
+		renderTemplateStatus(w, r, http.StatusForbidden, "error", &TemplateData{Errors: []string{"This link has expired or was already used."}})
+	}
+	if !checkModerationToken(r.URL.Query().Get("token"), slug, id, action, time.Now()) {
+		expired()
+		return
+	}
+	rec, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to load %s: %v", slug, err)
+		http.Error(w, "unable to load the post", http.StatusInternalServerError)
+		return
+	}
+	comments, err := LoadComments(slug)
+	if err != nil {
+		errorf("unable to load comments on %s: %v", slug, err)
+		http.Error(w, "unable to load the comment", http.StatusInternalServerError)
+		return
+	}
+	c := findComment(comments, id, false)
+	if c == nil || c.Status != commentPending {
+		expired()
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		renderTemplate(w, r, "moderate", &TemplateData{
+			PendingComments:  []PendingComment{{Comment: c, Post: rec}},
+			ModerationAction: action,
+			ModerationURL:    r.URL.RequestURI(),
+		})
+		return
+	}
+	status := commentApproved
+	if action == "reject" {
+		status = commentRejected
+	}
+	// the comment may have been moderated since it was loaded, from the
+	// queue or by a second click
+	c, err = moderatePendingComment(slug, id, status)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, errModerated) {
+		expired()
+		return
+	} else if err != nil {
+		errorf("unable to moderate comment %s on %s: %v", id, slug, err)
+		http.Error(w, "unable to save the comment", http.StatusInternalServerError)
+		return
+	}
+	infof("comment %s on %s %s by link", id, slug, status)
+	if status == commentApproved {
+		setFlash(w, flashInfo, "Approved the comment from "+c.Author)
+	} else {
+		setFlash(w, flashInfo, "Rejected the comment from "+c.Author)
+	}
+	http.Redirect(w, r, "/show/"+slug+"#comments", http.StatusSeeOther)
+}
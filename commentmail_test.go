@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useCommentNotify sends comment notifications to a queue nothing delivers
+// from, and returns it.
+func useCommentNotify(t *testing.T) *mailQueue {
+	t.Helper()
+	useTestNewsletter(t)
+	oldTo, oldMails := commentNotifyTo, mails
+	commentNotifyTo, mails = "owner@example.com", newMailQueue(10, 0, 0)
+	t.Cleanup(func() { commentNotifyTo, mails = oldTo, oldMails })
+	return mails
+}
+
+var moderationLink = regexp.MustCompile(`(?m)^(Approve|Reject): (\S+)$`)
+
+func TestCommentNotification(t *testing.T) {
+	useTempRecords(t)
+	useCommentLimiter(t, 100)
+	useAutoApprove(t, false)
+	q := useCommentNotify(t)
+	if err := (&Record{Title: "Open", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	postComment("open", url.Values{"author": {"Ada"}, "body": {"Nice post"}})
+	var job mailJob
+	select {
+	case job = <-q.jobs:
+	default:
+		t.Fatal("expected a notification to be queued")
+	}
+	msg := string(job.msg)
+	if job.to[0] != "owner@example.com" || !strings.Contains(msg, "Ada commented on \"Open\"") || !strings.Contains(msg, "Nice post") {
+		t.Fatalf("unexpected notification:\n%s", msg)
+	}
+	links := map[string]string{}
+	for _, m := range moderationLink.FindAllStringSubmatch(msg, -1) {
+		u, err := url.Parse(m[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		links[m[1]] = u.RequestURI()
+	}
+	if len(links) != 2 {
+		t.Fatalf("expected approve and reject links, got %v", links)
+	}
+	handler := withModerationToken(moderateByTokenHandler, http.NotFound)
+
+	// following the link only asks to confirm
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", links["Approve"], nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Nice post") {
+		t.Fatalf("expected a confirmation page, got %d", w.Code)
+	}
+	comments, _ := LoadComments("open")
+	if comments[0].Status != commentPending {
+		t.Fatalf("expected GET not to moderate, got %q", comments[0].Status)
+	}
+
+	// a token is for one action
+	w = httptest.NewRecorder()
+	rejectWithApprove := strings.Replace(links["Approve"], "/approve?", "/reject?", 1)
+	handler(w, httptest.NewRequest("POST", rejectWithApprove, nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for the wrong action, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", links["Approve"], nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect, got %d", w.Code)
+	}
+	comments, _ = LoadComments("open")
+	if comments[0].Status != commentApproved {
+		t.Fatalf("expected the comment to be approved, got %q", comments[0].Status)
+	}
+
+	// and works once
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("POST", links["Reject"], nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 once moderated, got %d", w.Code)
+	}
+}
+
+func TestCommentNotificationSkipsSpam(t *testing.T) {
+	useTempRecords(t)
+	useCommentLimiter(t, 100)
+	q := useCommentNotify(t)
+	if err := (&Record{Title: "Open", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	postComment("open", url.Values{"author": {"Bot"}, "body": {"Buy now"}, "website": {"http://spam.example"}})
+	if len(q.jobs) != 0 {
+		t.Fatal("expected no notification for spam")
+	}
+}
+
+func TestModerationTokenExpires(t *testing.T) {
+	now := time.Now()
+	token := moderationToken("open", "abc", "approve", now.Add(time.Hour))
+	if !checkModerationToken(token, "open", "abc", "approve", now) {
+		t.Fatal("expected the token to be valid")
+	}
+	if checkModerationToken(token, "open", "abc", "approve", now.Add(2*time.Hour)) {
+		t.Fatal("expected the token to have expired")
+	}
+	if checkModerationToken(token, "other", "abc", "approve", now) {
+		t.Fatal("expected the token to be for one post")
+	}
+}
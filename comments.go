@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxCommentAuthorLen = 80
+	maxCommentBodyLen   = 4000
+)
+
+// A comment's status says whether it is shown. Comments from before
+// moderation have none and are approved.
+const (
+	commentPending  = "pending"
+	commentApproved = "approved"
+	commentRejected = "rejected"
+)
+
+var (
+	// commentAutoApprove shows new comments straight away instead of
+	// holding them until they are approved
+	commentAutoApprove = envBool("BLOG_COMMENTS_AUTO_APPROVE", false)
+	// rejectedCommentRetention is how long rejected comments are kept, to
+	// look for spam patterns in, before they are purged
+	rejectedCommentRetention = time.Duration(envInt("BLOG_REJECTED_COMMENT_DAYS", 30)) * 24 * time.Hour
+	// commentMaxDepth is how deeply replies nest; replies to comments that
+	// deep are shown beside their parent instead
+	commentMaxDepth = envInt("BLOG_COMMENT_MAX_DEPTH", 3)
+)
+
+// errNoParent is returned for a reply to a comment that isn't shown.
+var errNoParent = errors.New("the comment replied to doesn't exist")
+
+// errModerated is returned for a comment that was already approved or
+// rejected.
+var errModerated = errors.New("the comment was already moderated")
+
+var (
+	commentPath    = regexp.MustCompile(`^/comment/([a-zA-Z0-9\-]+)$`)
+	moderationPath = regexp.MustCompile(`^/admin/comments/([a-zA-Z0-9\-]+)/([a-f0-9\-]+)/(approve|reject)$`)
+)
+
+// Comment is a reader's response to a post. The email is only shown to
+// moderators.
+type Comment struct {
+	ID          string    `json:"id"`
+	Author      string    `json:"author"`
+	Email       string    `json:"email,omitempty"`
+	Body        string    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+	Status      string    `json:"status,omitempty"`
+	ModeratedAt time.Time `json:"moderated_at"`
+	// ParentID is the comment this one replies to, if any
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+// Approved reports whether the comment may be shown.
+func (c *Comment) Approved() bool {
+	return c.Status == "" || c.Status == commentApproved
+}
+
+// CommentNode is a comment in a thread, with the replies shown under it.
+type CommentNode struct {
+	*Comment
+	Replies []*CommentNode
+	// Depth is 0 for the comments at the top level
+	Depth int
+
+	parent *CommentNode
+}
+
+// buildCommentTree arranges comments, oldest first, into threads. Replies
+// to a comment maxDepth deep go beside it rather than under it, so threads
+// never nest deeper than that.
+//
+// A reply whose parent isn't among comments, because the parent was
+// rejected, is still pending or was removed, is orphaned to the top level
+// rather than hidden with it: replies are moderated on their own and an
+// approved one stays shown.
+func buildCommentTree(comments []*Comment, maxDepth int) []*CommentNode {
+	var roots []*CommentNode
+	nodes := make(map[string]*CommentNode, len(comments))
+	for _, c := range comments {
+		n := &CommentNode{Comment: c}
+		p := nodes[c.ParentID]
+		nodes[c.ID] = n
+		if p != nil && p.Depth >= maxDepth {
+			// nil at the top level, which flattens everything when maxDepth
+			// is 0
+			p = p.parent
+		}
+		if p == nil {
+			roots = append(roots, n)
+			continue
+		}
+		n.parent, n.Depth = p, p.Depth+1
+		p.Replies = append(p.Replies, n)
+	}
+	return roots
+}
+
+// commentView is what the comment-thread template renders: a comment with
+// its replies, and whether to offer replying to them.
+type commentView struct {
+	Node     *CommentNode
+	CanReply bool
+}
+
+func newCommentView(n *CommentNode, canReply bool) commentView {
+	return commentView{Node: n, CanReply: canReply}
+}
+
+// approvedComments are the comments in comments that are shown.
+func approvedComments(comments []*Comment) []*Comment {
+	shown := make([]*Comment, 0, len(comments))
+	for _, c := range comments {
+		if c.Approved() {
+			shown = append(shown, c)
+		}
+	}
+	return shown
+}
+
+// commentsPath is where the comments on the post with slug are stored, one
+// file per post beside the revisions.
+func commentsPath(slug string) (string, error) {
+	return safePath(filepath.Join(recordsDir, "comments"), slug, ".json")
+}
+
+// slugLocks hands out a mutex per slug, dropping it once nobody holds or
+// waits for it.
+type slugLocks struct {
+	mu    sync.Mutex
+	locks map[string]*slugLock
+}
+
+type slugLock struct {
+	sync.Mutex
+	refs int
+}
+
+// commentLocks serialise the read-append-write of a post's comments.
+var commentLocks = &slugLocks{locks: make(map[string]*slugLock)}
+
+// lock locks slug and returns the function unlocking it.
+func (l *slugLocks) lock(slug string) func() {
+	l.mu.Lock()
+	sl, ok := l.locks[slug]
+	if !ok {
+		sl = &slugLock{}
+		l.locks[slug] = sl
+	}
+	sl.refs++
+	l.mu.Unlock()
+
+	sl.Lock()
+	return func() {
+		sl.Unlock()
+		l.mu.Lock()
+		if sl.refs--; sl.refs == 0 {
+			delete(l.locks, slug)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// LoadComments returns the comments on the post with slug, oldest first.
+func LoadComments(slug string) ([]*Comment, error) {
+	filename, err := commentsPath(slug)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return []*Comment{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var comments []*Comment
+	if err := json.Unmarshal(data, &comments); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return comments, nil
+}
+
+func writeComments(slug string, comments []*Comment) error {
+	filename, err := commentsPath(slug)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(comments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(filename, data, 0600)
+}
+
+// addComment appends c to the comments on slug, giving it an ID and time,
+// and a status unless it has one.
+func addComment(slug string, c *Comment) error {
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	c.ID, c.CreatedAt = id, time.Now()
+	if c.Status == "" {
+		c.Status = commentPending
+		if commentAutoApprove {
+			c.Status = commentApproved
+		}
+	}
+
+	unlock := commentLocks.lock(slug)
+	defer unlock()
+	comments, err := LoadComments(slug)
+	if err != nil {
+		return err
+	}
+	if c.ParentID != "" && findComment(comments, c.ParentID, true) == nil {
+		return errNoParent
+	}
+	return writeComments(slug, append(comments, c))
+}
+
+// findComment returns the comment id in comments, or nil. With approved set
+// only an approved comment is found.
+func findComment(comments []*Comment, id string, approved bool) *Comment {
+	for _, c := range comments {
+		if c.ID == id && (!approved || c.Approved()) {
+			return c
+		}
+	}
+	return nil
+}
+
+// moderateComment sets the status of the comment id on slug.
+func moderateComment(slug, id, status string) (*Comment, error) {
+	return setCommentStatus(slug, id, status, false)
+}
+
+// moderatePendingComment is moderateComment for a comment that must still
+// be pending, returning errModerated if it isn't.
+func moderatePendingComment(slug, id, status string) (*Comment, error) {
+	return setCommentStatus(slug, id, status, true)
+}
+
+func setCommentStatus(slug, id, status string, pendingOnly bool) (*Comment, error) {
+	unlock := commentLocks.lock(slug)
+	defer unlock()
+	comments, err := LoadComments(slug)
+	if err != nil {
+		return nil, err
+	}
+	c := findComment(comments, id, false)
+	if c == nil {
+		return nil, ErrNotFound
+	}
+	if pendingOnly && c.Status != commentPending {
+		return nil, errModerated
+	}
+	c.Status, c.ModeratedAt = status, time.Now()
+	return c, writeComments(slug, comments)
+}
+
+// PendingComment is a comment waiting for moderation, with its post.
+type PendingComment struct {
+	*Comment
+	Post *Record
+}
+
+// pendingComments lists the comments awaiting moderation on every post,
+// oldest first.
+func pendingComments() ([]PendingComment, error) {
+	records, err := AllRecords()
+	if err != nil {
+		return nil, err
+	}
+	var pending []PendingComment
+	for _, rec := range records {
+		comments, err := LoadComments(rec.Slug())
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			if c.Status == commentPending {
+				pending = append(pending, PendingComment{Comment: c, Post: rec})
+			}
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	return pending, nil
+}
+
+// purgeRejectedComments removes comments rejected more than
+// rejectedCommentRetention before now.
+func purgeRejectedComments(now time.Time) error {
+	files, err := filepath.Glob(filepath.Join(recordsDir, "comments", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		slug := strings.TrimSuffix(filepath.Base(f), ".json")
+		if err := purgeRejected(slug, now); err != nil {
+			return fmt.Errorf("%s: %w", slug, err)
+		}
+	}
+	return nil
+}
+
+func purgeRejected(slug string, now time.Time) error {
+	unlock := commentLocks.lock(slug)
+	defer unlock()
+	comments, err := LoadComments(slug)
+	if err != nil {
+		return err
+	}
+	kept := comments[:0]
+	for _, c := range comments {
+		if c.Status != commentRejected || now.Sub(c.ModeratedAt) < rejectedCommentRetention {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == len(comments) {
+		return nil
+	}
+	infof("purging %d rejected comments on %s", len(comments)-len(kept), slug)
+	return writeComments(slug, kept)
+}
+
+func purgeRejectedCommentsLoop(every time.Duration) {
+	for range time.Tick(every) {
+		if err := purgeRejectedComments(time.Now()); err != nil {
+			errorf("unable to purge rejected comments: %v", err)
+		}
+	}
+}
+
+// deleteComments removes every comment on slug, when its post is deleted.
+func deleteComments(slug string) error {
+	filename, err := commentsPath(slug)
+	if err != nil {
+		return err
+	}
+	unlock := commentLocks.lock(slug)
+	defer unlock()
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// moveComments carries a post's comments over when its slug changes.
+func moveComments(oldSlug, newSlug string) error {
+	from, err := commentsPath(oldSlug)
+	if err != nil {
+		return err
+	}
+	to, err := commentsPath(newSlug)
+	if err != nil {
+		return err
+	}
+	unlock := commentLocks.lock(oldSlug)
+	defer unlock()
+	if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// parseCommentForm builds the comment a form describes, with what is wrong
+// with it.
+func parseCommentForm(r *http.Request) (*Comment, []string) {
+	c := &Comment{
+		Author:   strings.TrimSpace(r.PostFormValue("author")),
+		Body:     strings.TrimSpace(r.PostFormValue("body")),
+		ParentID: strings.TrimSpace(r.PostFormValue("parent_id")),
+	}
+	var errs []string
+	switch n := utf8.RuneCountInString(c.Author); {
+	case n == 0:
+		errs = append(errs, "Name is required")
+	case n > maxCommentAuthorLen:
+		errs = append(errs, fmt.Sprintf("Name must be at most %d characters", maxCommentAuthorLen))
+	}
+	switch n := utf8.RuneCountInString(c.Body); {
+	case n == 0:
+		errs = append(errs, "Comment is required")
+	case n > maxCommentBodyLen:
+		errs = append(errs, fmt.Sprintf("Comment must be at most %d characters", maxCommentBodyLen))
+	}
+	if email := strings.TrimSpace(r.PostFormValue("email")); email != "" {
+		if addr, ok := parseEmail(email); ok {
+			c.Email = addr
+		} else {
+			errs = append(errs, "Email must be a valid address or left empty")
+		}
+	}
+	return c, errs
+}
+
+// commentable reports whether r may comment on rec: it must be published
+// and readable by r. Protected posts take no comments, since their access
+// cookie only reaches the post's own page.
+func commentable(r *http.Request, rec *Record) bool {
+	return rec.Published && !rec.Protected() && visibleTo(r, rec)
+}
+
+// commentHandler serves POST /comment/{slug}.
+func commentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := commentPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	slug := m[1]
+	rec, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) || (err == nil && !commentable(r, rec)) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to load %s: %v", slug, err)
+		http.Error(w, "unable to load the post", http.StatusInternalServerError)
+		return
+	}
+
+	c, errs := parseCommentForm(r)
+	if rule := commentSpamRule(r, slug, c, time.Now()); rule != "" {
+		refuseSpam(w, r, slug, rule)
+		return
+	}
+	if len(errs) > 0 {
+		setFlash(w, flashError, strings.Join(errs, ". "))
+		http.Redirect(w, r, "/show/"+slug+"#comment-form", http.StatusSeeOther)
+		return
+	}
+	err = addComment(slug, c)
+	if errors.Is(err, errNoParent) {
+		setFlash(w, flashError, "The comment you replied to isn't there anymore")
+		http.Redirect(w, r, "/show/"+slug+"#comments", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		errorf("unable to save a comment on %s: %v", slug, err)
+		http.Error(w, "unable to save the comment", http.StatusInternalServerError)
+		return
+	}
+	notifyComment(baseURL(r), rec, c)
+	if !c.Approved() {
+		setFlash(w, flashInfo, "Thanks for your comment. It will appear once it has been approved.")
+		http.Redirect(w, r, "/show/"+slug+"#comments", http.StatusSeeOther)
+		return
+	}
+	setFlash(w, flashInfo, "Thanks for your comment")
+	http.Redirect(w, r, "/show/"+slug+"#comment-"+c.ID, http.StatusSeeOther)
+}
+
+// moderationHandler serves /admin/comments, the comments waiting to be
+// approved or rejected.
+func moderationHandler(w http.ResponseWriter, r *http.Request) {
+	pending, err := pendingComments()
+	if err != nil {
+		errorf("unable to load pending comments: %v", err)
+		http.Error(w, "unable to load the comments", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "comments", &TemplateData{PendingComments: pending})
+}
+
+// moderateHandler serves POST /admin/comments/{slug}/{id}/approve and
+// /reject.
+func moderateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := moderationPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	slug, id, status := m[1], m[2], commentApproved
+	if m[3] == "reject" {
+		status = commentRejected
+	}
+	c, err := moderateComment(slug, id, status)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "comment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to moderate comment %s on %s: %v", id, slug, err)
+		http.Error(w, "unable to save the comment", http.StatusInternalServerError)
+		return
+	}
+	if status == commentApproved {
+		setFlash(w, flashInfo, "Approved the comment from "+c.Author)
+	} else {
+		setFlash(w, flashInfo, "Rejected the comment from "+c.Author)
+	}
+	http.Redirect(w, r, "/admin/comments", http.StatusSeeOther)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// postComment posts form as a comment on slug, from a form shown a minute
+// ago unless form has a stamp of its own.
+func postComment(slug string, form url.Values) *httptest.ResponseRecorder {
+	if _, ok := form["stamp"]; !ok {
+		form.Set("stamp", commentStamp(slug, time.Now().Add(-time.Minute)))
+	}
+	r := httptest.NewRequest("POST", "/comment/"+slug, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	commentHandler(w, r)
+	return w
+}
+
+func useAutoApprove(t *testing.T, on bool) {
+	t.Helper()
+	old := commentAutoApprove
+	commentAutoApprove = on
+	t.Cleanup(func() { commentAutoApprove = old })
+}
+
+func TestCommentHandler(t *testing.T) {
+	useTempRecords(t)
+	useCommentLimiter(t, 100)
+	useAutoApprove(t, true)
+	for _, rec := range []*Record{
+		{Title: "Open", Published: true},
+		{Title: "Draft", Published: false},
+		{Title: "Hidden", Published: true, Visibility: visibilityPrivate},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		name  string
+		slug  string
+		form  url.Values
+		code  int
+		flash string
+	}{
+		{"ok", "open", url.Values{"author": {"Ann"}, "body": {"Nice <b>post</b>"}, "email": {"ann@example.com"}}, http.StatusSeeOther, "Thanks"},
+		{"no name", "open", url.Values{"author": {"  "}, "body": {"hi"}}, http.StatusSeeOther, "Name is required"},
+		{"no body", "open", url.Values{"author": {"Ann"}}, http.StatusSeeOther, "Comment is required"},
+		{"long name", "open", url.Values{"author": {strings.Repeat("a", maxCommentAuthorLen+1)}, "body": {"hi"}}, http.StatusSeeOther, "Name must be at most"},
+		{"long body", "open", url.Values{"author": {"Ann"}, "body": {strings.Repeat("é", maxCommentBodyLen+1)}}, http.StatusSeeOther, "Comment must be at most"},
+		{"bad email", "open", url.Values{"author": {"Ann"}, "body": {"hi"}, "email": {"nope"}}, http.StatusSeeOther, "Email must be"},
+		{"missing post", "missing", url.Values{"author": {"Ann"}, "body": {"hi"}}, http.StatusNotFound, ""},
+		{"draft", "draft", url.Values{"author": {"Ann"}, "body": {"hi"}}, http.StatusNotFound, ""},
+		{"private", "hidden", url.Values{"author": {"Ann"}, "body": {"hi"}}, http.StatusNotFound, ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := postComment(tc.slug, tc.form)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			if tc.flash != "" {
+				r := httptest.NewRequest("GET", "/", nil)
+				for _, c := range w.Result().Cookies() {
+					r.AddCookie(c)
+				}
+				if f := popFlash(httptest.NewRecorder(), r); f == nil || !strings.Contains(f.Message, tc.flash) {
+					t.Fatalf("expected a flash with %q, got %+v", tc.flash, f)
+				}
+			}
+		})
+	}
+
+	for _, slug := range []string{"draft", "hidden"} {
+		if comments, _ := LoadComments(slug); len(comments) != 0 {
+			t.Fatalf("expected no comments on %s, got %d", slug, len(comments))
+		}
+	}
+	comments, err := LoadComments("open")
+	if err != nil || len(comments) != 1 || comments[0].Email != "ann@example.com" || comments[0].ID == "" {
+		t.Fatalf("expected the one valid comment stored, got %+v, %v", comments, err)
+	}
+
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/open", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, "Nice &lt;b&gt;post&lt;/b&gt;") || !strings.Contains(body, `id="comment-`+comments[0].ID+`"`) {
+		t.Fatalf("expected the comment escaped under the post, got %s", body)
+	}
+	if strings.Contains(body, "ann@example.com") {
+		t.Fatal("the commenter's email must not be shown")
+	}
+}
+
+func TestCommentModeration(t *testing.T) {
+	useTempRecords(t)
+	useCommentLimiter(t, 100)
+	useAutoApprove(t, false)
+	if err := (&Record{Title: "Open", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := postComment("open", url.Values{"author": {"Ann"}, "body": {"First!"}})
+	if w.Code != http.StatusSeeOther || !strings.HasSuffix(w.Header().Get("Location"), "#comments") {
+		t.Fatalf("expected a redirect to the comments, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if f := popFlash(httptest.NewRecorder(), r); f == nil || !strings.Contains(f.Message, "once it has been approved") {
+		t.Fatalf("expected to be told about moderation, got %+v", f)
+	}
+	if err := addComment("open", &Comment{Author: "Bob", Body: "Spam spam"}); err != nil {
+		t.Fatal(err)
+	}
+
+	show := func() string {
+		w := httptest.NewRecorder()
+		showHandler(w, httptest.NewRequest("GET", "/show/open", nil))
+		return w.Body.String()
+	}
+	if body := show(); strings.Contains(body, "First!") || strings.Contains(body, "Spam spam") {
+		t.Fatal("pending comments must not be shown")
+	}
+
+	pending, err := pendingComments()
+	if err != nil || len(pending) != 2 || pending[0].Author != "Ann" || pending[0].Post.Slug() != "open" {
+		t.Fatalf("expected both comments pending, oldest first, got %+v, %v", pending, err)
+	}
+	w = httptest.NewRecorder()
+	moderationHandler(w, httptest.NewRequest("GET", "/admin/comments", nil))
+	if !strings.Contains(w.Body.String(), "/admin/comments/open/"+pending[0].ID+"/approve") {
+		t.Fatalf("expected the queue to offer approving, got %s", w.Body)
+	}
+
+	moderate := func(method, path string) int {
+		w := httptest.NewRecorder()
+		moderateHandler(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+	if code := moderate("GET", "/admin/comments/open/"+pending[0].ID+"/approve"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", code)
+	}
+	if code := moderate("POST", "/admin/comments/open/0000/approve"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing comment, got %d", code)
+	}
+	if code := moderate("POST", "/admin/comments/open/"+pending[0].ID+"/approve"); code != http.StatusSeeOther {
+		t.Fatalf("expected approving to redirect, got %d", code)
+	}
+	if code := moderate("POST", "/admin/comments/open/"+pending[1].ID+"/reject"); code != http.StatusSeeOther {
+		t.Fatalf("expected rejecting to redirect, got %d", code)
+	}
+	if body := show(); !strings.Contains(body, "First!") || strings.Contains(body, "Spam spam") {
+		t.Fatal("expected only the approved comment shown")
+	}
+	if pending, _ := pendingComments(); len(pending) != 0 {
+		t.Fatalf("expected an empty queue, got %d", len(pending))
+	}
+
+	// rejected comments are kept for a while, then purged
+	if err := purgeRejectedComments(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if comments, _ := LoadComments("open"); len(comments) != 2 {
+		t.Fatalf("expected the rejected comment kept for now, got %d", len(comments))
+	}
+	if err := purgeRejectedComments(time.Now().Add(rejectedCommentRetention + time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	comments, _ := LoadComments("open")
+	if len(comments) != 1 || comments[0].Author != "Ann" {
+		t.Fatalf("expected only the approved comment left, got %+v", comments)
+	}
+}
+
+// treeShape writes nodes as "id(reply reply(...))" to compare trees.
+func treeShape(nodes []*CommentNode) string {
+	var parts []string
+	for _, n := range nodes {
+		part := n.ID
+		if len(n.Replies) > 0 {
+			part += "(" + treeShape(n.Replies) + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestBuildCommentTree(t *testing.T) {
+	c := func(id, parent string) *Comment { return &Comment{ID: id, ParentID: parent} }
+	thread := []*Comment{c("a", ""), c("b", "a"), c("c", "b"), c("d", "c"), c("e", "a"), c("f", "")}
+
+	tt := []struct {
+		name     string
+		comments []*Comment
+		maxDepth int
+		want     string
+	}{
+		{"flat", []*Comment{c("a", ""), c("b", "")}, 3, "a b"},
+		{"nested", thread, 3, "a(b(c(d)) e) f"},
+		{"flattened past the depth", thread, 2, "a(b(c d) e) f"},
+		{"one level", thread, 1, "a(b c d e) f"},
+		{"no nesting", thread, 0, "a b c d e f"},
+		{"orphan", []*Comment{c("b", "gone"), c("c", "b")}, 3, "b(c)"},
+		{"own parent", []*Comment{c("a", "a")}, 3, "a"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := treeShape(buildCommentTree(tc.comments, tc.maxDepth)); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	tree := buildCommentTree(thread, 2)
+	if d := tree[0].Replies[0].Replies[1]; d.ID != "d" || d.Depth != 2 {
+		t.Fatalf("expected d flattened to depth 2, got %s at %d", d.ID, d.Depth)
+	}
+}
+
+func TestCommentReplies(t *testing.T) {
+	useTempRecords(t)
+	useCommentLimiter(t, 100)
+	useAutoApprove(t, true)
+	if err := (&Record{Title: "Open", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addComment("open", &Comment{Author: "Ann", Body: "Question?"}); err != nil {
+		t.Fatal(err)
+	}
+	comments, _ := LoadComments("open")
+	parent := comments[0].ID
+
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/open?reply="+parent, nil))
+	if !strings.Contains(w.Body.String(), `name="parent_id" value="`+parent+`"`) || !strings.Contains(w.Body.String(), "Replying to Ann") {
+		t.Fatalf("expected the form to reply to Ann, got %s", w.Body)
+	}
+
+	if w := postComment("open", url.Values{"author": {"Bob"}, "body": {"Answer."}, "parent_id": {parent}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected the reply saved, got %d", w.Code)
+	}
+	w = postComment("open", url.Values{"author": {"Bob"}, "body": {"Lost."}, "parent_id": {"nope"}})
+	if !strings.HasSuffix(w.Header().Get("Location"), "#comments") {
+		t.Fatalf("expected a reply to a missing comment refused, got %q", w.Header().Get("Location"))
+	}
+	comments, _ = LoadComments("open")
+	if len(comments) != 2 || comments[1].ParentID != parent {
+		t.Fatalf("expected only the reply to Ann stored, got %+v", comments)
+	}
+
+	w = httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/open", nil))
+	body := w.Body.String()
+	ann := strings.Index(body, `id="comment-`+parent+`"`)
+	bob := strings.Index(body, `id="comment-`+comments[1].ID+`"`)
+	if ann < 0 || bob < ann || strings.Contains(body[ann:bob], "</article>") {
+		t.Fatalf("expected the reply nested in Ann's comment, got %s", body)
+	}
+
+	// rejecting a parent orphans its replies to the top level
+	if _, err := moderateComment("open", parent, commentRejected); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/open", nil))
+	if body := w.Body.String(); strings.Contains(body, "Question?") || !strings.Contains(body, "Answer.") {
+		t.Fatalf("expected the reply shown without its parent, got %s", body)
+	}
+	if w := postComment("open", url.Values{"author": {"Cy"}, "body": {"Me too"}, "parent_id": {parent}}); !strings.HasSuffix(w.Header().Get("Location"), "#comments") {
+		t.Fatal("expected replies to a rejected comment refused")
+	}
+}
+
+func TestCommentsConcurrent(t *testing.T) {
+	useTempRecords(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := addComment("busy", &Comment{Author: "reader", Body: fmt.Sprint(i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	comments, err := LoadComments("busy")
+	if err != nil || len(comments) != 50 {
+		t.Fatalf("expected 50 comments, got %d, %v", len(comments), err)
+	}
+	if len(commentLocks.locks) != 0 {
+		t.Fatalf("expected the locks to be released, %d left", len(commentLocks.locks))
+	}
+}
+
+func TestCommentsFollowTheirPost(t *testing.T) {
+	useTempRecords(t)
+	rec := &Record{Title: "Before", Published: true}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addComment("before", &Comment{Author: "A", Body: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec.Title = "After"
+	if err := RenameRecord("before", rec); err != nil {
+		t.Fatal(err)
+	}
+	if comments, _ := LoadComments("after"); len(comments) != 1 {
+		t.Fatal("expected the comments to follow the rename")
+	}
+
+	r := httptest.NewRequest("POST", "/delete/after", nil)
+	w := httptest.NewRecorder()
+	deleteHandler(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected the post deleted, got %d", w.Code)
+	}
+	path, _ := commentsPath("after")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the comments deleted with the post, got %v", err)
+	}
+}
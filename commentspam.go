@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// commentMinAge is how long the comment form must have been open before
+	// it is submitted; people take longer than that to type anything
+	commentMinAge = time.Duration(envInt("BLOG_COMMENT_MIN_SECONDS", 3)) * time.Second
+	// commentMaxAge is how long a comment form stays good for
+	commentMaxAge = 24 * time.Hour
+	// commentRateLimit is how many comments one address may post a minute
+	commentRateLimit = envInt("BLOG_COMMENT_RATE_LIMIT", 3)
+	// commentMaxLinks comments with more links than this are spam
+	commentMaxLinks = envInt("BLOG_COMMENT_MAX_LINKS", 2)
+	// commentBlocked are comma separated phrases no comment may contain,
+	// matched regardless of case
+	commentBlocked = splitList(envOr("BLOG_COMMENT_BLOCKED", ""))
+	// commentSpamSilent pretends spam was taken, so bots don't learn what
+	// gave them away; otherwise it is refused with a message saying nothing
+	// about why
+	commentSpamSilent = envBool("BLOG_COMMENT_SPAM_SILENT", true)
+)
+
+// commentLimiter limits comments per address, apart from the other writes.
+var commentLimiter = newRateLimiter(commentRateLimit)
+
+// commentHoneypot is a field hidden from people, which bots fill in.
+const commentHoneypot = "website"
+
+var commentLink = regexp.MustCompile(`(?i)\b(?:https?://|www\.)`)
+
+// commentStamp is put in the comment form for slug, recording when it was
+// shown, signed so it can't be backdated.
+func commentStamp(slug string, now time.Time) string {
+	return signValue("comment " + slug + " " + strconv.FormatInt(now.Unix(), 10))
+}
+
+// commentSpamRule names the rule the comment c posted by r breaks, or
+// returns "" when it breaks none.
+func commentSpamRule(r *http.Request, slug string, c *Comment, now time.Time) string {
+	if r.PostFormValue(commentHoneypot) != "" {
+		return "honeypot"
+	}
+	v, err := verifyValue(r.PostFormValue("stamp"))
+	prefix := "comment " + slug + " "
+	if err != nil || !strings.HasPrefix(v, prefix) {
+		return "missing stamp"
+	}
+	unix, err := strconv.ParseInt(strings.TrimPrefix(v, prefix), 10, 64)
+	if err != nil {
+		return "missing stamp"
+	}
+	switch age := now.Sub(time.Unix(unix, 0)); {
+	case age < commentMinAge:
+		return "too fast"
+	case age > commentMaxAge:
+		return "stale form"
+	}
+	if commentRateLimit > 0 {
+		if ok, _ := commentLimiter.allow(clientIP(r)); !ok {
+			return "rate limit"
+		}
+	}
+	text := strings.ToLower(c.Author + "\n" + c.Email + "\n" + c.Body)
+	if len(commentLink.FindAllStringIndex(text, -1)) > commentMaxLinks {
+		return "too many links"
+	}
+	for _, phrase := range commentBlocked {
+		if strings.Contains(text, strings.ToLower(phrase)) {
+			return "blocked phrase " + strconv.Quote(phrase)
+		}
+	}
+	return ""
+}
+
+// refuseSpam answers a comment commentSpamRule caught, logging the rule so
+// the settings can be tuned.
+func refuseSpam(w http.ResponseWriter, r *http.Request, slug, rule string) {
+	if commentSpamSilent {
+		warnf("discarding comment on %s from %s: %s", slug, clientIP(r), rule)
+		setFlash(w, flashInfo, "Thanks for your comment. It will appear once it has been approved.")
+		http.Redirect(w, r, "/show/"+slug+"#comments", http.StatusSeeOther)
+		return
+	}
+	warnf("refusing comment on %s from %s: %s", slug, clientIP(r), rule)
+	setFlash(w, flashError, "Your comment couldn't be posted. Please try again later.")
+	http.Redirect(w, r, "/show/"+slug+"#comment-form", http.StatusSeeOther)
+}
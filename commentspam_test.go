@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useCommentLimiter(t *testing.T, perMinute int) {
+	t.Helper()
+	oldLimiter, oldLimit := commentLimiter, commentRateLimit
+	commentLimiter, commentRateLimit = newRateLimiter(perMinute), perMinute
+	t.Cleanup(func() { commentLimiter, commentRateLimit = oldLimiter, oldLimit })
+}
+
+func TestCommentSpamRules(t *testing.T) {
+	useCommentLimiter(t, 2)
+	oldBlocked := commentBlocked
+	commentBlocked = []string{"Cheap Pills"}
+	t.Cleanup(func() { commentBlocked = oldBlocked })
+
+	now := time.Now()
+	good := commentStamp("open", now.Add(-time.Minute))
+	tt := []struct {
+		name string
+		form url.Values
+		ip   string
+		rule string
+	}{
+		{"ok", url.Values{"stamp": {good}, "body": {"see https://example.com"}}, "192.0.2.1", ""},
+		{"honeypot", url.Values{"stamp": {good}, "website": {"http://spam.example"}}, "192.0.2.2", "honeypot"},
+		{"no stamp", url.Values{}, "192.0.2.2", "missing stamp"},
+		{"forged stamp", url.Values{"stamp": {"comment open 1"}}, "192.0.2.2", "missing stamp"},
+		{"other post's stamp", url.Values{"stamp": {commentStamp("other", now.Add(-time.Minute))}}, "192.0.2.2", "missing stamp"},
+		{"too fast", url.Values{"stamp": {commentStamp("open", now.Add(-time.Second))}}, "192.0.2.2", "too fast"},
+		{"stale", url.Values{"stamp": {commentStamp("open", now.Add(-48*time.Hour))}}, "192.0.2.2", "stale form"},
+		{"links", url.Values{"stamp": {good}, "body": {"http://a.example www.b.example https://c.example"}}, "192.0.2.3", "too many links"},
+		{"blocked", url.Values{"stamp": {good}, "body": {"buy CHEAP pills now"}}, "192.0.2.4", `blocked phrase "Cheap Pills"`},
+		{"second from an address", url.Values{"stamp": {good}}, "192.0.2.1", ""},
+		{"third from an address", url.Values{"stamp": {good}}, "192.0.2.1", "rate limit"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/comment/open", strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.RemoteAddr = tc.ip + ":1234"
+			c := &Comment{Author: "Ann", Body: tc.form.Get("body")}
+			if got := commentSpamRule(r, "open", c, now); got != tc.rule {
+				t.Fatalf("expected %q, got %q", tc.rule, got)
+			}
+		})
+	}
+}
+
+func TestCommentSpamHandling(t *testing.T) {
+	useTempRecords(t)
+	useCommentLimiter(t, 100)
+	useAutoApprove(t, true)
+	if err := (&Record{Title: "Open", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	spam := url.Values{"author": {"Bot"}, "body": {"hi"}, "website": {"x"}}
+
+	oldSilent := commentSpamSilent
+	t.Cleanup(func() { commentSpamSilent = oldSilent })
+
+	commentSpamSilent = true
+	w := postComment("open", spam)
+	if w.Code != http.StatusSeeOther || !strings.HasSuffix(w.Header().Get("Location"), "#comments") {
+		t.Fatalf("expected spam to look accepted, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	commentSpamSilent = false
+	w = postComment("open", spam)
+	if !strings.HasSuffix(w.Header().Get("Location"), "#comment-form") {
+		t.Fatalf("expected spam refused, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	if f := popFlash(httptest.NewRecorder(), r); f == nil || f.Kind != flashError || strings.Contains(f.Message, "honeypot") {
+		t.Fatalf("expected a generic error, got %+v", f)
+	}
+
+	if comments, _ := LoadComments("open"); len(comments) != 0 {
+		t.Fatalf("expected the spam discarded, got %d comments", len(comments))
+	}
+
+	w = httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/open", nil))
+	if !strings.Contains(w.Body.String(), `name="stamp"`) || !strings.Contains(w.Body.String(), `name="website"`) {
+		t.Fatalf("expected the form to carry a stamp and a honeypot, got %s", w.Body)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// corsOrigins are the origins, like https://app.example.com, whose
+	// pages may call the API from the browser. Without any the API is
+	// same-origin only.
+	corsOrigins = splitList(envOr("BLOG_CORS_ORIGINS", ""))
+	// corsMaxAge is how long browsers may cache a preflight answer
+	corsMaxAge = time.Duration(envInt("BLOG_CORS_MAX_AGE", 600)) * time.Second
+)
+
+const (
+	corsAllowMethods = "GET, HEAD, POST, PATCH"
+	corsAllowHeaders = "Authorization, Content-Type, Idempotency-Key"
+	// corsExposeHeaders are the API's response headers scripts may read
+	corsExposeHeaders = "Location, Retry-After, Idempotent-Replayed"
+)
+
+// corsAllowed reports whether origin is one of corsOrigins. Origins are
+// compared without case or a trailing slash, as browsers send them without
+// one.
+func corsAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, o := range corsOrigins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCORS lets pages on corsOrigins call h from the browser. It answers
+// their preflight OPTIONS requests itself, before any authentication, since
+// browsers send those without credentials. Other origins get no CORS
+// headers at all, so browsers keep them out.
+func withCORS(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		// the answer depends on who asks, so caches must keep them apart
+		hdr.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !corsAllowed(origin) {
+			if preflight {
+				writeJSONError(w, http.StatusForbidden, "origin not allowed")
+				return
+			}
+			h(w, r)
+			return
+		}
+
+		hdr.Set("Access-Control-Allow-Origin", origin)
+		if preflight {
+			hdr.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			hdr.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		hdr.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		h(w, r)
+	}
+}
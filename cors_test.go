@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithCORS(t *testing.T) {
+	old := corsOrigins
+	corsOrigins = []string{"https://app.example/", "http://localhost:3000"}
+	t.Cleanup(func() { corsOrigins = old })
+
+	called := false
+	h := withCORS(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tt := []struct {
+		name      string
+		method    string
+		origin    string
+		preflight bool
+		code      int
+		allow     string
+		called    bool
+	}{
+		{"allowed", "GET", "https://app.example", false, http.StatusOK, "https://app.example", true},
+		{"allowed preflight", "OPTIONS", "http://localhost:3000", true, http.StatusNoContent, "http://localhost:3000", false},
+		{"other origin", "GET", "https://evil.example", false, http.StatusOK, "", true},
+		{"other origin preflight", "OPTIONS", "https://evil.example", true, http.StatusForbidden, "", false},
+		{"same origin", "GET", "", false, http.StatusOK, "", true},
+		{"plain OPTIONS", "OPTIONS", "https://app.example", false, http.StatusOK, "https://app.example", true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			r := httptest.NewRequest(tc.method, "/api/records", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.preflight {
+				r.Header.Set("Access-Control-Request-Method", "PATCH")
+				r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			hdr := w.Header()
+			if w.Code != tc.code || called != tc.called {
+				t.Fatalf("expected %d with the handler called %v, got %d and %v", tc.code, tc.called, w.Code, called)
+			}
+			if got := hdr.Get("Access-Control-Allow-Origin"); got != tc.allow {
+				t.Fatalf("expected Access-Control-Allow-Origin %q, got %q", tc.allow, got)
+			}
+			if hdr.Get("Vary") != "Origin" {
+				t.Fatalf("expected Vary: Origin, got %q", hdr.Get("Vary"))
+			}
+			wantMethods := ""
+			if tc.preflight && tc.allow != "" {
+				wantMethods = corsAllowMethods
+			}
+			if got := hdr.Get("Access-Control-Allow-Methods"); got != wantMethods {
+				t.Fatalf("expected Access-Control-Allow-Methods %q, got %q", wantMethods, got)
+			}
+		})
+	}
+}
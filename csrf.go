@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/subtle"
+	"html"
+	"html/template"
+	"net/http"
+)
+
+const csrfFormField = "csrf_token"
+
+// csrfToken is the token forms rendered for r must carry, or "" when r has
+// no session.
+func csrfToken(r *http.Request) string {
+	id := sessionID(r)
+	if id == "" {
+		return ""
+	}
+	sess, ok := sessions.get(id)
+	if !ok {
+		return ""
+	}
+	return sess.csrf
+}
+
+// csrfInput is the "csrfField" template function: the hidden input that
+// carries token in a form.
+func csrfInput(token string) template.HTML {
+	if token == "" {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` + html.EscapeString(token) + `">`)
+}
+
+// requireCSRF rejects any request but a GET or HEAD from a logged in
+// session unless its form carries that session's token, so other sites
+// can't submit forms with the session cookie. Browsers send Basic Auth
+// credentials cross-site too, and those come with no token, so forms
+// can't be submitted with them at all. Requests with neither aren't
+// authenticated, so there is nothing to forge. It parses the form, so it
+// must run after requireFormContentType; the JSON API is never wrapped.
+func requireCSRF(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h(w, r)
+			return
+		}
+		want := csrfToken(r)
+		if _, _, basic := r.BasicAuth(); want == "" && basic {
+			warnf("rejected %s %s: form sent with Basic Auth", r.Method, r.URL.Path)
+			http.Error(w, "forbidden: log in to submit forms", http.StatusForbidden)
+			return
+		}
+		if want != "" {
+			got := r.PostFormValue(csrfFormField)
+			if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+				warnf("rejected %s %s: bad CSRF token", r.Method, r.URL.Path)
+				http.Error(w, "forbidden: the form has expired, reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
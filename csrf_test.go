@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func formRequest(target string, form url.Values, c *http.Cookie) *http.Request {
+	r := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c != nil {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func tokenFor(c *http.Cookie) string {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(c)
+	return csrfToken(r)
+}
+
+func TestRequireCSRF(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+	h := requireCSRF(func(w http.ResponseWriter, r *http.Request) {})
+
+	a := sessionCookieFrom(t, login(t, "/"))
+	b := sessionCookieFrom(t, login(t, "/"))
+	stale := sessionCookieFrom(t, login(t, "/"))
+	staleToken := tokenFor(stale)
+	// logging in again from the same browser replaces the session
+	renewed := sessionCookieFrom(t, login(t, "/", stale))
+
+	tt := []struct {
+		name   string
+		cookie *http.Cookie
+		token  string
+		code   int
+	}{
+		{"valid", a, tokenFor(a), http.StatusOK},
+		{"missing", a, "", http.StatusForbidden},
+		{"wrong", a, "0123456789abcdef", http.StatusForbidden},
+		{"cross session", a, tokenFor(b), http.StatusForbidden},
+		{"stale", renewed, staleToken, http.StatusForbidden},
+		{"no session", nil, "", http.StatusOK},
+		{"basic auth", nil, "", http.StatusForbidden},
+		{"basic auth with session", a, tokenFor(a), http.StatusOK},
+		{"put", a, "", http.StatusForbidden},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{}
+			if tc.token != "" {
+				form.Set(csrfFormField, tc.token)
+			}
+			r := formRequest("/delete/x", form, tc.cookie)
+			if strings.HasPrefix(tc.name, "basic auth") {
+				r.SetBasicAuth("admin", "secret")
+			}
+			if tc.name == "put" {
+				r.Method = http.MethodPut
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+		})
+	}
+
+	// reads don't need a token
+	r := httptest.NewRequest("GET", "/delete/x", nil)
+	r.AddCookie(a)
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected GET to pass, got %d", w.Code)
+	}
+}
+
+func TestFormsCarryCSRFToken(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	useTestSessions(t)
+	if err := CreateRecord(&Record{Title: "Guarded", Published: true}, false); err != nil {
+		t.Fatal(err)
+	}
+	c := sessionCookieFrom(t, login(t, "/"))
+	want := `name="csrf_token" value="` + tokenFor(c) + `"`
+
+	for _, tc := range []struct {
+		handler http.HandlerFunc
+		target  string
+	}{
+		{editHandler, "/edit/guarded"},
+		{newHandler, "/new/"},
+		{deleteHandler, "/delete/guarded"},
+	} {
+		r := httptest.NewRequest("GET", tc.target, nil)
+		r.AddCookie(c)
+		w := httptest.NewRecorder()
+		tc.handler(w, r)
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: expected the form to carry %s, got:\n%s", tc.target, want, w.Body)
+		}
+	}
+}
+
+func TestWritesRejectGet(t *testing.T) {
+	useTempRecords(t)
+	if err := CreateRecord(&Record{Title: "Kept", Content: "original", Published: true}, false); err != nil {
+		t.Fatal(err)
+	}
+	for target, h := range map[string]http.HandlerFunc{
+		"/save/kept?title=Gone&content=overwritten": saveHandler,
+		"/create/?title=Planted&content=spam":       createHandler,
+	} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s: expected 405, got %d", target, w.Code)
+		}
+	}
+	if rec, err := LoadRecord("kept"); err != nil || rec.Content != "original" {
+		t.Fatalf("expected the post untouched, got %+v %v", rec, err)
+	}
+	if _, err := LoadRecord("planted"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected no post created, got %v", err)
+	}
+}
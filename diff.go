@@ -0,0 +1,94 @@
+package main
+
+const (
+	diffSame   = "same"
+	diffAdd    = "add"
+	diffRemove = "remove"
+)
+
+// maxDiffCells caps the LCS table diffLines builds. Anyone can ask for a
+// diff through /history, so two huge revisions mustn't cost gigabytes;
+// past the cap the changed middle is shown as removed and re-added.
+const maxDiffCells = 1 << 20
+
+// DiffLine is one line of a unified diff.
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+// diffLines computes a line diff of a against b from their longest common
+// subsequence. The lines both share at the start and end are taken off
+// first, so the quadratic part only covers what changed.
+func diffLines(a, b []string) []DiffLine {
+	diff := make([]DiffLine, 0, len(a)+len(b))
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		diff = append(diff, DiffLine{diffSame, a[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	diff = append(diff, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, DiffLine{diffSame, line})
+	}
+	return diff
+}
+
+// diffMiddle is diffLines for a and b without their common ends.
+func diffMiddle(a, b []string) []DiffLine {
+	diff := make([]DiffLine, 0, len(a)+len(b))
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			diff = append(diff, DiffLine{diffRemove, line})
+		}
+		for _, line := range b {
+			diff = append(diff, DiffLine{diffAdd, line})
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{diffSame, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{diffRemove, a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{diffAdd, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{diffRemove, a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{diffAdd, b[j]})
+	}
+	return diff
+}
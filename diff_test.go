@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tt := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"identical", "a\nb", "a\nb", " a  b"},
+		{"added line", "a\nc", "a\nb\nc", " a +b  c"},
+		{"removed line", "a\nb\nc", "a\nc", " a -b  c"},
+		{"changed line", "a\nb\nc", "a\nx\nc", " a -b +x  c"},
+		{"from empty", "", "a", "- +a"},
+		{"everything changed", "a\nb", "c", "-a -b +c"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			diff := diffLines(strings.Split(tc.a, "\n"), strings.Split(tc.b, "\n"))
+			got := make([]string, 0, len(diff))
+			for _, l := range diff {
+				prefix := map[string]string{diffSame: " ", diffAdd: "+", diffRemove: "-"}[l.Kind]
+				got = append(got, prefix+l.Text)
+			}
+			if strings.Join(got, " ") != tc.want {
+				t.Fatalf("\nexpected: %q\nactual: %q", tc.want, strings.Join(got, " "))
+			}
+		})
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	// far too big for the LCS table, but the unchanged ends are kept
+	a := make([]string, 0, 5002)
+	b := make([]string, 0, 5002)
+	a = append(a, "start")
+	b = append(b, "start")
+	for i := 0; i < 5000; i++ {
+		a = append(a, fmt.Sprintf("old %d", i))
+		b = append(b, fmt.Sprintf("new %d", i))
+	}
+	a = append(a, "end")
+	b = append(b, "end")
+
+	diff := diffLines(a, b)
+	if len(diff) != 10002 {
+		t.Fatalf("expected 10002 lines, got %d", len(diff))
+	}
+	first, last := diff[0], diff[len(diff)-1]
+	if first != (DiffLine{diffSame, "start"}) || last != (DiffLine{diffSame, "end"}) {
+		t.Fatalf("expected the common ends to be kept, got %v and %v", first, last)
+	}
+	if diff[1] != (DiffLine{diffRemove, "old 0"}) || diff[5001] != (DiffLine{diffAdd, "new 0"}) {
+		t.Fatalf("expected the middle to be removed and re-added, got %v and %v", diff[1], diff[5001])
+	}
+}
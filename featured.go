@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+)
+
+var featurePath = regexp.MustCompile(`^/admin/(feature|unfeature)/([a-zA-Z0-9\-]+)$`)
+
+// maxHeroRecords is how many featured records the index shows above the
+// listing.
+const maxHeroRecords = 3
+
+// featuredRecords returns the featured records among records that are
+// published, listed and not behind a passphrase, most recently updated
+// first, keeping at most limit of them when limit is positive.
+func featuredRecords(records []*Record, limit int) []*Record {
+	featured := make([]*Record, 0)
+	for _, r := range records {
+		if r.Featured && r.Published && r.Listed() && !r.Protected() {
+			featured = append(featured, r)
+		}
+	}
+	sortRecentlyUpdated(featured)
+	if limit > 0 && len(featured) > limit {
+		featured = featured[:limit]
+	}
+	return featured
+}
+
+// featureHandler serves POST /admin/feature/{slug} and
+// /admin/unfeature/{slug}.
+func featureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := featurePath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	feature, slug := m[1] == "feature", m[2]
+
+	rec, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to load %s: %v", slug, err)
+		http.Error(w, "unable to load the post", http.StatusInternalServerError)
+		return
+	}
+	if !allowed(r, actionEdit, rec) {
+		forbidden(w, r, "feature this post")
+		return
+	}
+
+	if rec.Featured != feature {
+		rec.Featured = feature
+		if err := rec.Save(); err != nil {
+			errorf("unable to save %s: %v", slug, err)
+			http.Error(w, "unable to save the post", http.StatusInternalServerError)
+			return
+		}
+	}
+	if feature {
+		setFlash(w, flashInfo, "Featured "+rec.Title)
+	} else {
+		setFlash(w, flashInfo, "Stopped featuring "+rec.Title)
+	}
+	http.Redirect(w, r, "/show/"+slug, http.StatusSeeOther)
+}
+
+// featuredHandler serves /featured, every featured post.
+func featuredHandler(w http.ResponseWriter, r *http.Request) {
+	records, err := AllRecords()
+	if err != nil {
+		errorf("unable to load records: %v", err)
+		http.Error(w, "unable to load posts", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "featured", &TemplateData{Records: featuredRecords(records, 0)})
+}
+
+// apiFeaturedHandler serves GET /api/featured, every featured post.
+func apiFeaturedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	records, err := AllRecords()
+	if err != nil {
+		errorf("unable to load records: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "unable to list records")
+		return
+	}
+	list := recordList{Records: []apiRecord{}}
+	for _, rec := range featuredRecords(records, 0) {
+		list.Records = append(list.Records, apiRecord{Slug: rec.Slug(), Record: rec})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
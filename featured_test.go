@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeaturedRecords(t *testing.T) {
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []*Record{
+		{Title: "plain", Published: true, UpdatedAt: day.Add(5 * time.Hour)},
+		{Title: "old", Featured: true, Published: true, UpdatedAt: day},
+		{Title: "draft", Featured: true, UpdatedAt: day.Add(4 * time.Hour)},
+		{Title: "newest", Featured: true, Published: true, UpdatedAt: day.Add(3 * time.Hour)},
+		{Title: "unlisted", Featured: true, Published: true, Visibility: visibilityUnlisted, UpdatedAt: day.Add(3 * time.Hour)},
+		{Title: "protected", Featured: true, Published: true, AccessPassword: "open sesame", UpdatedAt: day.Add(3 * time.Hour)},
+		{Title: "middle", Featured: true, Published: true, UpdatedAt: day.Add(2 * time.Hour)},
+		{Title: "older", Featured: true, Published: true, UpdatedAt: day.Add(time.Hour)},
+	}
+	var titles []string
+	for _, r := range featuredRecords(records, maxHeroRecords) {
+		titles = append(titles, r.Title)
+	}
+	if got := strings.Join(titles, " "); got != "newest middle older" {
+		t.Fatalf("expected newest middle older, got %s", got)
+	}
+	if got := featuredRecords(records, 0); len(got) != 4 {
+		t.Fatalf("expected every featured record without a limit, got %d", len(got))
+	}
+}
+
+func TestFeatureHandler(t *testing.T) {
+	dir := useTempRecords(t)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeRecordAt(t, dir, &Record{Title: "Big news", Published: true}, start)
+	writeRecordAt(t, dir, &Record{Title: "Small news", Published: true}, start.Add(time.Hour))
+
+	tt := []struct {
+		method string
+		target string
+		code   int
+	}{
+		{"POST", "/admin/feature/big-news", http.StatusSeeOther},
+		{"POST", "/admin/feature/big-news", http.StatusSeeOther},
+		{"GET", "/admin/feature/big-news", http.StatusMethodNotAllowed},
+		{"POST", "/admin/feature/nope", http.StatusNotFound},
+		{"POST", "/admin/feature/", http.StatusNotFound},
+	}
+	for _, tc := range tt {
+		w := httptest.NewRecorder()
+		featureHandler(w, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.target, tc.code, w.Code)
+		}
+	}
+	if rec, err := LoadRecord("big-news"); err != nil || !rec.Featured {
+		t.Fatalf("expected big-news to be featured, got %+v %v", rec, err)
+	}
+
+	w := httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	hero := strings.Index(body, `class="hero"`)
+	if hero < 0 || !strings.Contains(body[hero:strings.Index(body, "<table>")], "/show/big-news") {
+		t.Fatalf("expected big-news in the hero section:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	featuredHandler(w, httptest.NewRequest("GET", "/featured", nil))
+	if body := w.Body.String(); !strings.Contains(body, "/show/big-news") || strings.Contains(body, "/show/small-news") {
+		t.Fatalf("expected only big-news on /featured:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	apiFeaturedHandler(w, httptest.NewRequest("GET", "/api/featured", nil))
+	var list recordList
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Records) != 1 || list.Records[0].Slug != "big-news" {
+		t.Fatalf("expected big-news from the API, got %+v", list.Records)
+	}
+
+	w = httptest.NewRecorder()
+	featureHandler(w, httptest.NewRequest("POST", "/admin/unfeature/big-news", nil))
+	if rec, _ := LoadRecord("big-news"); rec.Featured {
+		t.Fatal("expected big-news to no longer be featured")
+	}
+	w = httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/", nil))
+	if strings.Contains(w.Body.String(), `class="hero"`) {
+		t.Fatal("expected no hero section without featured posts")
+	}
+}
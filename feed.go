@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+// feedSize is how many of the newest posts the feeds carry.
+var feedSize = envInt("BLOG_FEED_SIZE", 20)
+
+// feedRecords gathers what every feed format publishes: the newest listed
+// posts that aren't protected, at most feedSize of them.
+func feedRecords() ([]*Record, error) {
+	published, err := listedRecords()
+	if err != nil {
+		return nil, err
+	}
+	records := published[:0]
+	for _, r := range published {
+		if !r.Protected() {
+			records = append(records, r)
+		}
+	}
+	sortNewestFirst(records)
+	if feedSize > 0 && len(records) > feedSize {
+		records = records[:feedSize]
+	}
+	return records, nil
+}
+
+// jsonFeed is a JSON Feed 1.1 document, see https://jsonfeed.org/version/1.1.
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	Title         string    `json:"title"`
+	ContentHTML   string    `json:"content_html"`
+	Summary       string    `json:"summary,omitempty"`
+	Image         string    `json:"image,omitempty"`
+	DatePublished time.Time `json:"date_published"`
+	DateModified  time.Time `json:"date_modified,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`
+}
+
+// jsonFeedHandler serves /feed.json.
+func jsonFeedHandler(w http.ResponseWriter, r *http.Request) {
+	records, err := feedRecords()
+	if err != nil {
+		errorf("unable to load records for the feed: %v", err)
+		http.Error(w, "unable to load the feed", http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       siteTitle,
+		HomePageURL: base + "/",
+		FeedURL:     base + "/feed.json",
+		Description: siteDescription,
+		Items:       make([]jsonFeedItem, 0, len(records)),
+	}
+	for _, rec := range records {
+		url := base + "/show/" + rec.Slug()
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            url,
+			URL:           url,
+			Title:         rec.Title,
+			ContentHTML:   string(rec.HTML()),
+			Summary:       excerpt(rec.Content, feedExcerptWords),
+			Image:         absoluteURL(base, rec.FeaturedImage()),
+			DatePublished: rec.CreatedAt,
+			DateModified:  rec.UpdatedAt,
+			Tags:          rec.Tags,
+		})
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+// rssFeed is an RSS 2.0 document, see https://www.rssboard.org/rss-specification.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+}
+
+// rssFeedHandler serves /feed.rss.
+func rssFeedHandler(w http.ResponseWriter, r *http.Request) {
+	records, err := feedRecords()
+	if err != nil {
+		errorf("unable to load records for the feed: %v", err)
+		http.Error(w, "unable to load the feed", http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	ch := rssChannel{
+		Title:       siteTitle,
+		Link:        base + "/",
+		Description: siteDescription,
+		Items:       make([]rssItem, 0, len(records)),
+	}
+	if ch.Description == "" {
+		// the element is required, even if empty isn't much use
+		ch.Description = siteTitle
+	}
+	if t := latestUpdate(records); !t.IsZero() {
+		ch.LastBuildDate = t.UTC().Format(time.RFC1123Z)
+	}
+	for _, rec := range records {
+		url := base + "/show/" + rec.Slug()
+		ch.Items = append(ch.Items, rssItem{
+			Title:       rec.Title,
+			Link:        url,
+			GUID:        url,
+			PubDate:     rec.CreatedAt.UTC().Format(time.RFC1123Z),
+			Description: string(rec.HTML()),
+			Categories:  rec.Tags,
+		})
+	}
+	data, err := marshalXML(rssFeed{Version: "2.0", Channel: ch})
+	if err != nil {
+		errorf("unable to encode the feed: %v", err)
+		http.Error(w, "unable to encode the feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Write(data)
+}
+
+// atomFeed is an Atom document, see RFC 4287.
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   atomAuthor  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Author     *atomAuthor    `xml:"author,omitempty"`
+	Summary    string         `xml:"summary,omitempty"`
+	Content    atomContent    `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+// atomFeedHandler serves /feed.atom.
+func atomFeedHandler(w http.ResponseWriter, r *http.Request) {
+	records, err := feedRecords()
+	if err != nil {
+		errorf("unable to load records for the feed: %v", err)
+		http.Error(w, "unable to load the feed", http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r)
+	updated := latestUpdate(records)
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed := atomFeed{
+		Title:    siteTitle,
+		Subtitle: siteDescription,
+		ID:       base + "/",
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Author:   atomAuthor{Name: siteTitle},
+		Links:    []atomLink{{Href: base + "/"}, {Rel: "self", Href: base + "/feed.atom"}},
+		Entries:  make([]atomEntry, 0, len(records)),
+	}
+	for _, rec := range records {
+		url := base + "/show/" + rec.Slug()
+		e := atomEntry{
+			Title:     rec.Title,
+			ID:        url,
+			Link:      atomLink{Href: url},
+			Published: rec.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   rec.UpdatedAt.UTC().Format(time.RFC3339),
+			Summary:   excerpt(rec.Content, feedExcerptWords),
+			Content:   atomContent{Type: "html", Body: string(rec.HTML())},
+		}
+		if rec.UpdatedAt.IsZero() {
+			e.Updated = e.Published
+		}
+		if rec.Author != "" {
+			e.Author = &atomAuthor{Name: authorName(rec.Author)}
+		}
+		for _, tag := range rec.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	data, err := marshalXML(feed)
+	if err != nil {
+		errorf("unable to encode the feed: %v", err)
+		http.Error(w, "unable to encode the feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write(data)
+}
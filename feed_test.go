@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONFeed(t *testing.T) {
+	dir := useTempRecords(t)
+	old := feedSize
+	feedSize = 2
+	t.Cleanup(func() { feedSize = old })
+
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	writeRecordAt(t, dir, &Record{Title: "Oldest", Content: "first", Published: true}, start)
+	writeRecordAt(t, dir, &Record{Title: "Middle", Content: "**second**", Published: true, Meta: map[string]string{"image": "/static/m.png"}}, start.Add(time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Newest", Content: "third", Published: true}, start.Add(2*time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Draft", Content: "hidden", Published: false}, start.Add(3*time.Hour))
+
+	w := httptest.NewRecorder()
+	jsonFeedHandler(w, httptest.NewRequest("GET", "http://blog.example/feed.json", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected application/json, got %q", ct)
+	}
+
+	var feed jsonFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("bad JSON %s: %v", w.Body, err)
+	}
+	if feed.Version != "https://jsonfeed.org/version/1.1" || feed.FeedURL != "http://blog.example/feed.json" {
+		t.Fatalf("unexpected feed header: %+v", feed)
+	}
+	if len(feed.Items) != 2 || feed.Items[0].Title != "Newest" || feed.Items[1].Title != "Middle" {
+		t.Fatalf("expected the two newest published posts, got %+v", feed.Items)
+	}
+	item := feed.Items[1]
+	if item.URL != "http://blog.example/show/middle" || item.ContentHTML != "<p><strong>second</strong></p>\n" ||
+		!item.DatePublished.Equal(start.Add(time.Hour)) || item.Image != "http://blog.example/static/m.png" {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+}
+
+func TestJSONFeedEmpty(t *testing.T) {
+	useTempRecords(t)
+	w := httptest.NewRecorder()
+	jsonFeedHandler(w, httptest.NewRequest("GET", "/feed.json", nil))
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if string(raw["items"]) != "[]" {
+		t.Fatalf("expected an empty items array, got %s", raw["items"])
+	}
+}
+
+func TestXMLFeeds(t *testing.T) {
+	dir := useTempRecords(t)
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	writeRecordAt(t, dir, &Record{Title: "Older", Content: "first", Published: true, Tags: []string{"go"}}, start)
+	writeRecordAt(t, dir, &Record{Title: "Newer", Content: "**second** & more", Published: true}, start.Add(time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Draft", Content: "hidden"}, start.Add(2*time.Hour))
+
+	w := httptest.NewRecorder()
+	rssFeedHandler(w, httptest.NewRequest("GET", "http://blog.example/feed.rss", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/rss+xml") {
+		t.Fatalf("expected an RSS content type, got %q", ct)
+	}
+	var rss rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &rss); err != nil {
+		t.Fatalf("bad RSS %s: %v", w.Body, err)
+	}
+	items := rss.Channel.Items
+	if len(items) != 2 || items[0].Title != "Newer" || items[1].Link != "http://blog.example/show/older" {
+		t.Fatalf("unexpected items: %+v", items)
+	}
+	if items[0].Description != "<p><strong>second</strong> &amp; more</p>\n" || items[0].PubDate != "Mon, 01 Mar 2021 01:00:00 +0000" {
+		t.Fatalf("unexpected item: %+v", items[0])
+	}
+
+	w = httptest.NewRecorder()
+	atomFeedHandler(w, httptest.NewRequest("GET", "http://blog.example/feed.atom", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Fatalf("expected an Atom content type, got %q", ct)
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatalf("bad Atom %s: %v", w.Body, err)
+	}
+	if atom.Updated != "2021-03-01T01:00:00Z" || len(atom.Entries) != 2 {
+		t.Fatalf("unexpected feed: %+v", atom)
+	}
+	if e := atom.Entries[1]; e.ID != "http://blog.example/show/older" || e.Content.Type != "html" ||
+		len(e.Categories) != 1 || e.Categories[0].Term != "go" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestFeedAutodiscovery(t *testing.T) {
+	useTempRecords(t)
+	old := siteTitle
+	siteTitle = "Notes & Things"
+	t.Cleanup(func() { siteTitle = old })
+
+	w := httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`<title>Notes &amp; Things</title>`,
+		`<link rel="alternate" type="application/rss+xml" title="Notes &amp; Things" href="/feed.rss">`,
+		`<link rel="alternate" type="application/atom+xml" title="Notes &amp; Things" href="/feed.atom">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the page to contain %s", want)
+		}
+	}
+}
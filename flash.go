@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	flashCookie = "flash"
+	// keep the cookie well under the 4KB browsers will store
+	maxFlashLen = 256
+	flashMaxAge = 60
+
+	flashInfo  = "info"
+	flashError = "error"
+)
+
+// Flash is a one-shot message shown on the next rendered page.
+type Flash struct {
+	Kind    string
+	Message string
+}
+
+var secretKey = loadSecret()
+
+func loadSecret() []byte {
+	if s := os.Getenv("BLOG_SECRET"); s != "" {
+		return []byte(s)
+	}
+	// without a configured secret signed cookies won't survive a restart,
+	// which is fine for flashes
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("unable to generate secret: %v", err)
+	}
+	return key
+}
+
+func signValue(value string) string {
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString([]byte(value)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyValue(signed string) (string, error) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", errors.New("malformed signed value")
+	}
+	value, err := base64.RawURLEncoding.DecodeString(signed[:i])
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write(value)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("bad signature")
+	}
+	return string(value), nil
+}
+
+func setFlash(w http.ResponseWriter, kind, message string) {
+	if len(message) > maxFlashLen {
+		// cut on a rune boundary so the message stays valid UTF-8
+		n := maxFlashLen
+		for n > 0 && !utf8.RuneStart(message[n]) {
+			n--
+		}
+		message = message[:n]
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    signValue(kind + "\x00" + message),
+		Path:     "/",
+		MaxAge:   flashMaxAge,
+		HttpOnly: true,
+		Secure:   tlsDomain != "",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// popFlash reads and clears the flash cookie. A missing or tampered cookie
+// just means there is nothing to show.
+func popFlash(w http.ResponseWriter, r *http.Request) *Flash {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   tlsDomain != "",
+		SameSite: http.SameSiteLaxMode,
+	})
+	value, err := verifyValue(c.Value)
+	if err != nil {
+		debugf("discarding flash cookie: %v", err)
+		return nil
+	}
+	parts := strings.SplitN(value, "\x00", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	return &Flash{Kind: parts[0], Message: parts[1]}
+}
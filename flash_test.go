@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFlashRoundTrip(t *testing.T) {
+	tt := []struct {
+		name    string
+		kind    string
+		message string
+		want    string
+	}{
+		{"info", flashInfo, "Post saved", "Post saved"},
+		{"error", flashError, "Title is required", "Title is required"},
+		{"oversized", flashInfo, strings.Repeat("x", maxFlashLen+100), strings.Repeat("x", maxFlashLen)},
+		{"oversized multibyte", flashInfo, "x" + strings.Repeat("é", maxFlashLen), "x" + strings.Repeat("é", maxFlashLen/2-1)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setFlash(w, tc.kind, tc.message)
+			c := w.Result().Cookies()[0]
+			if !c.HttpOnly {
+				t.Fatal("flash cookie is not HttpOnly")
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(c)
+			f := popFlash(httptest.NewRecorder(), r)
+			if f == nil {
+				t.Fatal("expected a flash, got nil")
+			}
+			if f.Kind != tc.kind || f.Message != tc.want {
+				t.Fatalf("\nexpected: %s %q\nactual: %s %q", tc.kind, tc.want, f.Kind, f.Message)
+			}
+		})
+	}
+}
+
+func TestFlashSecure(t *testing.T) {
+	old := tlsDomain
+	t.Cleanup(func() { tlsDomain = old })
+
+	for _, domain := range []string{"", "blog.example"} {
+		tlsDomain = domain
+		w := httptest.NewRecorder()
+		setFlash(w, flashInfo, "Post saved")
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		cleared := httptest.NewRecorder()
+		popFlash(cleared, r)
+
+		for _, c := range append(w.Result().Cookies(), cleared.Result().Cookies()...) {
+			if c.Secure != (domain != "") {
+				t.Fatalf("TLS domain %q: expected Secure %v, got %v", domain, domain != "", c.Secure)
+			}
+		}
+	}
+}
+
+func TestFlashGarbled(t *testing.T) {
+	good := httptest.NewRecorder()
+	setFlash(good, flashInfo, "Post saved")
+	signed := good.Result().Cookies()[0].Value
+
+	tt := []struct {
+		name  string
+		value string
+	}{
+		{"empty", ""},
+		{"no signature", "UG9zdCBzYXZlZA"},
+		{"not base64", "!!!.???"},
+		{"tampered", "X" + signed[1:]},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(&http.Cookie{Name: flashCookie, Value: tc.value})
+			w := httptest.NewRecorder()
+			if f := popFlash(w, r); f != nil {
+				t.Fatalf("expected no flash, got %+v", f)
+			}
+			// the bad cookie should still be cleared
+			if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+				t.Fatalf("expected flash cookie to be cleared, got %v", c)
+			}
+		})
+	}
+}
+
+func TestFlashMissing(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	if f := popFlash(w, r); f != nil {
+		t.Fatalf("expected no flash, got %+v", f)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("no cookie should be set when there was no flash")
+	}
+}
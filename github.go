@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// the OAuth app registered on GitHub; its callback URL is
+	// {site}/oauth/callback
+	githubClientID     = envOr("BLOG_GITHUB_CLIENT_ID", "")
+	githubClientSecret = envOr("BLOG_GITHUB_CLIENT_SECRET", "")
+	// githubUsers are the GitHub logins allowed in, comma separated
+	githubUsers = splitList(envOr("BLOG_GITHUB_USERS", ""))
+)
+
+// GitHub's endpoints, replaced in tests.
+var (
+	githubAuthorizeURL = "https://github.com/login/oauth/authorize"
+	githubTokenURL     = "https://github.com/login/oauth/access_token"
+	githubUserURL      = "https://api.github.com/user"
+	githubClient       = &http.Client{Timeout: 10 * time.Second}
+)
+
+const (
+	oauthStateCookie = "oauth_state"
+	// oauthStateMaxAge is how long someone has to finish on GitHub
+	oauthStateMaxAge = 10 * 60
+	// githubUserPrefix marks session users who logged in with GitHub, so
+	// they can't be confused with a local account of the same name
+	githubUserPrefix = "github:"
+)
+
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// githubLoginEnabled reports whether GitHub login is set up. Without an
+// allowlist it stays off, or every GitHub user could log in.
+func githubLoginEnabled() bool {
+	return githubClientID != "" && githubClientSecret != "" && len(githubUsers) > 0
+}
+
+// githubAllowed reports whether login is on the allowlist. GitHub logins
+// are case-insensitive.
+func githubAllowed(login string) bool {
+	for _, u := range githubUsers {
+		if strings.EqualFold(u, login) {
+			return true
+		}
+	}
+	return false
+}
+
+// githubLoginHandler serves /login/github, sending the user to GitHub to
+// authorize the blog. The state parameter is kept in a signed cookie too,
+// and the callback only accepts a state matching it.
+func githubLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !githubLoginEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	state, err := randomToken()
+	if err != nil {
+		errorf("unable to start GitHub login: %v", err)
+		http.Error(w, "unable to log in", http.StatusInternalServerError)
+		return
+	}
+	next := safeNext(r.URL.Query().Get("next"))
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    signValue(state + " " + next),
+		Path:     "/oauth/",
+		MaxAge:   oauthStateMaxAge,
+		HttpOnly: true,
+		Secure:   tlsDomain != "",
+		SameSite: http.SameSiteLaxMode,
+	})
+	q := url.Values{
+		"client_id":    {githubClientID},
+		"redirect_uri": {baseURL(r) + "/oauth/callback"},
+		"scope":        {"read:user"},
+		"state":        {state},
+	}
+	http.Redirect(w, r, githubAuthorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// githubLoginError shows why a GitHub login didn't work, with a way back.
+func githubLoginError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	renderTemplateStatus(w, r, status, "login", &TemplateData{
+		ReturnTo:    "/",
+		GitHubLogin: githubLoginEnabled(),
+		Errors:      []string{msg},
+	})
+}
+
+// oauthCallbackHandler serves /oauth/callback, where GitHub sends the user
+// back with a code to exchange for a token.
+func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !githubLoginEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+
+	var state, next string
+	if c, err := r.Cookie(oauthStateCookie); err == nil {
+		if v, err := verifyValue(c.Value); err == nil {
+			state, next = v, "/"
+			if i := strings.Index(v, " "); i >= 0 {
+				state, next = v[:i], safeNext(v[i+1:])
+			}
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/oauth/", MaxAge: -1, HttpOnly: true})
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		githubLoginError(w, r, http.StatusBadRequest, "That GitHub login expired or didn't start here. Please try again.")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		// e.g. access_denied when the user cancelled
+		infof("GitHub login refused: %s", e)
+		githubLoginError(w, r, http.StatusUnauthorized, "GitHub didn't authorize the login.")
+		return
+	}
+
+	token, err := githubExchange(q.Get("code"), baseURL(r)+"/oauth/callback")
+	if err != nil {
+		errorf("GitHub token exchange failed: %v", err)
+		githubLoginError(w, r, http.StatusBadGateway, "Couldn't complete the login with GitHub. Please try again.")
+		return
+	}
+	login, err := githubLogin(token)
+	if err != nil {
+		errorf("unable to fetch the GitHub user: %v", err)
+		githubLoginError(w, r, http.StatusBadGateway, "Couldn't find out who you are on GitHub. Please try again.")
+		return
+	}
+	if !githubAllowed(login) {
+		warnf("GitHub user %s isn't allowed to log in", login)
+		githubLoginError(w, r, http.StatusForbidden, "The GitHub account "+login+" isn't allowed to log in here.")
+		return
+	}
+
+	if err := startSession(w, r, githubUserPrefix+login); err != nil {
+		errorf("unable to start session: %v", err)
+		http.Error(w, "unable to log in", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// githubExchange trades an authorization code for an access token.
+func githubExchange(code, redirectURI string) (string, error) {
+	if code == "" {
+		return "", errors.New("no code in the callback")
+	}
+	form := url.Values{
+		"client_id":     {githubClientID},
+		"client_secret": {githubClientSecret},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+	}
+	req, err := http.NewRequest(http.MethodPost, githubTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := githubDo(req, &body); err != nil {
+		return "", err
+	}
+	// GitHub reports a bad code with a 200 and an error field
+	if body.Error != "" {
+		return "", fmt.Errorf("%s: %s", body.Error, body.ErrorDescription)
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("no access token in the response")
+	}
+	return body.AccessToken, nil
+}
+
+// githubLogin fetches the login of the user token belongs to.
+func githubLogin(token string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, githubUserURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := githubDo(req, &user); err != nil {
+		return "", err
+	}
+	if user.Login == "" {
+		return "", errors.New("no login in the response")
+	}
+	return user.Login, nil
+}
+
+// githubDo sends req and decodes the JSON response into v.
+func githubDo(req *http.Request, v interface{}) error {
+	resp, err := githubClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
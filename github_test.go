@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// useTestGitHub points GitHub login at a fake GitHub that issues a token for
+// the code "good" and says the token belongs to login.
+func useTestGitHub(t *testing.T, login string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_secret") != "shh" || r.FormValue("code") != "good" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code", "error_description": "The code is incorrect"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "tok"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"login": login})
+	})
+	srv := httptest.NewServer(mux)
+
+	oldID, oldSecret, oldUsers := githubClientID, githubClientSecret, githubUsers
+	oldAuthorize, oldToken, oldUser := githubAuthorizeURL, githubTokenURL, githubUserURL
+	githubClientID, githubClientSecret, githubUsers = "client", "shh", []string{"Ada"}
+	githubAuthorizeURL, githubTokenURL, githubUserURL = "https://github.example/authorize", srv.URL+"/token", srv.URL+"/user"
+	t.Cleanup(func() {
+		srv.Close()
+		githubClientID, githubClientSecret, githubUsers = oldID, oldSecret, oldUsers
+		githubAuthorizeURL, githubTokenURL, githubUserURL = oldAuthorize, oldToken, oldUser
+	})
+}
+
+// startGitHubLogin follows /login/github and returns the state it sent to
+// GitHub and the cookie holding it.
+func startGitHubLogin(t *testing.T, next string) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	githubLoginHandler(w, httptest.NewRequest("GET", "http://blog.example/login/github?next="+url.QueryEscape(next), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected a redirect to GitHub, got %d", w.Code)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.String(), githubAuthorizeURL) {
+		t.Fatalf("unexpected redirect %q", w.Header().Get("Location"))
+	}
+	if got := loc.Query().Get("redirect_uri"); got != "http://blog.example/oauth/callback" {
+		t.Fatalf("unexpected redirect_uri %q", got)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oauthStateCookie {
+			return loc.Query().Get("state"), c
+		}
+	}
+	t.Fatal("no state cookie set")
+	return "", nil
+}
+
+func callback(query string, c *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://blog.example/oauth/callback?"+query, nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	oauthCallbackHandler(w, r)
+	return w
+}
+
+func TestGitHubLogin(t *testing.T) {
+	useTestSessions(t)
+	useTestGitHub(t, "ada")
+
+	state, c := startGitHubLogin(t, "/new/")
+	w := callback("code=good&state="+state, c)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/new/" {
+		t.Fatalf("expected to be sent on to /new/, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(sessionCookieFrom(t, w))
+	if user := currentUser(r); user != "github:ada" || roleOf(user) != roleAdmin || authorName(user) != "ada" {
+		t.Fatalf("expected an admin session for github:ada, got %q", user)
+	}
+}
+
+func TestGitHubLoginFailures(t *testing.T) {
+	useTestSessions(t)
+	useTestGitHub(t, "mallory")
+
+	state, c := startGitHubLogin(t, "/")
+	other, _ := startGitHubLogin(t, "/")
+
+	tt := []struct {
+		name   string
+		query  string
+		cookie *http.Cookie
+		code   int
+		msg    string
+	}{
+		{"no cookie", "code=good&state=" + state, nil, http.StatusBadRequest, "expired"},
+		{"wrong state", "code=good&state=" + other, c, http.StatusBadRequest, "expired"},
+		{"denied", "error=access_denied&state=" + state, c, http.StatusUnauthorized, "didn&#39;t authorize"},
+		{"bad code", "code=bad&state=" + state, c, http.StatusBadGateway, "Couldn&#39;t complete the login"},
+		{"not allowed", "code=good&state=" + state, c, http.StatusForbidden, "mallory isn&#39;t allowed"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := callback(tc.query, tc.cookie)
+			if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.msg) {
+				t.Fatalf("expected %d with %q, got %d: %s", tc.code, tc.msg, w.Code, w.Body)
+			}
+			for _, c := range w.Result().Cookies() {
+				if c.Name == sessionCookie && c.MaxAge >= 0 {
+					t.Fatal("no session should be started")
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubLoginDisabled(t *testing.T) {
+	useTestGitHub(t, "ada")
+	githubUsers = nil
+
+	w := httptest.NewRecorder()
+	githubLoginHandler(w, httptest.NewRequest("GET", "/login/github", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected GitHub login to be off without an allowlist, got %d", w.Code)
+	}
+	if roleOf("github:ada") != "" {
+		t.Fatal("expected no role for GitHub users while GitHub login is off")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	// gravatarDefault is the image Gravatar shows for addresses without
+	// one of their own: one of its generated styles, like identicon or
+	// retro, or the URL of an image
+	gravatarDefault = envOr("BLOG_GRAVATAR_DEFAULT", "identicon")
+	// gravatarSize is the avatars' width and height in pixels
+	gravatarSize = envInt("BLOG_GRAVATAR_SIZE", 48)
+)
+
+const gravatarBase = "https://www.gravatar.com/avatar/"
+
+// gravatarHash is the hash Gravatar looks an email address up by: the
+// SHA-256 of the trimmed, lowercased address, in hex.
+func gravatarHash(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(sum[:])
+}
+
+// AvatarURL is the commenter's Gravatar. Comments without an email get a
+// generated image, from a hash of the name so each commenter keeps theirs,
+// and never a real Gravatar that happens to match it. Only the hash leaves
+// the server, never the address.
+func (c *Comment) AvatarURL() string {
+	q := url.Values{"d": {gravatarDefault}, "s": {strconv.Itoa(gravatarSize)}}
+	key := c.Email
+	if key == "" {
+		key = "name:" + c.Author
+		q.Set("f", "y")
+	}
+	return gravatarBase + gravatarHash(key) + "?" + q.Encode()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGravatarHash(t *testing.T) {
+	const want = "b5fc85e55755f9e0d030a10ab4429b6b2944855f9a0d60077fe832becbc41d72"
+	for _, email := range []string{"ada@example.com", "  Ada@Example.COM\n"} {
+		if got := gravatarHash(email); got != want {
+			t.Errorf("%q: expected %s, got %s", email, want, got)
+		}
+	}
+}
+
+func TestAvatarURL(t *testing.T) {
+	withEmail := (&Comment{Author: "Ada", Email: "ada@example.com"}).AvatarURL()
+	if !strings.HasPrefix(withEmail, gravatarBase+gravatarHash("ada@example.com")+"?") ||
+		!strings.Contains(withEmail, "d=identicon") || strings.Contains(withEmail, "f=y") {
+		t.Fatalf("unexpected avatar for an email: %s", withEmail)
+	}
+
+	anon := (&Comment{Author: "Ada"}).AvatarURL()
+	if anon != (&Comment{Author: "Ada"}).AvatarURL() || anon == (&Comment{Author: "Bob"}).AvatarURL() {
+		t.Fatal("expected each name to keep its own generated avatar")
+	}
+	if !strings.Contains(anon, "f=y") || !strings.Contains(anon, "d=identicon") {
+		t.Fatalf("expected a forced generated avatar without an email, got %s", anon)
+	}
+}
+
+func TestShowPageAvatarsHideEmail(t *testing.T) {
+	useTempRecords(t)
+	useCommentLimiter(t, 100)
+	useAutoApprove(t, true)
+	if err := (&Record{Title: "Open", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	postComment("open", url.Values{"author": {"Ada"}, "body": {"Hello"}, "email": {"ada@example.com"}})
+
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/open", nil))
+	body := w.Body.String()
+	if strings.Contains(body, "ada@example.com") {
+		t.Fatal("the commenter's email must not be shown")
+	}
+	if !strings.Contains(body, gravatarBase+gravatarHash("ada@example.com")) {
+		t.Fatalf("expected the commenter's Gravatar in:\n%s", body)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyTTL is how long a create is remembered under its
+// Idempotency-Key, for clients retrying it.
+var idempotencyTTL = time.Duration(envInt("BLOG_IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour
+
+// maxIdempotencyKeyLen keeps clients from storing anything big as a key.
+const maxIdempotencyKeyLen = 255
+
+// replayedHeaders are the response headers sent again on a retry.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// idempotentResponse is what a request with an Idempotency-Key got, to
+// send again when it is retried.
+type idempotentResponse struct {
+	// request is the hash of the request's body, so a key reused for
+	// another request is caught
+	request [sha256.Size]byte
+	done    bool
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// idempotencyStore remembers responses by client and Idempotency-Key.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotentResponse
+	now     func() time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{ttl: ttl, entries: make(map[string]*idempotentResponse), now: time.Now}
+}
+
+var idempotencyKeys = newIdempotencyStore(idempotencyTTL)
+
+// idempotencyScope is who made r, so clients can't see each other's
+// responses by guessing keys.
+func idempotencyScope(r *http.Request) string {
+	if k, ok := r.Context().Value(apiKeyContextKey{}).(*APIKey); ok {
+		return "key " + k.ID
+	}
+	return "anonymous"
+}
+
+// cleanup forgets the responses whose time is up.
+func (s *idempotencyStore) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, e := range s.entries {
+		if e.done && now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *idempotencyStore) cleanupLoop(every time.Duration) {
+	for range time.Tick(every) {
+		s.cleanup()
+	}
+}
+
+// idempotent lets clients retry h safely: a request with an
+// Idempotency-Key header that was seen before gets the first response
+// again, marked with Idempotent-Replayed, rather than running h twice.
+// Server errors aren't kept, so those can be retried for real. Requests
+// without the header run as usual.
+func (s *idempotencyStore) idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			h(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			writeJSONError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPostSize))
+		if err != nil {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		id := idempotencyScope(r) + " " + r.Method + " " + r.URL.Path + " " + key
+
+		s.mu.Lock()
+		e, ok := s.entries[id]
+		if ok && e.done && s.now().After(e.expires) {
+			ok = false
+		}
+		switch {
+		case ok && e.request != sum:
+			s.mu.Unlock()
+			writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			return
+		case ok && !e.done:
+			s.mu.Unlock()
+			writeJSONError(w, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+			return
+		case ok:
+			s.mu.Unlock()
+			for name, values := range e.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+			return
+		}
+		e = &idempotentResponse{request: sum}
+		s.entries[id] = e
+		s.mu.Unlock()
+
+		rec := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if rec.status >= 500 {
+			delete(s.entries, id)
+			return
+		}
+		// only h's own headers, not those middleware set for this request
+		header := make(http.Header)
+		for _, name := range replayedHeaders {
+			if v := w.Header().Values(name); len(v) > 0 {
+				header[name] = v
+			}
+		}
+		e.done, e.status, e.header, e.body = true, rec.status, header, rec.body.Bytes()
+		e.expires = s.now().Add(s.ttl)
+	}
+}
+
+// responseCapture passes a response through, keeping a copy of it.
+type responseCapture struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if !c.wroteHeader {
+		c.status, c.wroteHeader = status, true
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	c.wroteHeader = true
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
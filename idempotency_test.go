@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentCreate(t *testing.T) {
+	dir := useTempRecords(t)
+	useTestAuth(t)
+	oldCollision := slugCollision
+	slugCollision = "suffix"
+	t.Cleanup(func() { slugCollision = oldCollision })
+	store := newIdempotencyStore(time.Hour)
+	apiKey := useTestAPIKey(t)
+	h := requireAPIKey(store.idempotent(createRecordHandler))
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		r := keyRequest("POST", "/api/records", apiKey, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	first := post("abc", `{"title": "Once"}`)
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected 201, got %d: %s", first.Code, first.Body)
+	}
+	retry := post("abc", `{"title": "Once"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("Location") != "/show/once" || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected the first response again, got %d %v: %s", retry.Code, retry.Header(), retry.Body)
+	}
+	if files, _ := os.ReadDir(dir); countRecordFiles(files) != 1 {
+		t.Fatalf("expected one record, got %d", countRecordFiles(files))
+	}
+
+	if w := post("abc", `{"title": "Twice"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a reused key, got %d", w.Code)
+	}
+	if w := post("", `{"title": "Once"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected a request without a key to create another record, got %d", w.Code)
+	}
+
+	// the key is forgotten once it expires
+	store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	store.cleanup()
+	if len(store.entries) != 0 {
+		t.Fatalf("expected expired keys to be cleaned up, got %d", len(store.entries))
+	}
+	if w := post("abc", `{"title": "Twice"}`); w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected an expired key to run the request, got %d", w.Code)
+	}
+}
+
+func countRecordFiles(files []os.DirEntry) int {
+	n := 0
+	for _, f := range files {
+		if isRecordFile(f.Name()) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestIdempotentScopesAndErrors(t *testing.T) {
+	store := newIdempotencyStore(time.Hour)
+	calls, status := 0, http.StatusInternalServerError
+	h := store.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	})
+	send := func(keyID string) int {
+		r := withAPIKey(httptest.NewRequest("POST", "/api/records", strings.NewReader("{}")), &APIKey{ID: keyID})
+		r.Header.Set("Idempotency-Key", "k")
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+
+	// server errors can be retried
+	send("ada")
+	status = http.StatusCreated
+	if code := send("ada"); code != http.StatusCreated || calls != 2 {
+		t.Fatalf("expected a retry after a 500 to run again, got %d after %d calls", code, calls)
+	}
+	send("ada")
+	if calls != 2 {
+		t.Fatalf("expected the retry to be answered from the store, got %d calls", calls)
+	}
+	// another client's key is its own
+	send("bob")
+	if calls != 3 {
+		t.Fatalf("expected keys to be scoped per client, got %d calls", calls)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+var (
+	// maxPostSize caps the body of a plain form post, and maxUploadSize a
+	// multipart one carrying files
+	maxPostSize   int64 = 1 << 20
+	maxUploadSize int64 = 32 << 20
+)
+
+// multipartMemory is how much of a multipart body is held in memory; the
+// rest of the files spill to temporary files.
+const multipartMemory = 8 << 20
+
+// limitBody caps the size of a form post and parses it up front, so a body
+// that is too large is refused with a 413 before the handler acts on any of
+// it.
+func limitBody(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h(w, r)
+			return
+		}
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		limit := maxPostSize
+		if mt == "multipart/form-data" {
+			limit = maxUploadSize
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+		var err error
+		if mt == "multipart/form-data" {
+			err = r.ParseMultipartForm(multipartMemory)
+		} else {
+			err = r.ParseForm()
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			warnf("refused a %s body over %d bytes to %s", mt, limit, r.URL.Path)
+			msg := fmt.Sprintf("That was too large to accept: the limit is %s.", formatBytes(limit))
+			renderTemplateStatus(w, r, http.StatusRequestEntityTooLarge, "error", &TemplateData{Errors: []string{msg}})
+			return
+		} else if err != nil {
+			http.Error(w, "unable to read the form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// formatBytes shows n in the largest whole unit, like "1 MB".
+func formatBytes(n int64) string {
+	units := []string{"bytes", "KB", "MB", "GB"}
+	i := 0
+	for ; i < len(units)-1 && n >= 1024 && n%1024 == 0; i++ {
+		n /= 1024
+	}
+	return fmt.Sprintf("%d %s", n, units[i])
+}
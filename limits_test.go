@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLimitBody(t *testing.T) {
+	useTempRecords(t)
+	oldPost, oldUpload := maxPostSize, maxUploadSize
+	maxPostSize, maxUploadSize = 1024, 4096
+	t.Cleanup(func() { maxPostSize, maxUploadSize = oldPost, oldUpload })
+	h := limitBody(createHandler)
+
+	form := url.Values{"title": {"Huge"}, "content": {strings.Repeat("x", 2048)}}
+	w := httptest.NewRecorder()
+	h(w, formRequest("/create/", form, nil))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "the limit is 1 KB") {
+		t.Fatalf("expected a rendered error, got %q", w.Body.String())
+	}
+	if _, err := LoadRecord("huge"); err == nil {
+		t.Fatal("expected no record to be written")
+	}
+
+	// a multipart post is held to the upload limit instead
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "Huge")
+	mw.WriteField("content", strings.Repeat("x", 2048))
+	mw.Close()
+	r := httptest.NewRequest("POST", "/create/", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected a multipart post under the upload limit to be created, got %d", w.Code)
+	}
+	if _, err := LoadRecord("huge"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tt := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 bytes"},
+		{1000, "1000 bytes"},
+		{1 << 20, "1 MB"},
+		{1536, "1536 bytes"},
+		{32 << 20, "32 MB"},
+	}
+	for _, tc := range tt {
+		if got := formatBytes(tc.n); got != tc.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+type level int
+
+const (
+	levelDebug level = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[level]string{
+	levelDebug: "DEBUG",
+	levelInfo:  "INFO",
+	levelWarn:  "WARN",
+	levelError: "ERROR",
+}
+
+var logLevel = parseLevel(os.Getenv("BLOG_LOG_LEVEL"))
+
+// parseLevel maps BLOG_LOG_LEVEL to a level, defaulting to info.
+func parseLevel(s string) level {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l
+		}
+	}
+	return levelInfo
+}
+
+// setupLogging sends the standard logger to BLOG_LOG_FILE when it is set.
+func setupLogging() error {
+	path := os.Getenv("BLOG_LOG_FILE")
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(f)
+	return nil
+}
+
+func logf(l level, format string, args ...interface{}) {
+	if l < logLevel {
+		return
+	}
+	// 3 skips logf and the debugf/infof/warnf/errorf wrapper
+	log.Output(3, levelNames[l]+" "+fmt.Sprintf(format, args...))
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, format, args...) }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tt := []struct {
+		in   string
+		want level
+	}{
+		{"debug", levelDebug},
+		{"DEBUG", levelDebug},
+		{"info", levelInfo},
+		{"warn", levelWarn},
+		{"error", levelError},
+		{"", levelInfo},
+		{"verbose", levelInfo},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.in, func(t *testing.T) {
+			if got := parseLevel(tc.in); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestLogLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	oldLevel := logLevel
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		logLevel = oldLevel
+	})
+
+	logLevel = levelInfo
+	debugf("hidden %d", 1)
+	infof("shown %d", 2)
+	errorf("shown %d", 3)
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("debug message logged at info level:\n%s", out)
+	}
+	if !strings.Contains(out, "INFO shown 2") || !strings.Contains(out, "ERROR shown 3") {
+		t.Fatalf("expected info and error messages, got:\n%s", out)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// loginMaxFailures failed logins within loginWindow, for one username
+	// or from one address, lock it out for loginLockout; 0 turns this off
+	loginMaxFailures = envInt("BLOG_LOGIN_MAX_FAILURES", 10)
+	loginWindow      = time.Duration(envInt("BLOG_LOGIN_WINDOW", 15)) * time.Minute
+	loginLockout     = time.Duration(envInt("BLOG_LOGIN_LOCKOUT", 15)) * time.Minute
+)
+
+// loginThrottle counts failed logins per key, a username or a client
+// address, and locks a key out once it has failed too often.
+type loginThrottle struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	lockout  time.Duration
+	failures map[string]*loginFailures
+	now      func() time.Time
+}
+
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func newLoginThrottle(max int, window, lockout time.Duration) *loginThrottle {
+	return &loginThrottle{
+		max:      max,
+		window:   window,
+		lockout:  lockout,
+		failures: make(map[string]*loginFailures),
+		now:      time.Now,
+	}
+}
+
+var logins = newLoginThrottle(loginMaxFailures, loginWindow, loginLockout)
+
+// loginKeys are what a login attempt counts against. Usernames are folded
+// so changing their case doesn't buy more attempts.
+func loginKeys(username, ip string) []string {
+	return []string{"user:" + strings.ToLower(username), "ip:" + ip}
+}
+
+// locked reports whether any of keys is locked out, and for how long.
+func (t *loginThrottle) locked(keys ...string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var wait time.Duration
+	for _, k := range keys {
+		if f, ok := t.failures[k]; ok && now.Before(f.lockedUntil) {
+			if d := f.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait, wait > 0
+}
+
+// fail records a failed login against keys, locking out any that reach max
+// failures within the window.
+func (t *loginThrottle) fail(keys ...string) {
+	if t.max <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, k := range keys {
+		f, ok := t.failures[k]
+		if !ok || now.Sub(f.first) > t.window {
+			f = &loginFailures{first: now}
+			t.failures[k] = f
+		}
+		f.count++
+		if f.count >= t.max {
+			f.lockedUntil = now.Add(t.lockout)
+			warnf("locking out %s after %d failed logins", k, f.count)
+			// start counting afresh once the lockout is over
+			f.count, f.first = 0, f.lockedUntil
+		}
+	}
+}
+
+// reset forgets the failures of keys, after a successful login.
+func (t *loginThrottle) reset(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		delete(t.failures, k)
+	}
+}
+
+// cleanup forgets keys that are neither locked out nor inside a window.
+func (t *loginThrottle) cleanup() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for k, f := range t.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.first) > t.window {
+			delete(t.failures, k)
+		}
+	}
+}
+
+func (t *loginThrottle) cleanupLoop(every time.Duration) {
+	for range time.Tick(every) {
+		t.cleanup()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTestLogins gives the test a login throttle of its own on a fake clock.
+func useTestLogins(t *testing.T, max int) *time.Time {
+	t.Helper()
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := logins
+	logins = newLoginThrottle(max, 15*time.Minute, 15*time.Minute)
+	logins.now = func() time.Time { return now }
+	t.Cleanup(func() { logins = old })
+	return &now
+}
+
+func loginAs(user, pass, ip string) *httptest.ResponseRecorder {
+	form := url.Values{"username": {user}, "password": {pass}, "next": {"/"}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	loginHandler(w, r)
+	return w
+}
+
+func TestLoginLockout(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+	now := useTestLogins(t, 3)
+
+	// an unknown user gets the same answer as a wrong password
+	for _, user := range []string{"admin", "nobody"} {
+		w := loginAs(user, "wrong", "192.0.2.1")
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Invalid credentials") {
+			t.Fatalf("%s: expected a generic 401, got %d", user, w.Code)
+		}
+	}
+	loginAs("Admin", "wrong", "192.0.2.2")
+	loginAs("admin", "wrong", "192.0.2.3")
+
+	// admin has failed three times, so even the right password is refused
+	w := loginAs("ADMIN", "secret", "192.0.2.4")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "900" {
+		t.Fatalf("expected admin to be locked out, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	*now = now.Add(16 * time.Minute)
+	if w := loginAs("admin", "secret", "192.0.2.4"); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected the lockout to be over, got %d", w.Code)
+	}
+}
+
+func TestLoginLockoutByAddress(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+	useTestLogins(t, 3)
+
+	for _, user := range []string{"a", "b", "c"} {
+		loginAs(user, "guess", "198.51.100.7")
+	}
+	if w := loginAs("admin", "secret", "198.51.100.7"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the address to be locked out, got %d", w.Code)
+	}
+	if w := loginAs("admin", "secret", "198.51.100.8"); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected another address to log in, got %d", w.Code)
+	}
+}
+
+func TestBasicAuthLockout(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+	now := useTestLogins(t, 3)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	basic := func(h http.HandlerFunc, pass string) int {
+		r := httptest.NewRequest("GET", "/admin", nil)
+		r.SetBasicAuth("admin", pass)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	// guesses through any of the guards add up
+	basic(requireAuth(ok), "one")
+	basic(requireLogin(ok), "two")
+	basic(requireAuth(ok), "three")
+	if code := basic(requireAuth(ok), "secret"); code != http.StatusUnauthorized {
+		t.Fatalf("expected the right password to be refused while locked out, got %d", code)
+	}
+	if w := loginAs("admin", "secret", "192.0.2.9"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the login form to be locked out too, got %d", w.Code)
+	}
+
+	*now = now.Add(16 * time.Minute)
+	if code := basic(requireAuth(ok), "secret"); code != http.StatusOK {
+		t.Fatalf("expected the lockout to be over, got %d", code)
+	}
+}
+
+func TestStaleBasicAuthOnPublicPages(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	useTestSessions(t)
+	useTestLogins(t, 3)
+
+	// a browser with a wrong saved password browsing the public pages
+	for i := 0; i < 10; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth("admin", "stale")
+		w := httptest.NewRecorder()
+		indexHandler(w, r)
+		if currentUser(r) != "" {
+			t.Fatal("expected unchecked credentials not to log anyone in")
+		}
+	}
+	if w := loginAs("admin", "secret", "192.0.2.1"); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected page views not to count as failed logins, got %d", w.Code)
+	}
+
+	// once a guard accepts the credentials, public pages know who it is
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("admin", "secret")
+	if currentUser(r) != "" {
+		t.Fatal("expected credentials no guard has checked to be ignored")
+	}
+	requireAuth(func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), r)
+	if got := currentUser(r); got != "admin" {
+		t.Fatalf("expected admin after the guard accepted them, got %q", got)
+	}
+
+	// changing the password forgets the old credentials
+	authPass = "changed"
+	if got := currentUser(r); got != "" {
+		t.Fatalf("expected the old password to be forgotten, got %q", got)
+	}
+}
+
+func TestLoginSuccessResets(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+	useTestLogins(t, 3)
+
+	for i := 0; i < 2; i++ {
+		loginAs("admin", "wrong", "192.0.2.1")
+	}
+	loginAs("admin", "secret", "192.0.2.1")
+	for i := 0; i < 2; i++ {
+		loginAs("admin", "wrong", "192.0.2.1")
+	}
+	if w := loginAs("admin", "secret", "192.0.2.1"); w.Code != http.StatusSeeOther {
+		t.Fatalf("expected the counters to have been reset by the earlier login, got %d", w.Code)
+	}
+}
+
+func TestLoginThrottleCleanup(t *testing.T) {
+	now := useTestLogins(t, 2)
+	logins.fail("ip:a")
+	logins.fail("ip:b", "ip:b")
+	*now = now.Add(20 * time.Minute)
+	logins.cleanup()
+	if len(logins.failures) != 1 {
+		t.Fatalf("expected only the locked out key to be kept, got %v", logins.failures)
+	}
+	*now = now.Add(30 * time.Minute)
+	logins.cleanup()
+	if len(logins.failures) != 0 {
+		t.Fatalf("expected everything to be forgotten, got %v", logins.failures)
+	}
+}
+
+func TestLoginThrottleConcurrent(t *testing.T) {
+	useTestLogins(t, 1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				logins.fail(loginKeys("admin", "192.0.2.1")...)
+				logins.locked(loginKeys("admin", "192.0.2.1")...)
+			}
+		}()
+	}
+	wg.Wait()
+	if f := logins.failures["user:admin"]; f == nil || f.count != 500 {
+		t.Fatalf("expected 500 failures counted, got %+v", f)
+	}
+}
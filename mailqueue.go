@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// smtpDryRun logs mail instead of sending it, for trying the blog out
+// without an SMTP server.
+var smtpDryRun = envBool("BLOG_SMTP_DRY_RUN", false)
+
+// mailEnabled reports whether there is anywhere for mail to go.
+func mailEnabled() bool {
+	return smtpHost != "" || smtpDryRun
+}
+
+// deliverMail sends msg to the addresses in to through the SMTP server, or
+// logs it in a dry run.
+func deliverMail(to []string, msg []byte) error {
+	if smtpDryRun {
+		infof("dry run, not mailing %s:\n%s", strings.Join(to, ", "), msg)
+		return nil
+	}
+	var auth smtp.Auth
+	if smtpUser != "" {
+		auth = smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+	}
+	return sendMail(net.JoinHostPort(smtpHost, smtpPort), auth, smtpFrom, to, msg)
+}
+
+// mailJob is one message waiting in a mailQueue.
+type mailJob struct {
+	// what the message is about, for the logs
+	about string
+	to    []string
+	msg   []byte
+}
+
+// mailQueue sends mail in the background, so a slow or unreachable SMTP
+// server holds up nobody's request. Failed sends are retried with
+// exponential backoff from delay, up to retries more times.
+type mailQueue struct {
+	jobs    chan mailJob
+	retries int
+	delay   time.Duration
+	send    func(to []string, msg []byte) error
+}
+
+func newMailQueue(size, retries int, delay time.Duration) *mailQueue {
+	return &mailQueue{
+		jobs:    make(chan mailJob, size),
+		retries: retries,
+		delay:   delay,
+		send:    deliverMail,
+	}
+}
+
+var mails = newMailQueue(100, 5, 30*time.Second)
+
+// enqueue queues msg for sending, dropping it when the queue is full rather
+// than waiting.
+func (q *mailQueue) enqueue(about string, to []string, msg []byte) bool {
+	select {
+	case q.jobs <- mailJob{about: about, to: to, msg: msg}:
+		return true
+	default:
+		errorf("mail queue full, dropping mail about %s", about)
+		return false
+	}
+}
+
+// run sends the queued mail, one message at a time, until the queue is
+// closed.
+func (q *mailQueue) run() {
+	for job := range q.jobs {
+		q.deliver(job)
+	}
+}
+
+func (q *mailQueue) deliver(job mailJob) {
+	delay := q.delay
+	for attempts := 1; ; attempts++ {
+		err := q.send(job.to, job.msg)
+		if err == nil {
+			return
+		}
+		if attempts > q.retries {
+			errorf("giving up on mail about %s after %d attempts: %v", job.about, attempts, err)
+			return
+		}
+		warnf("mail about %s failed on attempt %d, retrying in %v: %v", job.about, attempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/smtp"
+	"testing"
+)
+
+func TestMailQueueRetries(t *testing.T) {
+	q := newMailQueue(1, 2, 0)
+	attempts := 0
+	q.send = func(to []string, msg []byte) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	q.deliver(mailJob{about: "test", to: []string{"ada@example.com"}, msg: []byte("hi")})
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+
+	attempts = 0
+	q.send = func(to []string, msg []byte) error {
+		attempts++
+		return errors.New("connection refused")
+	}
+	q.deliver(mailJob{about: "test", to: []string{"ada@example.com"}, msg: []byte("hi")})
+	if attempts != 3 {
+		t.Fatalf("expected to give up after 3 attempts, got %d", attempts)
+	}
+}
+
+func TestMailQueueFull(t *testing.T) {
+	q := newMailQueue(1, 0, 0)
+	if !q.enqueue("first", []string{"ada@example.com"}, []byte("hi")) {
+		t.Fatal("expected the first mail to be queued")
+	}
+	if q.enqueue("second", []string{"ada@example.com"}, []byte("hi")) {
+		t.Fatal("expected the second mail to be dropped")
+	}
+}
+
+func TestDeliverMailDryRun(t *testing.T) {
+	useTestNewsletter(t)
+	old := smtpDryRun
+	smtpDryRun = true
+	t.Cleanup(func() { smtpDryRun = old })
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		t.Fatal("expected a dry run not to send mail")
+		return nil
+	}
+	if err := deliverMail([]string{"ada@example.com"}, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+}
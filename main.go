@@ -7,39 +7,179 @@ Presenting aww-crud: my first CRUD[1] app in go
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
-	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var validPath = regexp.MustCompile("^/(edit|save|show|delete)/([a-zA-Z0-9\\-]+)$")
 
+var validSlug = regexp.MustCompile("^[a-zA-Z0-9\\-]+$")
+
+var recordsDir = "records"
+
+// ErrSlugExists is returned by CreateRecord when the slug is already taken.
+var ErrSlugExists = errors.New("a post with this title already exists")
+
+// ErrNotFound is returned by the store when there is no record with the
+// requested slug. Check for it with errors.Is, it is usually wrapped.
+var ErrNotFound = errors.New("record not found")
+
+// ErrInvalidSlug is returned by the store for a slug that isn't safe to turn
+// into a file name.
+var ErrInvalidSlug = errors.New("invalid slug")
+
+const maxSlugSuffix = 100
+
+// slugCollision decides what creating a post with a taken slug does:
+// "reject" re-renders the form with an error, "suffix" appends -2, -3...
+var slugCollision = envOr("BLOG_SLUG_COLLISION", "reject")
+
+// immutableSlugs pins a post's slug when it is created, so editing the
+// title never changes its URL.
+var immutableSlugs = envOr("BLOG_IMMUTABLE_SLUGS", "") != ""
+
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
 type Record struct {
-	Title   string
-	Content string
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	Published bool      `json:"published"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Tags      []string  `json:"tags,omitempty"`
+	// Series groups multi-part posts; SeriesPart orders them within it
+	Series     string `json:"series,omitempty"`
+	SeriesPart int    `json:"series_part,omitempty"`
+	// Author is the username of whoever created the record
+	Author string `json:"author,omitempty"`
+	// Pinned records are listed above all others on the index
+	Pinned bool `json:"pinned,omitempty"`
+	// Featured records are highlighted above the index and on /featured
+	Featured bool `json:"featured,omitempty"`
+	// CanonicalURL is where search engines should send readers instead of
+	// here, for posts first published elsewhere
+	CanonicalURL string `json:"canonical_url,omitempty"`
+	// StoredSlug is set when the slug can't be derived from the title,
+	// e.g. after a collision was resolved with a numeric suffix, and on
+	// every record when slugs are immutable
+	StoredSlug string `json:"slug,omitempty"`
+	// Meta holds free-form key/value pairs, read them with GetMeta
+	Meta map[string]string `json:"meta,omitempty"`
+	// AccessPassword is the bcrypt hash of the passphrase a protected post
+	// needs to be read, see Protected
+	AccessPassword string `json:"access_password,omitempty"`
+	// IsTemplate records are starting points for new posts, listed on
+	// /admin/templates; keep them as drafts to keep them off the site
+	IsTemplate bool `json:"is_template,omitempty"`
+	// Visibility is public, unlisted or private; empty means public
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// ETag identifies this version of the record. The edit form sends it back
+// so a save can tell whether someone else changed the record meanwhile.
+func (r *Record) ETag() string {
+	sum := sha256.Sum256([]byte(r.Content + "\x00" + r.UpdatedAt.UTC().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(sum[:16])
 }
 
 func (r *Record) Slug() string {
-	slug := strings.ToLower(r.Title)
+	if r.StoredSlug != "" {
+		return r.StoredSlug
+	}
+	return slugify(r.Title)
+}
+
+// transliterations maps accented Latin letters to plain ASCII.
+var transliterations = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o",
+	'œ': "oe", 'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
 
-	// replace spaces with -
-	slug = strings.Replace(slug, " ", "-", -1)
+// slugify turns a title into a URL-safe slug: accented letters are
+// transliterated, every other run of non-alphanumerics becomes a single
+// hyphen, and a title with nothing usable left gets a hash instead.
+func slugify(title string) string {
+	if strings.TrimSpace(title) == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.ToLower(title) {
+		if t, ok := transliterations[c]; ok {
+			b.WriteString(t)
+			hyphen = false
+		} else if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+			hyphen = false
+		} else if !hyphen && b.Len() > 0 {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
 
-	// strip unwanted characters
-	re := regexp.MustCompile("[?&:!@#$%^*()]")
+	slug := truncateSlug(strings.Trim(b.String(), "-"), maxSlugLen)
+	if slug == "" {
+		sum := sha256.Sum256([]byte(title))
+		slug = "post-" + hex.EncodeToString(sum[:])[:10]
+	}
+	if reservedSlugs[slug] {
+		slug += "-post"
+	}
+	return slug
+}
 
-	return re.ReplaceAllLiteralString(slug, "")
+// truncateSlug shortens slug to at most max bytes, cutting at a hyphen when
+// there is one so no word is left half.
+func truncateSlug(slug string, max int) string {
+	if len(slug) <= max {
+		return slug
+	}
+	slug = slug[:max]
+	if i := strings.LastIndexByte(slug, '-'); i > 0 {
+		slug = slug[:i]
+	}
+	return strings.Trim(slug, "-")
 }
 
 func (r *Record) Save() error {
-	filename := "records/" + r.Slug() + ".json"
+	filename, err := recordPath(r.Slug())
+	if err != nil {
+		return err
+	}
+
+	r.UpdatedAt = time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = r.UpdatedAt
+	}
 
 	// serialize the data
 	fstring, err := json.Marshal(r)
@@ -47,178 +187,1093 @@ func (r *Record) Save() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(filename, fstring, 0600)
+	err = saveWithRetry(filename, fstring, saveRetries, saveRetryDelay)
 
 	if err != nil {
 		return err
 	}
 
+	titleIdx.invalidate()
+	sitemaps.reset()
+	if err := saveRevision(r.Slug(), fstring); err != nil {
+		errorf("unable to save revision of %s: %v", r.Slug(), err)
+	}
+	return nil
+}
+
+// CreateRecord writes a new record, never overwriting an existing one. When
+// the slug is taken it fails with ErrSlugExists, or with suffix set tries
+// slug-2, slug-3 and so on until a free one is found.
+func CreateRecord(r *Record, suffix bool) error {
+	base := r.Slug()
+	if err := checkSlug(base); err != nil {
+		return err
+	}
+	for n := 1; n <= maxSlugSuffix; n++ {
+		if n > 1 {
+			if !suffix {
+				break
+			}
+			tail := fmt.Sprintf("-%d", n)
+			r.StoredSlug = truncateSlug(base, maxSlugLen-len(tail)) + tail
+		}
+
+		if immutableSlugs && n == 1 {
+			r.StoredSlug = base
+		}
+		err := r.create()
+		if !os.IsExist(err) {
+			return err
+		}
+	}
+	r.StoredSlug = ""
+	return ErrSlugExists
+}
+
+// create is Save, except that the file must not exist yet. O_EXCL makes the
+// check and the create a single step so concurrent creates can't both win.
+func (r *Record) create() error {
+	filename, err := recordPath(r.Slug())
+	if err != nil {
+		return err
+	}
+
+	r.UpdatedAt = time.Now()
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = r.UpdatedAt
+	}
+	fstring, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(fstring); err != nil {
+		f.Close()
+		os.Remove(filename)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	titleIdx.invalidate()
+	sitemaps.reset()
+	if err := saveRevision(r.Slug(), fstring); err != nil {
+		errorf("unable to save revision of %s: %v", r.Slug(), err)
+	}
+	return nil
+}
+
+// writeFile is swapped out by tests to simulate a flaky filesystem.
+var writeFile = atomicWriteFile
+
+// writeTemp is swapped out by tests to fail part way through a write.
+var writeTemp = func(f *os.File, data []byte) (int, error) { return f.Write(data) }
+
+// atomicWriteFile writes data to a temporary file next to filename and
+// renames it into place, so readers only ever see the old or the new file in
+// full, never a partial write.
+func atomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	// the leading dot keeps AllRecords from seeing it
+	f, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := writeTemp(f, data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+const (
+	saveRetries    = 3
+	saveRetryDelay = 50 * time.Millisecond
+)
+
+// saveWithRetry writes data to filename, retrying up to retries more times
+// with exponential backoff from delay. Network filesystems fail like this
+// now and then.
+func saveWithRetry(filename string, data []byte, retries int, delay time.Duration) error {
+	attempts := 0
+	for {
+		attempts++
+		err := writeFile(filename, data, 0600)
+		if err == nil {
+			return nil
+		}
+		if attempts > retries {
+			return fmt.Errorf("writing %s failed after %d attempts: %w", filename, attempts, err)
+		}
+		warnf("writing %s failed on attempt %d, retrying in %v: %v", filename, attempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// RenameRecord saves r under its new slug and removes the record it used to
+// be stored as. The new file is created exclusively, so renaming onto
+// another post's slug fails with ErrSlugExists rather than overwriting it.
+func RenameRecord(oldSlug string, r *Record) error {
+	if _, err := recordPath(oldSlug); err != nil {
+		return err
+	}
+	if err := checkSlug(r.Slug()); err != nil {
+		return err
+	}
+	err := r.create()
+	if os.IsExist(err) {
+		return ErrSlugExists
+	} else if err != nil {
+		return err
+	}
+
+	if err := DeleteRecord(oldSlug); err != nil {
+		// don't leave the post behind twice
+		if filename, err := recordPath(r.Slug()); err == nil {
+			os.Remove(filename)
+		}
+		return err
+	}
+	if err := moveRevisions(oldSlug, r.Slug()); err != nil {
+		errorf("unable to move revisions of %s to %s: %v", oldSlug, r.Slug(), err)
+	}
+	if err := moveComments(oldSlug, r.Slug()); err != nil {
+		errorf("unable to move comments of %s to %s: %v", oldSlug, r.Slug(), err)
+	}
+	if err := moveTranslations(oldSlug, r.Slug()); err != nil {
+		errorf("unable to move translations of %s to %s: %v", oldSlug, r.Slug(), err)
+	}
+	if err := addAlias(oldSlug, r.Slug()); err != nil {
+		errorf("unable to redirect %s to %s: %v", oldSlug, r.Slug(), err)
+	}
 	return nil
 }
 
 func DeleteRecord(slug string) error {
-	filename := "records/" + slug + ".json"
-	return os.Remove(filename)
+	filename, err := recordPath(slug)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filename)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s: %w", ErrNotFound, slug, err)
+	}
+	titleIdx.invalidate()
+	sitemaps.reset()
+	return err
+}
+
+// recordPath is where the record with the given slug is stored.
+func recordPath(slug string) (string, error) {
+	return safePath(recordsDir, slug, ".json")
+}
+
+// safePath joins slug and ext onto dir. Slugs come from URLs, the API and
+// imports, so anything that isn't a plain slug is refused, and the result
+// is checked to still be inside dir in case validSlug is ever loosened.
+func safePath(dir, slug, ext string) (string, error) {
+	if !validSlug.MatchString(slug) || strings.Contains(slug, "..") {
+		return "", ErrInvalidSlug
+	}
+	p := filepath.Clean(filepath.Join(dir, slug+ext))
+	rel, err := filepath.Rel(filepath.Clean(dir), p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") || strings.ContainsRune(rel, filepath.Separator) {
+		return "", ErrInvalidSlug
+	}
+	return p, nil
 }
 
 func LoadRecord(slug string) (*Record, error) {
-	if slug == "" {
-		return nil, errors.New("empty slug")
+	filename, err := recordPath(slug)
+	if err != nil {
+		return nil, err
 	}
-	filename := "records/" + slug + ".json"
 	file, err := ioutil.ReadFile(filename)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s: %w", ErrNotFound, slug, err)
+	} else if err != nil {
 		return nil, err
 	}
-	var r Record
-	err = json.Unmarshal(file, &r)
+	r, err := parseRecordFile(file)
 	if err != nil {
+		if ve, ok := err.(*ValidationError); ok {
+			ve.File = filename
+		}
+		return nil, err
+	}
+	// the file name is the slug, even if the title would produce another
+	// one now, e.g. because slugify changed since the record was written.
+	// With immutable slugs it is pinned, and stays so on the next save.
+	if r.Slug() != slug || immutableSlugs {
+		r.StoredSlug = slug
+	}
+	// records written before timestamps existed have no creation time, so
+	// the file's is the best guess
+	if r.CreatedAt.IsZero() || r.UpdatedAt.IsZero() {
+		if fi, err := os.Stat(filename); err == nil {
+			if r.CreatedAt.IsZero() {
+				r.CreatedAt = fi.ModTime()
+			}
+			if r.UpdatedAt.IsZero() {
+				r.UpdatedAt = fi.ModTime()
+			}
+		}
+	}
+	return r, nil
+}
+
+func decodeRecord(data []byte) (*Record, error) {
+	// records written before drafts existed have no Published key
+	r := Record{Published: true}
+	if err := json.Unmarshal(data, &r); err != nil {
 		return nil, err
 	}
 	return &r, nil
 }
 
+func sortNewestFirst(records []*Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedAt.After(records[j].CreatedAt)
+	})
+}
+
+// sortRecentlyUpdated orders records by when they were last changed, most
+// recent first.
+func sortRecentlyUpdated(records []*Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].UpdatedAt.After(records[j].UpdatedAt)
+	})
+}
+
+// The orders the index can be listed in with ?sort=.
+const (
+	sortCreated = "created"
+	sortUpdated = "updated"
+)
+
+// indexSort reads the ?sort= parameter. Anything but a known order gets
+// the default, newest posts first.
+func indexSort(raw string) string {
+	if raw == sortUpdated {
+		return sortUpdated
+	}
+	return sortCreated
+}
+
+// adjacentRecords finds the published records created immediately before and
+// after the one with the given slug. Either may be nil at the ends.
+func adjacentRecords(records []*Record, slug string) (prev, next *Record) {
+	sorted := make([]*Record, 0, len(records))
+	for _, r := range records {
+		if (r.Published && r.Listed()) || r.Slug() == slug {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].Slug() < sorted[j].Slug()
+		}
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	for i, r := range sorted {
+		if r.Slug() != slug {
+			continue
+		}
+		if i > 0 {
+			prev = sorted[i-1]
+		}
+		if i < len(sorted)-1 {
+			next = sorted[i+1]
+		}
+		break
+	}
+	return prev, next
+}
+
+// LoadProblem is a file in recordsDir that couldn't be loaded as a record.
+type LoadProblem struct {
+	File string
+	Err  error
+}
+
+// AllRecords loads every record, skipping (and logging) any that are
+// broken so one bad file can't take the whole blog down.
 func AllRecords() ([]*Record, error) {
+	records, _, err := LoadAllRecords()
+	return records, err
+}
+
+// LoadAllRecords is AllRecords, but also reports the files it skipped. The
+// error is only set when recordsDir itself can't be read.
+func LoadAllRecords() ([]*Record, []LoadProblem, error) {
 	records := make([]*Record, 0)
-	files, err := ioutil.ReadDir("records")
+	var problems []LoadProblem
+	files, err := ioutil.ReadDir(recordsDir)
 	if os.IsNotExist(err) {
-		if err := os.Mkdir("records", os.ModePerm); err != nil {
-			return nil, err
+		if err := os.Mkdir(recordsDir, os.ModePerm); err != nil {
+			return nil, nil, err
 		}
 	} else if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	for _, f := range files {
+		// revisions live in a subdirectory, in-progress writes are hidden
+		// files and translations are listed with their post
+		if f.IsDir() || !isRecordFile(f.Name()) {
+			continue
+		}
 		r, err := LoadRecord(strings.TrimSuffix(f.Name(), ".json"))
 		if err != nil {
-			return nil, err
+			warnf("skipping record %s: %v", f.Name(), err)
+			problems = append(problems, LoadProblem{File: f.Name(), Err: err})
+			continue
 		}
 
 		records = append(records, r)
 	}
-	return records, nil
+	if len(problems) > 0 {
+		warnf("loaded %d records, skipped %d broken ones", len(records), len(problems))
+	}
+	return records, problems, nil
+}
+
+// TemplateData is what every page template is executed with.
+type TemplateData struct {
+	*Record
+	Records []*Record
+	Flash   *Flash
+	// Prev and Next are the neighbouring posts on the show page
+	Prev *Record
+	Next *Record
+	// Errors are shown above a form that couldn't be submitted
+	Errors []string
+	// Revisions and Diff are used by the history pages
+	Revisions []time.Time
+	Diff      []DiffLine
+	// Query is what was searched for, and Results what it found
+	Query   string
+	Results []SearchResult
+	// Stats is shown on the admin dashboard, SiteStats on /admin/stats
+	Stats     *DashboardStats
+	SiteStats *SiteStats
+	// Pagination is set on listing pages
+	Pagination *Pagination
+	// Problems lists the record files the admin dashboard couldn't load
+	Problems []LoadProblem
+	// Preview names the form ("new" or "edit") an unsaved record came from
+	Preview string
+	// EditSlug is the slug of the record the edit form saves to, which may
+	// differ from the one the submitted title produces
+	EditSlug string
+	// EditETag is the ETag of the record as it was when editing started
+	EditETag string
+	// CSRFToken goes in every form that changes something; templates put it
+	// there with {{ csrfField .CSRFToken }}
+	CSRFToken string
+	// ReturnTo is where the login form goes after a successful login
+	ReturnTo string
+	// SeriesParts lists the series the record, or the listing, is for;
+	// PrevPart and NextPart are the record's neighbours in it
+	SeriesName  string
+	SeriesParts []*Record
+	PrevPart    *Record
+	NextPart    *Record
+	// PinnedRecords go above Records on the first page of the index
+	PinnedRecords []*Record
+	// FeaturedRecords are shown prominently above them
+	FeaturedRecords []*Record
+	// Sort is the order the index is listed in, sortCreated or sortUpdated
+	Sort string
+	// OpenGraph describes the post on the show page for social sites
+	OpenGraph *OpenGraph
+	// Canonical is the post's preferred address, for search engines
+	Canonical string
+	// TagCloud is every tag in use, and Tag the tag a listing is for
+	TagCloud []TagCount
+	Tag      string
+	// TagView is how /tags shows them, as a cloud or a list
+	TagView string
+	// SiteTitle names the blog in every page's head
+	SiteTitle string
+	// Comments are shown under a post, with a form for another when
+	// CanComment is set
+	Comments   []*Comment
+	CanComment bool
+	// CommentThreads are Comments arranged by who replied to whom, and
+	// ReplyTo the one the comment form replies to, if any
+	CommentThreads []*CommentNode
+	ReplyTo        *Comment
+	// CommentStamp goes in the comment form, to tell how long it was open
+	CommentStamp string
+	// PendingComments are the comments awaiting moderation
+	PendingComments []PendingComment
+	// ModerationAction is what following a notification's ModerationURL
+	// does to the comment in PendingComments
+	ModerationAction string
+	ModerationURL    string
+	// Lang is the language the post is shown in, and Languages those it can
+	// be read in, for the language switcher
+	Lang      string
+	Languages []string
+	// GitHubLogin offers logging in with GitHub on the login page
+	GitHubLogin bool
+}
+
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data *TemplateData) {
+	renderTemplateStatus(w, r, http.StatusOK, tmpl, data)
 }
 
-func renderTemplate(w http.ResponseWriter, tmpl string, r *Record) {
-	t, err := template.ParseFiles("templates/" + tmpl + ".html")
+func renderTemplateStatus(w http.ResponseWriter, r *http.Request, status int, tmpl string, data *TemplateData) {
+	t, err := currentTemplates()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = t.Execute(w, r)
+	if data == nil {
+		data = &TemplateData{}
+	}
+	data.Flash = popFlash(w, r)
+	data.CSRFToken = csrfToken(r)
+	data.SiteTitle = siteTitle
+
+	// render to a buffer so a template error can still become a clean 500
+	var buf bytes.Buffer
+	err = t.ExecuteTemplate(&buf, tmpl+".html", data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		errorf("ignoring invalid %s=%q: %v", key, v, err)
+		return def
+	}
+	return n
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		errorf("ignoring invalid %s=%q: %v", key, v, err)
+		return def
 	}
+	return b
 }
 
 func getSlug(r *http.Request) string {
-	log.Printf("url %s", r.URL.Path)
+	debugf("url %s", r.URL.Path)
 	m := validPath.FindStringSubmatch(r.URL.Path)
 	if m == nil {
-		log.Print("error: 'm' is nil")
+		debugf("no slug in %s", r.URL.Path)
 		// leave early or this will panic as it will be a index out of bounds
 		return ""
 	}
-	log.Printf("slug is %s", m[2])
+	debugf("slug is %s", m[2])
 	return m[2]
 }
 
 func showHandler(w http.ResponseWriter, r *http.Request) {
 	slug := getSlug(r)
 	rec, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) {
+		// the post may have been renamed since the link was made
+		if to, ok := resolveAlias(slug); ok {
+			http.Redirect(w, r, "/show/"+to, http.StatusMovedPermanently)
+			return
+		}
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to load %s: %v", slug, err)
+		http.Error(w, "unable to load the post", http.StatusInternalServerError)
+		return
+	}
+	if !visibleTo(r, rec) {
+		// as if it didn't exist, so as not to confirm that it does
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	}
+	if !canRead(r, rec) {
+		unlockHandler(w, r, rec)
+		return
+	}
+	if rec.Protected() {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	records, err := AllRecords()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("did not find the desired record: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("unable to load all records: %v", err), http.StatusInternalServerError)
 		return
 	}
-	renderTemplate(w, "show", rec)
+	views.add(rec.Slug())
+	prev, next := adjacentRecords(records, slug)
+
+	langs, err := translations(rec.Slug())
+	if err != nil {
+		errorf("unable to list translations of %s: %v", slug, err)
+	}
+	lang := pickLanguage(r, langs)
+	w.Header().Set("Vary", "Accept-Language")
+	if lang != defaultLang {
+		t, err := LoadTranslation(rec.Slug(), lang)
+		if err != nil {
+			errorf("unable to load the %s translation of %s: %v", lang, slug, err)
+			lang = defaultLang
+		} else {
+			rec = translated(rec, t)
+		}
+	}
+
+	data := &TemplateData{Record: rec, Prev: prev, Next: next, OpenGraph: openGraphFor(r, rec), Canonical: canonicalURL(baseURL(r), rec), CanComment: commentable(r, rec)}
+	data.Lang, data.Languages = lang, append([]string{defaultLang}, langs...)
+	if data.CanComment {
+		data.CommentStamp = commentStamp(rec.Slug(), time.Now())
+	}
+	if comments, err := LoadComments(rec.Slug()); err != nil {
+		errorf("unable to load comments on %s: %v", slug, err)
+	} else {
+		data.Comments = approvedComments(comments)
+		data.CommentThreads = buildCommentTree(data.Comments, commentMaxDepth)
+		if id := r.URL.Query().Get("reply"); id != "" && data.CanComment {
+			data.ReplyTo = findComment(data.Comments, id, true)
+		}
+	}
+	if rec.Series != "" {
+		data.SeriesParts, data.PrevPart, data.NextPart, err = seriesNav(r, rec)
+		if err != nil {
+			errorf("unable to load series of %s: %v", slug, err)
+		}
+	}
+	renderTemplate(w, r, "show", data)
 }
 
 func editHandler(w http.ResponseWriter, r *http.Request) {
 	slug := getSlug(r)
 	rec, err := LoadRecord(slug)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to load %s: %v", slug, err)
+		http.Error(w, "unable to load the post", http.StatusInternalServerError)
 		return
 	}
-	renderTemplate(w, "edit", rec)
+	if !allowed(r, actionEdit, rec) {
+		forbidden(w, r, "edit this post")
+		return
+	}
+	renderTemplate(w, r, "edit", &TemplateData{Record: rec, EditSlug: slug, EditETag: rec.ETag()})
+}
+
+type formMode int
+
+const (
+	modeCreate formMode = iota
+	modeUpdate
+)
+
+// parseRecordForm builds the record a new or edit form describes and
+// validates it. When updating, the form is applied over a copy of existing
+// so everything the form doesn't carry (creation time, tags...) is kept.
+func parseRecordForm(r *http.Request, mode formMode, existing *Record) (*Record, []string) {
+	rec := &Record{}
+	if mode == modeUpdate {
+		*rec = *existing
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	if mode == modeUpdate && title != existing.Title && !immutableSlugs {
+		// a new title means a new slug, unless it was pinned
+		rec.StoredSlug = ""
+	}
+	rec.Title = title
+	rec.Content = r.FormValue("content")
+	rec.Published = r.FormValue("published") != ""
+	rec.Pinned = r.FormValue("pinned") != ""
+	rec.IsTemplate = r.FormValue("is_template") != ""
+
+	errs := validateRecord(rec)
+	// a form without the field leaves the visibility as it was
+	if _, ok := r.Form["visibility"]; ok {
+		if v := r.FormValue("visibility"); validVisibility(v) {
+			rec.Visibility = v
+			if v == visibilityPublic {
+				rec.Visibility = ""
+			}
+		} else {
+			errs = append(errs, "Visibility must be public, unlisted or private")
+		}
+	}
+	// the passphrase is only sent to set or change it
+	if pass := r.FormValue("access_password"); pass != "" {
+		if hash, err := hashPassword(pass); err != nil {
+			errorf("unable to hash a passphrase: %v", err)
+			errs = append(errs, "unable to set the passphrase")
+		} else {
+			rec.AccessPassword = hash
+		}
+	} else if r.FormValue("remove_access_password") != "" {
+		rec.AccessPassword = ""
+	}
+	// a form without the field leaves the metadata as it was
+	if _, ok := r.Form["meta"]; ok {
+		meta, metaErrs := parseMeta(r.FormValue("meta"))
+		rec.Meta = meta
+		errs = append(errs, metaErrs...)
+	}
+	if _, ok := r.Form["series"]; ok {
+		errs = append(errs, parseSeriesForm(r, rec)...)
+	}
+	if _, ok := r.Form["canonical_url"]; ok {
+		rec.CanonicalURL = strings.TrimSpace(r.FormValue("canonical_url"))
+	}
+	return rec, errs
 }
 
 func saveHandler(w http.ResponseWriter, r *http.Request) {
-	title := r.FormValue("title")
-	content := r.FormValue("content")
-	rec := &Record{Title: title, Content: content}
-	err := rec.Save()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		// do not redirect or error message will be lost
+	slug := getSlug(r)
+	if slug == "" {
+		http.Error(w, "missing slug in URL", http.StatusBadRequest)
 		return
 	}
-	http.Redirect(w, r, "/show/"+rec.Slug(), http.StatusFound)
+	upsertRecord(w, r, slug)
 }
 
 func createHandler(w http.ResponseWriter, r *http.Request) {
-	title := r.FormValue("title")
-	content := r.FormValue("content")
-	rec := &Record{Title: title, Content: content}
-	err := rec.Save()
-	if err != nil {
+	upsertRecord(w, r, "")
+}
+
+// upsertRecord handles both post forms: with existingSlug "" it creates a
+// post from the new form, otherwise it saves the edit form over the post
+// stored as existingSlug, moving it when the new title changes its slug.
+func upsertRecord(w http.ResponseWriter, r *http.Request, existingSlug string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	page, mode := "new", modeCreate
+	var old *Record
+	if existingSlug != "" {
+		page, mode = "edit", modeUpdate
+		var err error
+		old, err = LoadRecord(existingSlug)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "no such post to save", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !allowed(r, actionEdit, old) {
+			forbidden(w, r, "edit this post")
+			return
+		}
+	} else if !allowed(r, actionCreate, nil) {
+		forbidden(w, r, "write posts")
+		return
+	}
+
+	etag := r.FormValue("etag")
+	rec, errs := parseRecordForm(r, mode, old)
+	// fail shows the form again, with what went wrong
+	fail := func(status int, etag string, errs ...string) {
+		renderTemplateStatus(w, r, status, page, &TemplateData{Record: rec, EditSlug: existingSlug, EditETag: etag, Errors: errs})
+	}
+	if len(errs) > 0 {
+		fail(http.StatusUnprocessableEntity, etag, errs...)
+		return
+	}
+	// forms from before ETags existed don't send one
+	if old != nil && etag != "" && etag != old.ETag() {
+		// resubmitting now overwrites the other change, as the message says
+		fail(http.StatusConflict, old.ETag(), "This post was modified by another user since you started editing. Saving again will overwrite their changes.")
+		return
+	}
+	if rec.Author == "" {
+		rec.Author = currentUser(r)
+	}
+
+	var err error
+	switch {
+	case old == nil:
+		err = CreateRecord(rec, slugCollision == "suffix")
+	case rec.Slug() != existingSlug:
+		// the title change moved the post to a new slug
+		err = RenameRecord(existingSlug, rec)
+		if err == nil {
+			views.rename(existingSlug, rec.Slug())
+		}
+	default:
+		err = rec.Save()
+	}
+	if errors.Is(err, ErrSlugExists) {
+		fail(http.StatusConflict, etag, err.Error())
+		return
+	} else if errors.Is(err, ErrInvalidSlug) {
+		fail(http.StatusUnprocessableEntity, etag, err.Error())
+		return
+	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		// do not redirect or error message will be lost
 		return
 	}
+
+	announce(baseURL(r), old, rec)
+	if old == nil {
+		setFlash(w, flashInfo, "Post created")
+	} else {
+		setFlash(w, flashInfo, "Post saved")
+	}
 	http.Redirect(w, r, "/show/"+rec.Slug(), http.StatusFound)
 }
 
 func newHandler(w http.ResponseWriter, r *http.Request) {
-	t, err := template.ParseFiles("templates/new.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !allowed(r, actionCreate, nil) {
+		forbidden(w, r, "write posts")
+		return
 	}
-	err = t.Execute(w, nil)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var data *TemplateData
+	if slug := r.URL.Query().Get("template"); slug != "" {
+		tmpl, err := loadTemplate(slug)
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			errorf("unable to load template %s: %v", slug, err)
+			http.Error(w, "unable to load the template", http.StatusInternalServerError)
+			return
+		}
+		data = &TemplateData{Record: fromTemplate(tmpl, currentUser(r), time.Now())}
+	}
+	renderTemplate(w, r, "new", data)
+}
+
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from := r.FormValue("from")
+	if from == "" {
+		// a bare content field is the edit page asking for a live preview
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, string(renderContent(r.FormValue("content"))))
+		return
+	}
+	if from != "new" && from != "edit" {
+		http.Error(w, "unknown form to preview", http.StatusBadRequest)
+		return
 	}
+
+	// never Save() here, the record only lives for this response
+	rec, _ := parseRecordForm(r, modeCreate, nil)
+	data := &TemplateData{Record: rec, EditSlug: r.FormValue("slug"), EditETag: r.FormValue("etag")}
+	if r.FormValue("back") != "" {
+		renderTemplate(w, r, from, data)
+		return
+	}
+	data.Preview = from
+	renderTemplate(w, r, "show", data)
 }
 
+// deleteHandler asks for confirmation on GET and only deletes on POST, so
+// crawlers and link prefetching can't remove posts.
 func deleteHandler(w http.ResponseWriter, r *http.Request) {
 	slug := getSlug(r)
-	err := DeleteRecord(slug)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rec, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to load %s: %v", slug, err)
+		http.Error(w, "unable to load the post", http.StatusInternalServerError)
+		return
+	}
+	if !allowed(r, actionDelete, rec) {
+		forbidden(w, r, "delete this post")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		renderTemplate(w, r, "delete", &TemplateData{Record: rec})
+	case http.MethodPost:
+		err = DeleteRecord(slug)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "post not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			errorf("unable to delete %s: %v", slug, err)
+			http.Error(w, "unable to delete the post", http.StatusInternalServerError)
+			return
+		}
+		if err := deleteComments(slug); err != nil {
+			errorf("unable to delete the comments on %s: %v", slug, err)
+		}
+		if err := deleteTranslations(slug); err != nil {
+			errorf("unable to delete the translations of %s: %v", slug, err)
+		}
+		if err := deleteRevisions(slug); err != nil {
+			errorf("unable to delete the history of %s: %v", slug, err)
+		}
+		setFlash(w, flashInfo, "Post deleted")
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
 
+func randomHandler(w http.ResponseWriter, r *http.Request) {
+	records, err := AllRecords()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("unable to load all records: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	published := make([]*Record, 0, len(records))
+	for _, rec := range records {
+		if rec.Published && rec.Listed() {
+			published = append(published, rec)
+		}
+	}
+	if len(published) == 0 {
+		http.Error(w, "there are no posts yet", http.StatusNotFound)
 		return
 	}
-	http.Redirect(w, r, "/", http.StatusFound)
+
+	rndMu.Lock()
+	rec := published[rnd.Intn(len(published))]
+	rndMu.Unlock()
+	http.Redirect(w, r, "/show/"+rec.Slug(), http.StatusFound)
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
-	records, err := AllRecords()
+	order := indexSort(r.URL.Query().Get("sort"))
+	base := "/"
+	if order != sortCreated {
+		base = "/?sort=" + order
+	}
+	raw := r.URL.Query().Get("page")
+	if raw == "1" {
+		http.Redirect(w, r, base, http.StatusMovedPermanently)
+		return
+	}
+	page, err := parsePage(raw)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("unable to load all records: %v", err), http.StatusInternalServerError)
+		http.Error(w, "invalid page number", http.StatusBadRequest)
 		return
 	}
 
-	t, err := template.ParseFiles("templates/index.html")
+	records, err := AllRecords()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("unable to parse file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("unable to load all records: %v", err), http.StatusInternalServerError)
 		return
 	}
+	// unlisted posts are only found by their link, private ones only by
+	// those logged in
+	loggedIn := currentUser(r) != ""
+	shown := records[:0]
+	for _, rec := range records {
+		if rec.Listed() || (rec.Private() && loggedIn) {
+			shown = append(shown, rec)
+		}
+	}
+	pinned, records := splitPinned(shown)
+	if order == sortUpdated {
+		sortRecentlyUpdated(records)
+	} else {
+		sortNewestFirst(records)
+	}
 
-	err = t.Execute(w, records)
+	p, err := paginate(len(records), page, pageSize, base)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("unable to render template: %v", err), http.StatusInternalServerError)
+		http.NotFound(w, r)
+		return
 	}
+	data := &TemplateData{Records: records[p.Start:p.End], Pagination: p, Sort: order}
+	if p.Page == 1 {
+		data.PinnedRecords = pinned
+		data.FeaturedRecords = featuredRecords(shown, maxHeroRecords)
+	}
+	renderTemplate(w, r, "index", data)
 }
 
 func main() {
+	themeFlag := flag.String("theme", "", "theme to use, overriding BLOG_THEME and the saved site setting")
+	flag.StringVar(&authUser, "username", authUser, "username for editing and admin pages, defaults to BLOG_USERNAME")
+	flag.StringVar(&authPass, "password", authPass, "password for editing and admin pages, defaults to BLOG_PASSWORD")
+	flag.BoolVar(&insecure, "insecure", false, "allow anyone to create, edit and delete posts when no credentials are set")
+	flag.BoolVar(&readOnly, "readonly", readOnly, "serve the blog read-only, refusing all changes, defaults to BLOG_READONLY")
+	flag.BoolVar(&publicAPIReads, "public-api", false, "allow reading the JSON API without an API key")
+	flag.Int64Var(&maxPostSize, "max-post-size", maxPostSize, "largest form post accepted, in bytes")
+	flag.BoolVar(&smtpDryRun, "smtp-dry-run", smtpDryRun, "log mail instead of sending it, defaults to BLOG_SMTP_DRY_RUN")
+	flag.Int64Var(&maxUploadSize, "max-upload-size", maxUploadSize, "largest file upload accepted, in bytes")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "apikey":
+		if err := runAPIKeyCommand(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "user":
+		if err := runUserCommand(flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "import":
+		if err := runImportCommand(flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	go writes.cleanupLoop(time.Minute)
+	go logins.cleanupLoop(time.Minute)
+	go basicAuths.cleanupLoop(time.Minute)
+	go commentLimiter.cleanupLoop(time.Minute)
+	go idempotencyKeys.cleanupLoop(time.Minute)
+
 	http.HandleFunc("/", indexHandler)
-	http.HandleFunc("/show/", showHandler)
-	http.HandleFunc("/edit/", editHandler)
-	http.HandleFunc("/save/", saveHandler)
-	http.HandleFunc("/new/", newHandler)
-	http.HandleFunc("/create/", createHandler)
-	http.HandleFunc("/delete/", deleteHandler)
-	log.Println("Starting server on localhost:5050/")
-	log.Fatal(http.ListenAndServe(":5050", nil))
+	http.HandleFunc("/show/", requireFormContentType(limitBody(showHandler)))
+	http.HandleFunc("/edit/", requireWritable(requireWriteAuth(editHandler)))
+	http.HandleFunc("/save/", writes.limit(requireWritable(requireWriteAuth(requireFormContentType(limitBody(requireCSRF(saveHandler)))))))
+	http.HandleFunc("/new/", requireWritable(requireWriteAuth(newHandler)))
+	http.HandleFunc("/create/", writes.limit(requireWritable(requireWriteAuth(requireFormContentType(limitBody(requireCSRF(createHandler)))))))
+	http.HandleFunc("/delete/", writes.limit(requireWritable(requireWriteAuth(requireFormContentType(limitBody(requireCSRF(deleteHandler)))))))
+	http.HandleFunc("/comment/", writes.limit(requireWritable(requireFormContentType(limitBody(requireCSRF(commentHandler))))))
+	http.HandleFunc("/random", randomHandler)
+	http.HandleFunc("/history/", historyHandler)
+	http.HandleFunc("/diff/", diffHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/search/suggestions", suggestionsHandler)
+	http.HandleFunc("/tags", tagCloudHandler)
+	http.HandleFunc("/tag/", tagHandler)
+	http.HandleFunc("/series/", seriesHandler)
+	http.HandleFunc("/featured", featuredHandler)
+	http.HandleFunc("/feed.json", jsonFeedHandler)
+	http.HandleFunc("/feed.rss", rssFeedHandler)
+	http.HandleFunc("/feed.atom", atomFeedHandler)
+	http.HandleFunc("/subscribe", writes.limit(requireWritable(requireFormContentType(limitBody(requireCSRF(subscribeHandler))))))
+	http.HandleFunc("/unsubscribe", requireWritable(unsubscribeHandler))
+	http.HandleFunc("/robots.txt", robotsHandler)
+	http.HandleFunc("/sitemap-index.xml", sitemapIndexHandler)
+	http.HandleFunc("/sitemap/", monthlySitemapHandler)
+	http.HandleFunc("/preview", requireFormContentType(limitBody(previewHandler)))
+	http.HandleFunc("/api/records", withCORS(readWrite(requireAPIReadKey(listRecordsHandler), writes.limit(requireWritable(requireAPIKey(idempotencyKeys.idempotent(createRecordHandler)))))))
+	http.HandleFunc("/api/records/", withCORS(apiRecordsHandler))
+	http.HandleFunc("/api/records/delete", withCORS(writes.limit(requireWritable(requireAPIKey(bulkDeleteHandler)))))
+	http.HandleFunc("/api/stats", withCORS(requireAPIKey(apiStatsHandler)))
+	http.HandleFunc("/api/featured", withCORS(requireAPIReadKey(apiFeaturedHandler)))
+	http.HandleFunc("/api/openapi.json", withCORS(openAPIHandler))
+	http.HandleFunc("/api/docs", apiDocsHandler)
+	http.HandleFunc("/login", requireFormContentType(limitBody(loginHandler)))
+	http.HandleFunc("/login/github", githubLoginHandler)
+	http.HandleFunc("/oauth/callback", oauthCallbackHandler)
+	http.HandleFunc("/logout", requireFormContentType(limitBody(requireCSRF(logoutHandler))))
+	http.HandleFunc("/admin", requireLogin(requireManage(adminHandler)))
+	http.HandleFunc("/admin/templates", requireLogin(templatesHandler))
+	http.HandleFunc("/admin/comments", requireLogin(requireManage(moderationHandler)))
+	http.HandleFunc("/admin/comments/", requireWritable(requireFormContentType(limitBody(withModerationToken(moderateByTokenHandler, requireLogin(requireManage(requireCSRF(moderateHandler))))))))
+	http.HandleFunc("/admin/stats", requireLogin(requireManage(statsHandler)))
+	http.HandleFunc("/admin/pin/", requireLogin(requireWritable(requireFormContentType(limitBody(requireCSRF(pinHandler))))))
+	http.HandleFunc("/admin/unpin/", requireLogin(requireWritable(requireFormContentType(limitBody(requireCSRF(pinHandler))))))
+	http.HandleFunc("/admin/feature/", requireLogin(requireWritable(requireFormContentType(limitBody(requireCSRF(featureHandler))))))
+	http.HandleFunc("/admin/unfeature/", requireLogin(requireWritable(requireFormContentType(limitBody(requireCSRF(featureHandler))))))
+	http.HandleFunc("/admin/disk-usage", requireAuth(diskUsageHandler))
+	http.HandleFunc("/admin/rebuild-index", requireAuth(requireWritable(requireFormContentType(limitBody(requireCSRF(rebuildIndexHandler))))))
+	http.HandleFunc("/admin/theme", requireLogin(requireManage(requireWritable(requireFormContentType(limitBody(requireCSRF(themeHandler)))))))
+	http.HandleFunc("/admin/theme/reload", requireLogin(requireManage(requireWritable(requireFormContentType(limitBody(requireCSRF(reloadThemeHandler)))))))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.Handle("/static/", http.StripPrefix("/static/", staticHandler(themeFS{})))
+	if err := setupLogging(); err != nil {
+		log.Fatalf("unable to open log file: %v", err)
+	}
+	if err := users.load(usersFile); err != nil {
+		log.Fatalf("unable to load %s: %v", usersFile, err)
+	}
+	go users.watch(usersFile, 5*time.Second)
+	if !authConfigured() {
+		if !insecure {
+			log.Fatal("no credentials configured: add a user with \"user add\", set BLOG_USERNAME and BLOG_PASSWORD, set up GitHub login with BLOG_GITHUB_*, or pass -insecure to let anyone edit posts")
+		}
+		warnf("running without credentials, anyone can create, edit and delete posts")
+	}
+	if err := views.load(viewsFile); err != nil {
+		log.Fatalf("unable to load %s: %v", viewsFile, err)
+	}
+	go views.flushLoop(viewsFile, time.Minute)
+	go purgeRejectedCommentsLoop(time.Hour)
+	go mails.run()
+	if err := loadConfig(); err != nil {
+		log.Fatalf("unable to load %s: %v", configFile, err)
+	}
+	if err := loadShortcodes(shortcodesFile); err != nil {
+		log.Fatalf("unable to load %s: %v", shortcodesFile, err)
+	}
+	if err := verifyTemplates(); err != nil {
+		log.Fatalf("bad templates directory: %v", err)
+	}
+	name := currentTheme()
+	if *themeFlag != "" {
+		name = *themeFlag
+	}
+	if !themeExists(name) {
+		log.Fatalf("unknown theme %q", name)
+	}
+	if err := setTheme(name); err != nil {
+		log.Fatalf("unable to load theme %q: %v", name, err)
+	}
+	log.Fatal(serve(securityHeaders(http.DefaultServeMux)))
 }
@@ -1,6 +1,31 @@
 package main
 
-import "testing"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// every test request comes from the same address, so the shared write
+	// limiter would start refusing them partway through the run; tests of
+	// the limits install their own
+	writes = newRateLimiter(0)
+	os.Exit(m.Run())
+}
 
 func TestSlug(t *testing.T) {
 	tt := []struct {
@@ -8,19 +33,998 @@ func TestSlug(t *testing.T) {
 		title string
 		slug  string
 	}{
-		{"&, :, and ()", "Jack & Jill: The Untold Story (Part 2)", "jack--jill-the-untold-story-part-2"},
-		{"! and @", "Email me at me@myself.com", "email-me-at-memyself.com"},
+		{"&, :, and ()", "Jack & Jill: The Untold Story (Part 2)", "jack-jill-the-untold-story-part-2"},
+		{"! and @", "Email me at me@myself.com", "email-me-at-me-myself-com"},
 		{"$ and %", "$50 is 50% off $100", "50-is-50-off-100"},
-		{"kitchen sink", "Raindrops & Roses: Hey! me@myself.com #1 $300.00 10% off x^2 7 * 6 = 42 (the untold story)", "raindrops--roses-hey-memyself.com-1-300.00-10-off-x2-7--6-=-42-the-untold-story"},
+		{"kitchen sink", "Raindrops & Roses: Hey! me@myself.com #1 $300.00 10% off x^2 7 * 6 = 42 (the untold story)", "raindrops-roses-hey-me-myself-com-1-300-00-10-off-x-2-7-6-42-the-untold-story"},
+		{"accents", "Café écrit à Zürich", "cafe-ecrit-a-zurich"},
+		{"ligatures and eszett", "Æsop's Straße", "aesop-s-strasse"},
+		{"multiple spaces", "  lots   of\tspace  ", "lots-of-space"},
+		{"existing hyphens", "--already-hyphenated -- title--", "already-hyphenated-title"},
+		{"mixed scripts", "Go 语言 tips", "go-tips"},
+		{"cyrillic", "Привет, мир!", "post-" + titleHash("Привет, мир!")},
+		{"cjk", "日本語のブログ", "post-" + titleHash("日本語のブログ")},
+		{"emoji", "🎉🎉🎉", "post-" + titleHash("🎉🎉🎉")},
+		{"empty", "", ""},
 	}
 
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
 			rec := &Record{Title: tc.title, Content: ""}
-			s := rec.Slug();
+			s := rec.Slug()
 			if s != tc.slug {
 				t.Fatalf("\nexpected: %s\nactual: %s", tc.slug, s)
 			}
+			if s != "" && !validPath.MatchString("/show/"+s) {
+				t.Fatalf("slug %q is not reachable through /show/", s)
+			}
+		})
+	}
+}
+
+func titleHash(title string) string {
+	sum := sha256.Sum256([]byte(title))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+func TestLoadRecordKeepsFileSlug(t *testing.T) {
+	dir := useTempRecords(t)
+
+	// written by an older slug algorithm
+	data := []byte(`{"title":"Jack & Jill"}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "jack--jill.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := LoadRecord("jack--jill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Slug() != "jack--jill" {
+		t.Fatalf("expected the file's slug to be kept, got %s", rec.Slug())
+	}
+}
+
+// useTempRecords points the store at a fresh directory for the duration of a test.
+func useTempRecords(t *testing.T) string {
+	t.Helper()
+	old := recordsDir
+	recordsDir = t.TempDir()
+	t.Cleanup(func() { recordsDir = old })
+	return recordsDir
+}
+
+func TestRandomHandler(t *testing.T) {
+	useTempRecords(t)
+
+	w := httptest.NewRecorder()
+	randomHandler(w, httptest.NewRequest("GET", "/random", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with no records, got %d", w.Code)
+	}
+
+	for _, rec := range []*Record{
+		{Title: "Published One", Published: true},
+		{Title: "Draft One", Published: false},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		randomHandler(w, httptest.NewRequest("GET", "/random", nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("expected 302, got %d", w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != "/show/published-one" {
+			t.Fatalf("expected redirect to the published post, got %s", loc)
+		}
+	}
+}
+
+func TestPreviewHandler(t *testing.T) {
+	dir := useTempRecords(t)
+
+	tt := []struct {
+		name string
+		form url.Values
+		want string
+	}{
+		{"preview new", url.Values{"from": {"new"}, "title": {"Draft Title"}, "content": {"hello <world>"}}, "Back to editing"},
+		{"preview edit", url.Values{"from": {"edit"}, "title": {"Draft Title"}, "content": {"hello <world>"}}, "Back to editing"},
+		{"back to new", url.Values{"from": {"new"}, "title": {"Draft Title"}, "content": {"hello <world>"}, "back": {"1"}}, "hello &lt;world&gt;</textarea>"},
+		{"back to edit", url.Values{"from": {"edit"}, "slug": {"original"}, "title": {"Draft Title"}, "content": {"hello <world>"}, "back": {"1"}}, `action="/save/original"`},
+		{"fragment", url.Values{"content": {"# Hi\n\n*there* <script>alert(1)</script>"}}, "<h1>Hi</h1>\n<p><em>there</em> &lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/preview", strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			previewHandler(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tc.want) {
+				t.Fatalf("expected body to contain %q, got:\n%s", tc.want, w.Body)
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 0 {
+				t.Fatalf("preview wrote %d files to the records directory", len(files))
+			}
+		})
+	}
+}
+
+func TestPreviewHandlerRejectsGet(t *testing.T) {
+	w := httptest.NewRecorder()
+	previewHandler(w, httptest.NewRequest("GET", "/preview?from=new&title=x", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
+
+func TestAdjacentRecords(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	records := []*Record{
+		{Title: "third", Published: true, CreatedAt: day(3)},
+		{Title: "first", Published: true, CreatedAt: day(1)},
+		{Title: "draft", Published: false, CreatedAt: day(2)},
+		{Title: "second", Published: true, CreatedAt: day(2)},
+	}
+
+	tt := []struct {
+		slug string
+		prev string
+		next string
+	}{
+		{"first", "", "second"},
+		{"second", "first", "third"},
+		{"third", "second", ""},
+		// a draft still gets neighbours, but is never one itself
+		{"draft", "first", "second"},
+		{"missing", "", ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.slug, func(t *testing.T) {
+			prev, next := adjacentRecords(records, tc.slug)
+			if got := slugOf(prev); got != tc.prev {
+				t.Fatalf("prev: expected %q, got %q", tc.prev, got)
+			}
+			if got := slugOf(next); got != tc.next {
+				t.Fatalf("next: expected %q, got %q", tc.next, got)
+			}
+		})
+	}
+}
+
+func slugOf(r *Record) string {
+	if r == nil {
+		return ""
+	}
+	return r.Slug()
+}
+
+func TestCreateRecordCollisions(t *testing.T) {
+	tt := []struct {
+		name   string
+		suffix bool
+		want   []string
+	}{
+		{"reject", false, []string{"hello"}},
+		{"suffix", true, []string{"hello", "hello-2", "hello-3", "hello-4", "hello-5"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			useTempRecords(t)
+
+			// every create races for the same slug
+			const n = 5
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- CreateRecord(&Record{Title: "Hello", Content: fmt.Sprint(i)}, tc.suffix)
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+
+			failed := 0
+			for err := range errs {
+				if err == ErrSlugExists {
+					failed++
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if failed != n-len(tc.want) {
+				t.Fatalf("expected %d creates to be rejected, got %d", n-len(tc.want), failed)
+			}
+
+			records, err := AllRecords()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(records))
+			for _, rec := range records {
+				got = append(got, rec.Slug())
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("expected slugs %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateHandlerCollision(t *testing.T) {
+	useTempRecords(t)
+
+	if err := (&Record{Title: "Hello", Content: "original"}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"title": {"Hello"}, "content": {"impostor"}}
+	r := httptest.NewRequest("POST", "/create/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	createHandler(w, r)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "impostor</textarea>") {
+		t.Fatal("expected the form to be re-rendered with the submitted content")
+	}
+
+	rec, err := LoadRecord("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Content != "original" {
+		t.Fatalf("existing post was overwritten with %q", rec.Content)
+	}
+
+	// editing the post itself still writes to its own slug
+	form = url.Values{"title": {"Hello"}, "content": {"edited"}}
+	r = httptest.NewRequest("POST", "/save/hello", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	saveHandler(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", w.Code)
+	}
+	if rec, _ := LoadRecord("hello"); rec.Content != "edited" {
+		t.Fatalf("expected edit to be saved, got %q", rec.Content)
+	}
+}
+
+func TestHandlersRejectInvalidTitles(t *testing.T) {
+	dir := useTempRecords(t)
+	existing := filepath.Join(dir, "existing.json")
+	if err := ioutil.WriteFile(existing, []byte(`{"title":"Existing"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		want    string
+	}{
+		{"create", createHandler, "/create/", `action="/create/"`},
+		{"save", saveHandler, "/save/existing", `action="/save/existing"`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{"title": {"  "}, "content": {"kept content"}}
+			r := httptest.NewRequest("POST", tc.target, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			tc.handler(w, r)
+
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("expected 422, got %d", w.Code)
+			}
+			body := w.Body.String()
+			for _, want := range []string{"Title is required", "kept content</textarea>", tc.want} {
+				if !strings.Contains(body, want) {
+					t.Fatalf("expected body to contain %q, got:\n%s", want, body)
+				}
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("invalid post wrote %d files", len(files)-1)
+			}
+		})
+	}
+}
+
+func TestSaveWithRetry(t *testing.T) {
+	tt := []struct {
+		name     string
+		failures int
+		retries  int
+		attempts int
+		ok       bool
+	}{
+		{"first try", 0, 3, 1, true},
+		{"transient", 2, 3, 3, true},
+		{"last retry", 3, 3, 4, true},
+		{"gives up", 5, 3, 4, false},
+		{"no retries", 1, 0, 1, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			old := writeFile
+			writeFile = func(filename string, data []byte, perm os.FileMode) error {
+				attempts++
+				if attempts <= tc.failures {
+					return errors.New("stale NFS file handle")
+				}
+				return nil
+			}
+			defer func() { writeFile = old }()
+
+			err := saveWithRetry("x.json", nil, tc.retries, time.Microsecond)
+			if attempts != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d", tc.attempts, attempts)
+			}
+			if tc.ok && err != nil {
+				t.Fatalf("expected success, got %v", err)
+			}
+			if !tc.ok {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if !strings.Contains(err.Error(), fmt.Sprintf("after %d attempts", tc.attempts)) {
+					t.Fatalf("error should report the attempts made: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveHandlerRename(t *testing.T) {
+	tt := []struct {
+		name     string
+		newTitle string
+		code     int
+		location string
+		exists   []string
+		gone     []string
+	}{
+		{"rename", "Gamma", http.StatusFound, "/show/gamma", []string{"gamma", "beta"}, []string{"alpha"}},
+		{"collides with another post", "Beta", http.StatusConflict, "", []string{"alpha", "beta"}, []string{"gamma"}},
+		{"same slug", "ALPHA", http.StatusFound, "/show/alpha", []string{"alpha", "beta"}, []string{"gamma"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			useTempRecords(t)
+			created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			for _, rec := range []*Record{
+				{Title: "Alpha", Content: "alpha body", CreatedAt: created},
+				{Title: "Beta", Content: "beta body"},
+			} {
+				if err := rec.Save(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			form := url.Values{"title": {tc.newTitle}, "content": {"edited"}}
+			r := httptest.NewRequest("POST", "/save/alpha", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			saveHandler(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			if loc := w.Header().Get("Location"); loc != tc.location {
+				t.Fatalf("expected redirect to %q, got %q", tc.location, loc)
+			}
+
+			for _, slug := range tc.exists {
+				if _, err := LoadRecord(slug); err != nil {
+					t.Fatalf("expected %s to exist: %v", slug, err)
+				}
+			}
+			for _, slug := range tc.gone {
+				if _, err := LoadRecord(slug); !errors.Is(err, ErrNotFound) {
+					t.Fatalf("expected %s to be gone, got %v", slug, err)
+				}
+			}
+
+			beta, _ := LoadRecord("beta")
+			if beta.Content != "beta body" {
+				t.Fatalf("unrelated post was overwritten: %q", beta.Content)
+			}
+			if tc.code == http.StatusFound {
+				rec, _ := LoadRecord(strings.TrimPrefix(tc.location, "/show/"))
+				if !rec.CreatedAt.Equal(created) {
+					t.Fatalf("rename lost the creation time: %v", rec.CreatedAt)
+				}
+				if revs, _ := Revisions(rec.Slug()); len(revs) != 2 {
+					t.Fatalf("expected history to follow the rename, got %d revisions", len(revs))
+				}
+			}
+		})
+	}
+}
+
+func TestSaveIsAtomic(t *testing.T) {
+	dir := useTempRecords(t)
+
+	rec := &Record{Title: "Atomic", Content: "original content"}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the disk fills up half way through writing the new version
+	old := writeTemp
+	writeTemp = func(f *os.File, data []byte) (int, error) {
+		n, _ := f.Write(data[:len(data)/2])
+		return n, errors.New("no space left on device")
+	}
+	defer func() { writeTemp = old }()
+
+	rec.Content = "a much longer replacement that will never be fully written"
+	if err := rec.Save(); err == nil {
+		t.Fatal("expected Save to fail")
+	}
+
+	got, err := LoadRecord("atomic")
+	if err != nil {
+		t.Fatalf("record was corrupted by the failed write: %v", err)
+	}
+	if got.Content != "original content" {
+		t.Fatalf("expected the original content to survive, got %q", got.Content)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".tmp-") {
+			t.Fatalf("temporary file %s was left behind", f.Name())
+		}
+	}
+}
+
+func TestSaveHandlerUpdatesInPlace(t *testing.T) {
+	useTempRecords(t)
+
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rec := &Record{Title: "Keep Me", Content: "old", Published: true, CreatedAt: created, Tags: []string{"go"}}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	views.add("keep-me")
+
+	form := url.Values{"title": {"Keep Me Renamed"}, "content": {"new"}, "published": {"1"}}
+	r := httptest.NewRequest("POST", "/save/keep-me", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	saveHandler(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d: %s", w.Code, w.Body)
+	}
+
+	got, err := LoadRecord("keep-me-renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(created) {
+		t.Fatalf("edit reset the creation time to %v", got.CreatedAt)
+	}
+	if !got.UpdatedAt.After(created) {
+		t.Fatalf("edit did not bump the update time: %v", got.UpdatedAt)
+	}
+	if strings.Join(got.Tags, ",") != "go" || got.Content != "new" {
+		t.Fatalf("unexpected record after edit: %+v", got)
+	}
+	if views.snapshot()["keep-me-renamed"] != 1 {
+		t.Fatal("views did not follow the rename")
+	}
+}
+
+func TestSaveHandlerMissingRecord(t *testing.T) {
+	dir := useTempRecords(t)
+
+	form := url.Values{"title": {"Ghost"}, "content": {"boo"}}
+	r := httptest.NewRequest("POST", "/save/ghost", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	saveHandler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatal("saving a missing post must not create it")
+	}
+}
+
+func TestSaveHandlerMissingSlug(t *testing.T) {
+	dir := useTempRecords(t)
+
+	for _, target := range []string{"/save/", "/save/not_a_slug", "/save/a/b"} {
+		form := url.Values{"title": {"Nameless"}, "content": {"boo"}}
+		r := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		saveHandler(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "missing slug in URL") {
+			t.Fatalf("%s: expected 400, got %d: %s", target, w.Code, w.Body)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatal("saving without a slug must not create anything")
+	}
+}
+
+func TestAllRecordsSkipsBrokenFiles(t *testing.T) {
+	dir := useTempRecords(t)
+	files := map[string]string{
+		"good.json":      `{"title":"Good","content":"fine"}`,
+		"truncated.json": `{"title":"Trunc`,
+		"notes.txt":      `not a record`,
+		"README":         `nor this`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".trash"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	records, problems, err := LoadAllRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Title != "Good" {
+		t.Fatalf("expected only the good record, got %v", titles(records))
+	}
+	if len(problems) != 1 || problems[0].File != "truncated.json" {
+		t.Fatalf("expected truncated.json to be reported, got %+v", problems)
+	}
+
+	if records, err := AllRecords(); err != nil || len(records) != 1 {
+		t.Fatalf("expected AllRecords to skip the broken file, got %d %v", len(records), err)
+	}
+}
+
+func TestImmutableSlugs(t *testing.T) {
+	dir := useTempRecords(t)
+	immutableSlugs = true
+	t.Cleanup(func() { immutableSlugs = false })
+
+	// written before slugs were pinned, so it has no stored slug
+	if err := ioutil.WriteFile(filepath.Join(dir, "old-post.json"), []byte(`{"title":"Old Post"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateRecord(&Record{Title: "New Post", Published: true}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, slug := range []string{"old-post", "new-post"} {
+		form := url.Values{"title": {"Retitled " + slug}, "content": {"x"}}
+		r := httptest.NewRequest("POST", "/save/"+slug, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		saveHandler(w, r)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/show/"+slug {
+			t.Fatalf("%s: expected a redirect to the same slug, got %d %s", slug, w.Code, w.Header().Get("Location"))
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, slug+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"slug":"`+slug+`"`) {
+			t.Fatalf("%s: expected the slug to be stored, got %s", slug, data)
+		}
+	}
+}
+
+// hostileSlugs try to escape recordsDir one way or another.
+var hostileSlugs = []string{
+	"",
+	"..",
+	"../../etc/passwd",
+	"..%2f..%2fsomething",
+	"a/b",
+	`a\b`,
+	"/abs",
+	"post.json",
+	"post\x00",
+}
+
+func TestStoreRejectsHostileSlugs(t *testing.T) {
+	dir := useTempRecords(t)
+	// a file the hostile slugs would reach if they weren't refused
+	outside := filepath.Join(filepath.Dir(dir), "outside.json")
+	if err := ioutil.WriteFile(outside, []byte(`{"title":"Outside"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, slug := range hostileSlugs {
+		t.Run(slug, func(t *testing.T) {
+			if _, err := LoadRecord(slug); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("LoadRecord: expected ErrInvalidSlug, got %v", err)
+			}
+			if err := DeleteRecord(slug); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("DeleteRecord: expected ErrInvalidSlug, got %v", err)
+			}
+			if err := (&Record{StoredSlug: slug}).Save(); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("Save: expected ErrInvalidSlug, got %v", err)
+			}
+			if err := CreateRecord(&Record{StoredSlug: slug}, false); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("CreateRecord: expected ErrInvalidSlug, got %v", err)
+			}
+			if err := RenameRecord(slug, &Record{Title: "Renamed"}); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("RenameRecord: expected ErrInvalidSlug, got %v", err)
+			}
+		})
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Fatalf("the file outside recordsDir was touched: %v", err)
+	}
+	// nothing may have been written, not even by the rename
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected no files, got %d", len(files))
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	dir := useTempRecords(t)
+	if err := (&Record{Title: "Doomed", Content: "x", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	exists := func() bool {
+		_, err := os.Stat(filepath.Join(dir, "doomed.json"))
+		return err == nil
+	}
+
+	// a GET only asks
+	w := httptest.NewRecorder()
+	deleteHandler(w, httptest.NewRequest("GET", "/delete/doomed", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `<form method="post" action="/delete/doomed">`) || !strings.Contains(w.Body.String(), "Doomed") {
+		t.Fatalf("expected a confirmation form, got:\n%s", w.Body)
+	}
+	if !exists() {
+		t.Fatal("a GET deleted the post")
+	}
+
+	w = httptest.NewRecorder()
+	deleteHandler(w, httptest.NewRequest("POST", "/delete/doomed", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", w.Code)
+	}
+	if exists() {
+		t.Fatal("the POST did not delete the post")
+	}
+
+	tt := []struct {
+		method string
+		code   int
+	}{
+		{"GET", http.StatusNotFound},
+		{"POST", http.StatusNotFound},
+		{"PUT", http.StatusMethodNotAllowed},
+	}
+	for _, tc := range tt {
+		t.Run(tc.method, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			deleteHandler(w, httptest.NewRequest(tc.method, "/delete/doomed", nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+		})
+	}
+}
+
+func TestNotFoundStatusCodes(t *testing.T) {
+	dir := useTempRecords(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadRecord("missing"); !errors.Is(err, ErrNotFound) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotFound wrapping the os error, got %v", err)
+	}
+	if err := DeleteRecord("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		code    int
+	}{
+		{"show missing", showHandler, "GET", "/show/missing", http.StatusNotFound},
+		{"edit missing", editHandler, "GET", "/edit/missing", http.StatusNotFound},
+		{"delete missing", deleteHandler, "GET", "/delete/missing", http.StatusNotFound},
+		{"publish missing", publishHandler, "POST", "/api/records/missing/publish", http.StatusNotFound},
+		{"show corrupt", showHandler, "GET", "/show/corrupt", http.StatusInternalServerError},
+		{"edit corrupt", editHandler, "GET", "/edit/corrupt", http.StatusInternalServerError},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tc.handler(w, httptest.NewRequest(tc.method, tc.target, nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+			if strings.Contains(w.Body.String(), dir) || strings.Contains(w.Body.String(), ".json") {
+				t.Fatalf("response leaks the file system: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestSaveHandlerConflict(t *testing.T) {
+	useTempRecords(t)
+	if err := (&Record{Title: "Shared", Content: "v1", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// both editors open the form
+	w := httptest.NewRecorder()
+	editHandler(w, httptest.NewRequest("GET", "/edit/shared", nil))
+	rec, err := LoadRecord("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := rec.ETag()
+	if !strings.Contains(w.Body.String(), `name="etag" value="`+etag+`"`) {
+		t.Fatalf("expected the edit form to carry the etag, got:\n%s", w.Body)
+	}
+
+	save := func(content, etag string) *httptest.ResponseRecorder {
+		form := url.Values{"title": {"Shared"}, "content": {content}, "published": {"1"}, "etag": {etag}}
+		r := httptest.NewRequest("POST", "/save/shared", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		saveHandler(w, r)
+		return w
+	}
+
+	if w := save("first editor", etag); w.Code != http.StatusFound {
+		t.Fatalf("expected the first save to succeed, got %d", w.Code)
+	}
+	w = save("second editor", etag)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a stale etag, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "modified by another user") || !strings.Contains(w.Body.String(), "second editor") {
+		t.Fatalf("expected the form back with a warning, got:\n%s", w.Body)
+	}
+	if rec, _ := LoadRecord("shared"); rec.Content != "first editor" {
+		t.Fatalf("the stale save overwrote the record: %q", rec.Content)
+	}
+}
+
+func TestSaveHandlerConflictWithoutContentChange(t *testing.T) {
+	useTempRecords(t)
+	rec := &Record{Title: "Shared", Content: "same", Published: true}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	etag := rec.ETag()
+
+	// someone else changes only the tags; the content is untouched but the
+	// save time, and so the etag, moves on
+	time.Sleep(time.Millisecond)
+	rec.Tags = []string{"news"}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"title": {"Shared"}, "content": {"same"}, "published": {"1"}, "etag": {etag}}
+	r := httptest.NewRequest("POST", "/save/shared", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	saveHandler(w, r)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 after a change made since the form was opened, got %d", w.Code)
+	}
+}
+
+func TestUpsertRecord(t *testing.T) {
+	tt := []struct {
+		name     string
+		slug     string
+		form     url.Values
+		code     int
+		location string
+		// the form shown again, or the post as stored afterwards
+		body    string
+		content string
+	}{
+		{"create", "", url.Values{"title": {"Fresh"}, "content": {"new"}}, http.StatusFound, "/show/fresh", "", "new"},
+		{"create invalid", "", url.Values{"title": {" "}, "content": {"x"}}, http.StatusUnprocessableEntity, "", `action="/create/"`, ""},
+		{"create taken", "", url.Values{"title": {"Existing"}, "content": {"x"}}, http.StatusConflict, "", `action="/create/"`, ""},
+		{"update", "existing", url.Values{"title": {"Existing"}, "content": {"changed"}}, http.StatusFound, "/show/existing", "", "changed"},
+		{"update invalid", "existing", url.Values{"title": {""}, "content": {"x"}}, http.StatusUnprocessableEntity, "", `action="/save/existing"`, ""},
+		{"rename", "existing", url.Values{"title": {"Renamed"}, "content": {"moved"}}, http.StatusFound, "/show/renamed", "", "moved"},
+		{"rename onto another", "existing", url.Values{"title": {"Other"}, "content": {"x"}}, http.StatusConflict, "", `action="/save/existing"`, ""},
+		{"stale", "existing", url.Values{"title": {"Existing"}, "content": {"x"}, "etag": {"stale"}}, http.StatusConflict, "", "modified by another user", ""},
+		{"missing", "ghost", url.Values{"title": {"Ghost"}, "content": {"x"}}, http.StatusNotFound, "", "", ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			useTempRecords(t)
+			for _, title := range []string{"Existing", "Other"} {
+				if err := (&Record{Title: title, Content: "before"}).Save(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tc.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			upsertRecord(w, r, tc.slug)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			if got := w.Header().Get("Location"); got != tc.location {
+				t.Fatalf("expected to be sent to %q, got %q", tc.location, got)
+			}
+			if !strings.Contains(w.Body.String(), tc.body) {
+				t.Fatalf("expected %q in %s", tc.body, w.Body)
+			}
+			if tc.content != "" {
+				rec, err := LoadRecord(strings.TrimPrefix(tc.location, "/show/"))
+				if err != nil || rec.Content != tc.content {
+					t.Fatalf("expected %q stored, got %+v, %v", tc.content, rec, err)
+				}
+			}
+			if old, err := LoadRecord("existing"); tc.location != "/show/renamed" && (err != nil || old.Content == "x") {
+				t.Fatalf("the existing post should be untouched or updated, got %+v, %v", old, err)
+			}
+		})
+	}
+}
+
+func TestUpsertRecordNotifiesOnPublish(t *testing.T) {
+	useTempRecords(t)
+	sent := useTestNewsletter(t)
+	if err := subscribe("reader@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, form := range []url.Values{
+		{"title": {"Quiet Draft"}, "content": {"x"}},
+		{"title": {"Loud Post"}, "content": {"x"}, "published": {"1"}},
+	} {
+		r := httptest.NewRequest("POST", "/create/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		upsertRecord(httptest.NewRecorder(), r, "")
+	}
+	select {
+	case msg := <-sent:
+		if !strings.Contains(msg, "Loud Post") {
+			t.Fatalf("expected a mail about the published post, got %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected creating a published post to notify subscribers")
+	}
+	select {
+	case msg := <-sent:
+		t.Fatalf("expected only one mail, got another: %s", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIndexSurvivesCorruptRecord(t *testing.T) {
+	dir := useTempRecords(t)
+	for _, title := range []string{"Still Here", "Me Too"} {
+		if err := (&Record{Title: title, Content: "x", Published: true}).Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "corrupt.json"), []byte(`{"title": "Cut o`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	for _, want := range []string{"Still Here", "Me Too"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("expected %q on the index, got:\n%s", want, w.Body)
+		}
+	}
+}
+
+func TestIndexSortUpdated(t *testing.T) {
+	dir := useTempRecords(t)
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, title := range []string{"Oldest", "Middle", "Newest"} {
+		writeRecordAt(t, dir, &Record{Title: title, Published: true}, day.AddDate(0, 0, i))
+	}
+	stale := &Record{Title: "Oldest", Published: true, CreatedAt: day}
+	stale.UpdatedAt = day.AddDate(0, 1, 0)
+	data, err := json.Marshal(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oldest.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	order := func(target string) []string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		indexHandler(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		body := w.Body.String()
+		titles := []string{"Oldest", "Middle", "Newest"}
+		sort.Slice(titles, func(i, j int) bool {
+			return strings.Index(body, titles[i]) < strings.Index(body, titles[j])
 		})
+		return titles
+	}
+
+	tt := []struct {
+		target string
+		want   string
+	}{
+		{"/", "Newest Middle Oldest"},
+		{"/?sort=updated", "Oldest Newest Middle"},
+		{"/?sort=bogus", "Newest Middle Oldest"},
+	}
+	for _, tc := range tt {
+		if got := strings.Join(order(tc.target), " "); got != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.target, tc.want, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/?sort=updated", nil))
+	if !strings.Contains(w.Body.String(), `<time datetime="2023-06-01T00:00:00Z">2023-06-01</time>`) {
+		t.Fatalf("expected the last-modified date listed, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/?sort=updated&page=1", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/?sort=updated" {
+		t.Fatalf("expected page 1 to redirect keeping the order, got %d %q", w.Code, w.Header().Get("Location"))
 	}
-}
\ No newline at end of file
+}
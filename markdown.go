@@ -0,0 +1,179 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	mdHeading    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdRule       = regexp.MustCompile(`^ {0,3}([-*_])( *[-*_]){2,} *$`)
+	mdBullet     = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdNumbered   = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdQuote      = regexp.MustCompile(`^\s*> ?(.*)$`)
+	mdFence      = regexp.MustCompile("^```\\s*([a-zA-Z0-9_+-]*)\\s*$")
+	mdImage      = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+	mdLink       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong     = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEmphasis   = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	mdStrike     = regexp.MustCompile(`~~([^~]+)~~`)
+	mdLineBreaks = regexp.MustCompile(` {2,}\n`)
+)
+
+// renderMarkdown turns post content into HTML. It covers the common subset
+// (headings, paragraphs, emphasis, links, images, lists, quotes, code) and
+// escapes any HTML in the source instead of passing it through. The result
+// still goes through sanitizeHTML before it is shown; use renderContent.
+func renderMarkdown(src string) string {
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+	var b strings.Builder
+	renderBlocks(&b, lines)
+	return b.String()
+}
+
+// renderContent is what pages and feeds show for a post's content, with its
+// shortcodes expanded.
+func renderContent(src string) template.HTML {
+	return template.HTML(sanitizeHTML(expandShortcodes(renderMarkdown(src))))
+}
+
+// HTML is the record's content rendered for display.
+func (r *Record) HTML() template.HTML {
+	return renderContent(r.Content)
+}
+
+var (
+	// excerptWords is how long the excerpts on listing pages are
+	excerptWords = envInt("BLOG_EXCERPT_WORDS", 40)
+	// feedExcerptWords is the same for feeds, which can afford more
+	feedExcerptWords = envInt("BLOG_FEED_EXCERPT_WORDS", excerptWords)
+)
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// Excerpt is the start of the post as plain text, excerptWords long.
+// Protected posts have none, so listings don't give them away.
+func (r *Record) Excerpt() string {
+	if r.Protected() {
+		return ""
+	}
+	return excerpt(r.Content, excerptWords)
+}
+
+// excerpt returns the first n words of content's text, with the Markdown
+// removed, and an ellipsis if anything was cut. Words are never split.
+func excerpt(content string, n int) string {
+	words := strings.Fields(plainText(content))
+	if n <= 0 || len(words) <= n {
+		return strings.Join(words, " ")
+	}
+	return strings.Join(words[:n], " ") + "…"
+}
+
+// plainText is the text of the rendered content, without the markup.
+func plainText(content string) string {
+	// renderMarkdown escapes all the text, so every tag in its output is
+	// one it produced, and it ends every block with a newline, so tags can go
+	// without running words together
+	text := html.UnescapeString(htmlTag.ReplaceAllString(stripShortcodes(renderMarkdown(content)), ""))
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func renderBlocks(b *strings.Builder, lines []string) {
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			b.WriteString("<p>" + renderInline(strings.Join(para, "\n")) + "</p>\n")
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case mdFence.MatchString(line):
+			flush()
+			lang := mdFence.FindStringSubmatch(line)[1]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(lines[i], "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code")
+			if lang != "" {
+				b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+			}
+			b.WriteString(">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case mdHeading.MatchString(line):
+			flush()
+			m := mdHeading.FindStringSubmatch(line)
+			tag := "h" + string(rune('0'+len(m[1])))
+			b.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">\n")
+		case mdRule.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+		case mdQuote.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && mdQuote.MatchString(lines[i]); i++ {
+				quoted = append(quoted, mdQuote.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+		case mdBullet.MatchString(line):
+			flush()
+			i = renderList(b, lines, i, "ul", mdBullet)
+		case mdNumbered.MatchString(line):
+			flush()
+			i = renderList(b, lines, i, "ol", mdNumbered)
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+}
+
+// renderList writes the list starting at lines[i] and returns the index of
+// its last line.
+func renderList(b *strings.Builder, lines []string, i int, tag string, item *regexp.Regexp) int {
+	b.WriteString("<" + tag + ">\n")
+	for ; i < len(lines) && item.MatchString(lines[i]); i++ {
+		b.WriteString("<li>" + renderInline(item.FindStringSubmatch(lines[i])[1]) + "</li>\n")
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i - 1
+}
+
+// renderInline handles the markup within a block. Code spans are split out
+// first so nothing inside them is interpreted.
+func renderInline(s string) string {
+	parts := strings.Split(s, "`")
+	var b strings.Builder
+	for i, part := range parts {
+		// an unmatched backtick is just a backtick
+		if i%2 == 1 && i < len(parts)-1 {
+			b.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			b.WriteString("`")
+		}
+		b.WriteString(renderSpan(html.EscapeString(part)))
+	}
+	return b.String()
+}
+
+// renderSpan applies the inline markup to already escaped text.
+func renderSpan(s string) string {
+	s = mdImage.ReplaceAllString(s, `<img src="$2" alt="$1">`)
+	s = mdLink.ReplaceAllString(s, `<a href="$2">$1</a>`)
+	s = mdStrong.ReplaceAllString(s, `<strong>$1$2</strong>`)
+	s = mdEmphasis.ReplaceAllString(s, `<em>$1$2</em>`)
+	s = mdStrike.ReplaceAllString(s, `<del>$1</del>`)
+	return mdLineBreaks.ReplaceAllString(s, "<br>\n")
+}
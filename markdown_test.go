@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tt := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"paragraphs", "one\ntwo\n\nthree", "<p>one\ntwo</p>\n<p>three</p>\n"},
+		{"heading", "## Sub *title* ##", "<h2>Sub <em>title</em></h2>\n"},
+		{"emphasis", "**bold** and _it_ and ~~gone~~", "<p><strong>bold</strong> and <em>it</em> and <del>gone</del></p>\n"},
+		{"snake case", "a snake_case_name", "<p>a snake_case_name</p>\n"},
+		{"link", "see [the docs](https://example.com/a_b)", `<p>see <a href="https://example.com/a_b">the docs</a></p>` + "\n"},
+		{"image", "![a cat](/cat.png)", `<p><img src="/cat.png" alt="a cat"></p>` + "\n"},
+		{"code span", "run `rm -rf *x*` now", "<p>run <code>rm -rf *x*</code> now</p>\n"},
+		{"unmatched backtick", "it`s", "<p>it`s</p>\n"},
+		{"fenced code", "```go\nif a < b {\n```", `<pre><code class="language-go">if a &lt; b {</code></pre>` + "\n"},
+		{"lists", "- a\n- b\n\n1. c\n2. d", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol>\n<li>c</li>\n<li>d</li>\n</ol>\n"},
+		{"quote", "> quoted\n> **text**", "<blockquote>\n<p>quoted\n<strong>text</strong></p>\n</blockquote>\n"},
+		{"rule", "a\n\n---\n\nb", "<p>a</p>\n<hr>\n<p>b</p>\n"},
+		{"html is escaped", `<b onclick="x">hi</b>`, "<p>&lt;b onclick=&#34;x&#34;&gt;hi&lt;/b&gt;</p>\n"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := renderMarkdown(tc.src); got != tc.want {
+				t.Fatalf("\nexpected: %q\nactual: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRenderContentSanitizes(t *testing.T) {
+	got := string(renderContent("[click](javascript:alert(1)) ![x](data:text/html,hi)"))
+	if strings.Contains(got, "javascript") || strings.Contains(got, "data:") {
+		t.Fatalf("unsafe URL survived: %s", got)
+	}
+	if !strings.Contains(got, `rel="nofollow noopener"`) {
+		t.Fatalf("expected links to get rel, got %s", got)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tt := []struct {
+		name    string
+		content string
+		n       int
+		want    string
+	}{
+		{"short", "just a few words", 5, "just a few words"},
+		{"exact", "one two three", 3, "one two three"},
+		{"cut", "one two three four", 2, "one two…"},
+		{"markdown removed", "# Title\n\nSome **bold** [link](/x) text", 4, "Title Some bold link…"},
+		{"entities decoded", "fish & chips < steak", 10, "fish & chips < steak"},
+		{"punctuation after markup", "Some *text*, then `code`.", 10, "Some text, then code."},
+		{"blocks kept apart", "- one\n- two\n\n> three", 10, "one two three"},
+		{"unlimited", "a b c", 0, "a b c"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := excerpt(tc.content, tc.n); got != tc.want {
+				t.Fatalf("\nexpected: %q\nactual: %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var validMetaKey = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+const metaKeyRule = "must start with a lowercase letter or underscore and contain only lowercase letters, digits and underscores"
+
+// GetMeta returns the metadata value stored under key, or "" if there is
+// none.
+func (r *Record) GetMeta(key string) string {
+	return r.Meta[key]
+}
+
+// MetaText is Meta as the key=value lines the forms edit it as.
+func (r *Record) MetaText() string {
+	keys := make([]string, 0, len(r.Meta))
+	for k := range r.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + r.Meta[k] + "\n")
+	}
+	return b.String()
+}
+
+func validateMetaKey(key string) error {
+	if !validMetaKey.MatchString(key) {
+		return fmt.Errorf("meta key %q %s", key, metaKeyRule)
+	}
+	return nil
+}
+
+// parseMeta reads the key=value lines of a form's meta field, returning one
+// message per bad line. Blank lines are skipped and a repeated key keeps
+// its last value.
+func parseMeta(text string) (map[string]string, []string) {
+	var errs []string
+	meta := make(map[string]string)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			errs = append(errs, fmt.Sprintf("Meta line %d must look like key=value", i+1))
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		if !validMetaKey.MatchString(key) {
+			errs = append(errs, fmt.Sprintf("Meta key %q %s", key, metaKeyRule))
+			continue
+		}
+		meta[key] = strings.TrimSpace(kv[1])
+	}
+	if len(meta) == 0 {
+		meta = nil
+	}
+	return meta, errs
+}
+
+// metaRequest sets and removes individual metadata keys without touching
+// the rest of the record.
+type metaRequest struct {
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+func metaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		w.Header().Set("Allow", http.MethodPatch)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req metaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	for key := range req.Set {
+		if err := validateMetaKey(key); err != nil {
+			writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+	}
+
+	rec, err := LoadRecord(getAPISlug(r))
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "record not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !allowed(r, actionEdit, rec) {
+		forbiddenJSON(w, "edit this record")
+		return
+	}
+
+	if rec.Meta == nil && len(req.Set) > 0 {
+		rec.Meta = make(map[string]string, len(req.Set))
+	}
+	for k, v := range req.Set {
+		rec.Meta[k] = v
+	}
+	for _, k := range req.Remove {
+		delete(rec.Meta, k)
+	}
+	if len(rec.Meta) == 0 {
+		rec.Meta = nil
+	}
+
+	if err := rec.Save(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, apiRecord{Slug: rec.Slug(), Record: rec})
+}
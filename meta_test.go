@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseMeta(t *testing.T) {
+	meta, errs := parseMeta("canonical_url = https://example.com/a?b=c\n\npriority=high\n_draft_note=x\npriority=low\n")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(meta) != 3 || meta["canonical_url"] != "https://example.com/a?b=c" || meta["priority"] != "low" {
+		t.Fatalf("unexpected meta: %v", meta)
+	}
+
+	_, errs = parseMeta("Priority=high\nno equals sign\n9lives=yes\nok=1")
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+
+	if meta, _ := parseMeta(" \n"); meta != nil {
+		t.Fatalf("expected no meta for an empty field, got %v", meta)
+	}
+}
+
+func TestRecordMeta(t *testing.T) {
+	useTempRecords(t)
+	rec := &Record{Title: "With Meta", Content: "x", Published: true, Meta: map[string]string{"review_status": "done", "a": "1"}}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadRecord("with-meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetMeta("review_status") != "done" || got.GetMeta("missing") != "" {
+		t.Fatalf("unexpected meta after load: %v", got.Meta)
+	}
+	if got.MetaText() != "a=1\nreview_status=done\n" {
+		t.Fatalf("unexpected meta text: %q", got.MetaText())
+	}
+
+	// a form without the field keeps the metadata, one with it replaces it
+	save := func(form url.Values) int {
+		r := httptest.NewRequest("POST", "/save/with-meta", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		saveHandler(w, r)
+		return w.Code
+	}
+	if code := save(url.Values{"title": {"With Meta"}, "content": {"y"}}); code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", code)
+	}
+	if got, _ := LoadRecord("with-meta"); len(got.Meta) != 2 {
+		t.Fatalf("a save without meta dropped it: %v", got.Meta)
+	}
+	if code := save(url.Values{"title": {"With Meta"}, "content": {"y"}, "meta": {"priority=high"}}); code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", code)
+	}
+	if got, _ := LoadRecord("with-meta"); len(got.Meta) != 1 || got.GetMeta("priority") != "high" {
+		t.Fatalf("unexpected meta after edit: %v", got.Meta)
+	}
+	if code := save(url.Values{"title": {"With Meta"}, "content": {"y"}, "meta": {"Bad-Key=1"}}); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a bad key, got %d", code)
+	}
+}
+
+func TestMetaEndpoint(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	key := useTestAPIKey(t)
+	if err := (&Record{Title: "Api Meta", Meta: map[string]string{"old": "x"}}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+	}{
+		{"set and remove", "PATCH", `{"set":{"priority":"high"},"remove":["old"]}`, http.StatusOK},
+		{"bad key", "PATCH", `{"set":{"Priority":"high"}}`, http.StatusUnprocessableEntity},
+		{"not a string", "PATCH", `{"set":{"priority":1}}`, http.StatusBadRequest},
+		{"wrong method", "POST", `{}`, http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := keyRequest(tc.method, "/api/records/api-meta/meta", key, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			apiRecordsHandler(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			if tc.code == http.StatusOK && !strings.Contains(w.Body.String(), `"meta":{"priority":"high"}`) {
+				t.Fatalf("expected the meta in the response, got %s", w.Body)
+			}
+		})
+	}
+}
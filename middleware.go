@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// requireFormContentType rejects POSTs that aren't form encoded before the
+// handler gets a chance to call ParseForm on them. JSON API routes must not be
+// wrapped with this.
+func requireFormContentType(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || (mt != "application/x-www-form-urlencoded" && mt != "multipart/form-data") {
+				http.Error(w, "unsupported media type: expected a form submission", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+var (
+	authUser = os.Getenv("BLOG_USERNAME")
+	authPass = os.Getenv("BLOG_PASSWORD")
+	// insecure leaves the write routes open when no credentials are set,
+	// for running the blog locally
+	insecure bool
+)
+
+// authConfigured reports whether anyone at all can log in, with the
+// credentials from the flags or environment, a local account or GitHub.
+func authConfigured() bool {
+	return (authUser != "" && authPass != "") || users.count() > 0 || githubLoginEnabled()
+}
+
+// currentUser is the username r is authenticated as, or "" when it isn't.
+// It runs on public pages too, so Basic Auth credentials only count once a
+// guard has checked them; a browser with a stale saved password mustn't
+// pay for a bcrypt check, or a lockout, on every page it views.
+func currentUser(r *http.Request) string {
+	if id := sessionID(r); id != "" {
+		if user, ok := sessions.user(id); ok {
+			return user
+		}
+	}
+	if user, pass, ok := r.BasicAuth(); ok {
+		if name, ok := basicAuths.lookup(basicAuthKey(user, pass)); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// basicAuthUser checks r's Basic Auth credentials for a guard, returning
+// the user they belong to. Wrong guesses count against the same lockout as
+// the login form, so the header is no way around it.
+func basicAuthUser(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	keys := loginKeys(user, clientIP(r))
+	if _, locked := logins.locked(keys...); locked {
+		return "", false
+	}
+	name, ok := authenticate(user, pass)
+	if !ok {
+		logins.fail(keys...)
+		return "", false
+	}
+	logins.reset(keys...)
+	basicAuths.remember(basicAuthKey(user, pass), name)
+	return name, true
+}
+
+// basicAuthTTL is how long public pages go on trusting Basic Auth
+// credentials after they were last used.
+const basicAuthTTL = 15 * time.Minute
+
+// basicAuthCache remembers the Basic Auth credentials guards accepted, by
+// basicAuthKey, so currentUser can recognise them cheaply.
+type basicAuthCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]basicAuthEntry
+	now     func() time.Time
+}
+
+type basicAuthEntry struct {
+	user    string
+	expires time.Time
+}
+
+func newBasicAuthCache(ttl time.Duration) *basicAuthCache {
+	return &basicAuthCache{ttl: ttl, entries: make(map[string]basicAuthEntry), now: time.Now}
+}
+
+var basicAuths = newBasicAuthCache(basicAuthTTL)
+
+// basicAuthKey identifies a username and password together with what is
+// stored for the account, so changing the password forgets the old one.
+// Only a hash is kept, never the password.
+func basicAuthKey(user, pass string) string {
+	stored := ""
+	if u, ok := users.get(user); ok {
+		stored = u.PasswordHash
+	} else if user == authUser {
+		stored = authPass
+	}
+	sum := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + stored))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *basicAuthCache) remember(key, user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = basicAuthEntry{user: user, expires: c.now().Add(c.ttl)}
+}
+
+// lookup returns the user behind key, keeping it fresh while it is in use.
+func (c *basicAuthCache) lookup(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	now := c.now()
+	if !ok || now.After(e.expires) {
+		return "", false
+	}
+	e.expires = now.Add(c.ttl)
+	c.entries[key] = e
+	return e.user, true
+}
+
+// cleanup forgets the credentials that haven't been used for ttl.
+func (c *basicAuthCache) cleanup() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *basicAuthCache) cleanupLoop(every time.Duration) {
+	for range time.Tick(every) {
+		c.cleanup()
+	}
+}
+
+// authorized reports whether r comes from a logged in session or carries
+// valid Basic Auth credentials.
+func authorized(r *http.Request) bool {
+	if loggedIn(r) {
+		return true
+	}
+	_, ok := basicAuthUser(r)
+	return ok
+}
+
+// requireAuth guards a handler with a session or HTTP Basic Auth, answering
+// 401 otherwise; it suits API clients. With no credentials configured nobody
+// gets in.
+func requireAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="blog", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requireLogin is requireAuth for pages people browse to: instead of a 401
+// they are sent to the login form, which brings them back afterwards.
+func requireLogin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			http.Redirect(w, r, loginURL(r), http.StatusSeeOther)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requireWriteAuth guards the routes that change posts. It is requireLogin,
+// except that an -insecure server without credentials lets everyone in.
+func requireWriteAuth(h http.HandlerFunc) http.HandlerFunc {
+	guarded := requireLogin(h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if insecure && !authConfigured() {
+			h(w, r)
+			return
+		}
+		guarded(w, r)
+	}
+}
+
+// contentSecurityPolicy fits the default theme: its scripts, styles and
+// fonts come from /static/, while posts may show images from anywhere over
+// HTTPS. Themes that need more can set BLOG_CSP.
+var contentSecurityPolicy = envOr("BLOG_CSP",
+	"default-src 'self'; img-src 'self' https: data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'")
+
+// strictTransportSecurity is the HSTS header sent over HTTPS. Preloading
+// commits every subdomain to HTTPS too, so sites that can't should set
+// BLOG_HSTS to a plain max-age.
+var strictTransportSecurity = envOr("BLOG_HSTS", "max-age=31536000; includeSubDomains; preload")
+
+// securityHeaders sets the headers every response should carry, the API's
+// included. HSTS is only sent when the blog is served over HTTPS, so
+// deployments on plain HTTP keep working.
+func securityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		hdr.Set("X-Content-Type-Options", "nosniff")
+		hdr.Set("X-Frame-Options", "DENY")
+		hdr.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if contentSecurityPolicy != "" {
+			hdr.Set("Content-Security-Policy", contentSecurityPolicy)
+		}
+		if tlsDomain != "" && r.TLS != nil && strictTransportSecurity != "" {
+			hdr.Set("Strict-Transport-Security", strictTransportSecurity)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
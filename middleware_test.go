@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireFormContentType(t *testing.T) {
+	tt := []struct {
+		name        string
+		method      string
+		contentType string
+		code        int
+	}{
+		{"urlencoded", "POST", "application/x-www-form-urlencoded", http.StatusOK},
+		{"urlencoded with charset", "POST", "application/x-www-form-urlencoded; charset=utf-8", http.StatusOK},
+		{"multipart", "POST", "multipart/form-data; boundary=xyz", http.StatusOK},
+		{"json", "POST", "application/json", http.StatusUnsupportedMediaType},
+		{"missing", "POST", "", http.StatusUnsupportedMediaType},
+		{"garbage", "POST", ";;;", http.StatusUnsupportedMediaType},
+		{"get is not checked", "GET", "", http.StatusOK},
+	}
+
+	h := requireFormContentType(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/create/", strings.NewReader("title=x"))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	h := requireAuth(func(w http.ResponseWriter, r *http.Request) {})
+
+	tt := []struct {
+		name       string
+		user, pass string
+		configured bool
+		code       int
+	}{
+		{"valid", "admin", "secret", true, http.StatusOK},
+		{"wrong password", "admin", "nope", true, http.StatusUnauthorized},
+		{"wrong user", "root", "secret", true, http.StatusUnauthorized},
+		{"no header", "", "", true, http.StatusUnauthorized},
+		{"nothing configured", "", "", false, http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.configured {
+				useTestAuth(t)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.user != "" {
+				r.SetBasicAuth(tc.user, tc.pass)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRequireWriteAuth(t *testing.T) {
+	h := requireWriteAuth(func(w http.ResponseWriter, r *http.Request) {})
+
+	tt := []struct {
+		name       string
+		configured bool
+		insecure   bool
+		login      bool
+		code       int
+	}{
+		{"configured, logged in", true, false, true, http.StatusOK},
+		{"configured, anonymous", true, false, false, http.StatusSeeOther},
+		{"insecure ignored once configured", true, true, false, http.StatusSeeOther},
+		{"open without insecure", false, false, false, http.StatusSeeOther},
+		{"open with insecure", false, true, false, http.StatusOK},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.configured {
+				useTestAuth(t)
+			}
+			old := insecure
+			insecure = tc.insecure
+			t.Cleanup(func() { insecure = old })
+
+			r := httptest.NewRequest("GET", "/new/", nil)
+			if tc.login {
+				r.SetBasicAuth("admin", "secret")
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+			if loc := w.Header().Get("Location"); tc.code == http.StatusSeeOther && loc != "/login?next=%2Fnew%2F" {
+				t.Fatalf("expected a redirect to the login form, got %q", loc)
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	useTempRecords(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", indexHandler)
+	mux.HandleFunc("/api/records", listRecordsHandler)
+	h := securityHeaders(mux)
+
+	tt := []struct {
+		name   string
+		target string
+		tls    bool
+		want   map[string]string
+	}{
+		{"html", "http://blog.example/", false, map[string]string{
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           "strict-origin-when-cross-origin",
+			"Content-Security-Policy":   contentSecurityPolicy,
+			"Strict-Transport-Security": "",
+		}},
+		{"api", "http://blog.example/api/records", false, map[string]string{
+			"X-Content-Type-Options": "nosniff",
+		}},
+		{"https", "https://blog.example/", true, map[string]string{
+			"Strict-Transport-Security": "max-age=31536000; includeSubDomains; preload",
+		}},
+		{"https without TLS mode", "https://blog.example/", false, map[string]string{
+			"Strict-Transport-Security": "",
+		}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.tls {
+				old := tlsDomain
+				tlsDomain = "blog.example"
+				t.Cleanup(func() { tlsDomain = old })
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tc.target, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", w.Code)
+			}
+			for k, v := range tc.want {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s: expected %q, got %q", k, v, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCSPConfigurable(t *testing.T) {
+	old := contentSecurityPolicy
+	contentSecurityPolicy = "default-src 'self' https://fonts.example"
+	t.Cleanup(func() { contentSecurityPolicy = old })
+
+	w := httptest.NewRecorder()
+	securityHeaders(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("Content-Security-Policy"); got != contentSecurityPolicy {
+		t.Fatalf("expected the configured policy, got %q", got)
+	}
+}
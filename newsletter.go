@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// subscribersFile holds the newsletter's subscribers
+	subscribersFile = envOr("BLOG_SUBSCRIBERS_FILE", "subscribers.json")
+	// the SMTP server notifications are sent through; without a host no
+	// mail is sent
+	smtpHost = envOr("BLOG_SMTP_HOST", "")
+	smtpPort = envOr("BLOG_SMTP_PORT", "587")
+	smtpUser = envOr("BLOG_SMTP_USER", "")
+	smtpPass = envOr("BLOG_SMTP_PASS", "")
+	smtpFrom = envOr("BLOG_SMTP_FROM", "")
+)
+
+// sendMail is smtp.SendMail, replaced in tests.
+var sendMail = smtp.SendMail
+
+// Subscriber is an address that is mailed when a post is published. Token
+// is the secret in the subscriber's unsubscribe link.
+type Subscriber struct {
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// subscribersMu serialises the read-modify-write of subscribersFile.
+var subscribersMu sync.Mutex
+
+func loadSubscribers() ([]*Subscriber, error) {
+	list := make([]*Subscriber, 0)
+	data, err := ioutil.ReadFile(subscribersFile)
+	if os.IsNotExist(err) {
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", subscribersFile, err)
+	}
+	return list, nil
+}
+
+func saveSubscribers(list []*Subscriber) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(subscribersFile, data, 0600)
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// subscribe adds email to the list. Subscribing twice is not an error, so
+// the response doesn't reveal who is already on the list.
+func subscribe(email string) error {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	list, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	for _, s := range list {
+		if strings.EqualFold(s.Email, email) {
+			return nil
+		}
+	}
+	token, err := newUUID()
+	if err != nil {
+		return err
+	}
+	return saveSubscribers(append(list, &Subscriber{Email: email, Token: token, CreatedAt: time.Now()}))
+}
+
+// unsubscribe removes the subscriber holding token.
+func unsubscribe(token string) error {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	list, err := loadSubscribers()
+	if err != nil {
+		return err
+	}
+	for i, s := range list {
+		if token != "" && s.Token == token {
+			return saveSubscribers(append(list[:i], list[i+1:]...))
+		}
+	}
+	return fmt.Errorf("%w: subscriber", ErrNotFound)
+}
+
+// parseEmail accepts a bare address like "ada@example.com".
+func parseEmail(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || len(s) > 254 {
+		return "", false
+	}
+	return s, true
+}
+
+// subscribeHandler serves POST /subscribe.
+func subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	email, ok := parseEmail(r.FormValue("email"))
+	if !ok {
+		setFlash(w, flashError, "Please enter a valid email address")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if err := subscribe(email); err != nil {
+		errorf("unable to subscribe: %v", err)
+		http.Error(w, "unable to subscribe", http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, flashInfo, "Subscribed "+email)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// unsubscribeHandler serves GET /unsubscribe?token=..., the link at the foot
+// of every notification.
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	err := unsubscribe(r.URL.Query().Get("token"))
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "no such subscription", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to unsubscribe: %v", err)
+		http.Error(w, "unable to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, flashInfo, "Unsubscribed")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+	<body>
+		<h1><a href="{{ .URL }}">{{ .Record.Title }}</a></h1>
+		{{ .Record.HTML }}
+		<hr>
+		<p><small>You are receiving this because you subscribed to {{ .SiteTitle }}.
+		<a href="{{ .UnsubscribeURL }}">Unsubscribe</a></small></p>
+	</body>
+</html>
+`))
+
+type emailData struct {
+	Record         *Record
+	SiteTitle      string
+	URL            string
+	UnsubscribeURL string
+}
+
+// notificationMessage is the mail telling sub that rec was published.
+func notificationMessage(base string, rec *Record, sub *Subscriber) ([]byte, error) {
+	var body bytes.Buffer
+	err := emailTemplate.Execute(&body, &emailData{
+		Record:         rec,
+		SiteTitle:      siteTitle,
+		URL:            base + "/show/" + rec.Slug(),
+		UnsubscribeURL: base + "/unsubscribe?token=" + sub.Token,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", smtpFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", sub.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", rec.Title))
+	fmt.Fprintf(&msg, "List-Unsubscribe: <%s/unsubscribe?token=%s>\r\n", base, sub.Token)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// announce notifies subscribers when rec has just been published: it is
+// new or was a draft as old, and is neither unlisted nor protected.
+func announce(base string, old, rec *Record) {
+	if rec.Published && (old == nil || !old.Published) && rec.Listed() && !rec.Protected() {
+		notifySubscribers(base, rec)
+	}
+}
+
+// notifySubscribers queues a mail about rec for every subscriber. A failure
+// for one subscriber is logged and doesn't stop the others.
+func notifySubscribers(base string, rec *Record) {
+	if !mailEnabled() {
+		return
+	}
+	list, err := loadSubscribers()
+	if err != nil {
+		errorf("unable to load subscribers: %v", err)
+		return
+	}
+	queued := 0
+	for _, sub := range list {
+		msg, err := notificationMessage(base, rec, sub)
+		if err != nil {
+			errorf("unable to write the mail to %s about %s: %v", sub.Email, rec.Slug(), err)
+			continue
+		}
+		if mails.enqueue(rec.Slug()+" for "+sub.Email, []string{sub.Email}, msg) {
+			queued++
+		}
+	}
+	infof("queued mail to %d of %d subscribers about %s", queued, len(list), rec.Slug())
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestNewsletter points the subscribers at a file of the test's own and
+// captures the mail that would be sent, directly or through a mail queue of
+// the test's own.
+func useTestNewsletter(t *testing.T) <-chan string {
+	t.Helper()
+	oldFile, oldHost, oldFrom, oldSend := subscribersFile, smtpHost, smtpFrom, sendMail
+	subscribersFile = filepath.Join(t.TempDir(), "subscribers.json")
+	smtpHost, smtpFrom = "smtp.example.com", "blog@example.com"
+	sent := make(chan string, 10)
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent <- string(msg)
+		return nil
+	}
+	// queued mail goes straight to sent, so the queue never reads sendMail
+	// while the cleanup puts it back
+	oldMails, q := mails, newMailQueue(10, 0, 0)
+	q.send = func(to []string, msg []byte) error {
+		sent <- string(msg)
+		return nil
+	}
+	mails = q
+	go q.run()
+	t.Cleanup(func() {
+		close(q.jobs)
+		subscribersFile, smtpHost, smtpFrom, sendMail, mails = oldFile, oldHost, oldFrom, oldSend, oldMails
+	})
+	return sent
+}
+
+func TestSubscribe(t *testing.T) {
+	useTestNewsletter(t)
+
+	tt := []struct {
+		email string
+		flash string
+	}{
+		{"ada@example.com", "Subscribed"},
+		{"ADA@example.com", "Subscribed"},
+		{"not an address", "valid email"},
+		{"Ada <ada@example.com>", "valid email"},
+	}
+	for _, tc := range tt {
+		w := httptest.NewRecorder()
+		subscribeHandler(w, formRequest("/subscribe", url.Values{"email": {tc.email}}, nil))
+		if w.Code != http.StatusSeeOther || !strings.Contains(w.Header().Get("Set-Cookie"), flashCookie) {
+			t.Fatalf("%q: expected a redirect with a flash, got %d", tc.email, w.Code)
+		}
+	}
+
+	list, err := loadSubscribers()
+	if err != nil || len(list) != 1 || list[0].Token == "" {
+		t.Fatalf("expected one subscriber with a token, got %v %v", list, err)
+	}
+
+	w := httptest.NewRecorder()
+	unsubscribeHandler(w, httptest.NewRequest("GET", "/unsubscribe?token="+list[0].Token, nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected 303, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	unsubscribeHandler(w, httptest.NewRequest("GET", "/unsubscribe?token="+list[0].Token, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown token to 404, got %d", w.Code)
+	}
+	if list, _ := loadSubscribers(); len(list) != 0 {
+		t.Fatalf("expected no subscribers left, got %v", list)
+	}
+}
+
+func TestNotifyOnPublish(t *testing.T) {
+	useTempRecords(t)
+	sent := useTestNewsletter(t)
+	if err := subscribe("ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Record{Title: "Hello", Content: "draft"}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(form url.Values) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		saveHandler(w, formRequest("/save/hello", form, nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("expected 302, got %d", w.Code)
+		}
+	}
+	save(url.Values{"title": {"Hello"}, "content": {"**world**"}, "published": {"on"}})
+
+	select {
+	case msg := <-sent:
+		for _, want := range []string{"To: ada@example.com", "Subject: Hello", "<strong>world</strong>", "/show/hello", "/unsubscribe?token="} {
+			if !strings.Contains(msg, want) {
+				t.Fatalf("expected the mail to contain %q:\n%s", want, msg)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a mail when the post was published")
+	}
+
+	// saving an already published post doesn't mail anyone again
+	save(url.Values{"title": {"Hello"}, "content": {"again"}, "published": {"on"}})
+	select {
+	case msg := <-sent:
+		t.Fatalf("unexpected mail:\n%s", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNotifyOnAPIPublish(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	sent := useTestNewsletter(t)
+	key := useTestAPIKey(t)
+	if err := subscribe("ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Record{Title: "Hello", Content: "draft"}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(target string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		apiRecordsHandler(w, keyRequest("POST", target, key, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, w.Code, w.Body)
+		}
+	}
+	post("/api/records/hello/publish")
+	select {
+	case msg := <-sent:
+		if !strings.Contains(msg, "/show/hello") {
+			t.Fatalf("expected the mail to link the post:\n%s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a mail when the post was published through the API")
+	}
+
+	// unpublishing mails nobody
+	post("/api/records/hello/unpublish")
+	select {
+	case msg := <-sent:
+		t.Fatalf("unexpected mail:\n%s", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
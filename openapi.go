@@ -0,0 +1,69 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// openAPISpec describes the /api/ routes; keep it in step with api.go. It
+// is written in the JSON subset of YAML, so it is served as JSON as is.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+func init() {
+	if !json.Valid(openAPISpec) {
+		panic("openapi.yaml must be written as JSON-compatible YAML")
+	}
+}
+
+// swaggerUIVersion is the Swagger UI release /api/docs loads.
+const swaggerUIVersion = "5.11.0"
+
+const swaggerUIBase = "https://unpkg.com/swagger-ui-dist@" + swaggerUIVersion
+
+// openAPIHandler serves /api/openapi.json.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+var apiDocsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<title>API docs</title>
+		<link rel="stylesheet" href="{{ .Base }}/swagger-ui.css">
+	</head>
+	<body>
+		<div id="swagger-ui"></div>
+		<script src="{{ .Base }}/swagger-ui-bundle.js"></script>
+		<script nonce="{{ .Nonce }}">
+			SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui"});
+		</script>
+	</body>
+</html>
+`))
+
+// apiDocsHandler serves /api/docs, Swagger UI showing the spec. Swagger UI
+// comes from a CDN, so the page gets a policy of its own allowing that and
+// its one inline script.
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	nonce, err := randomToken()
+	if err != nil {
+		http.Error(w, "unable to render the docs", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Security-Policy", fmt.Sprintf(
+		"default-src 'self'; script-src 'nonce-%s' %s/; style-src %s/; img-src 'self' data:; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+		nonce, swaggerUIBase, swaggerUIBase))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	apiDocsPage.Execute(w, struct{ Base, Nonce string }{swaggerUIBase, nonce})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	openAPIHandler(w, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var spec struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.0") {
+		t.Fatalf("expected an OpenAPI 3.0 document, got %q", spec.OpenAPI)
+	}
+
+	// every action apiPath routes should be documented
+	actions := regexp.MustCompile(`\(([a-z|]+)\)\)\?\$$`).FindStringSubmatch(apiPath.String())
+	if actions == nil {
+		t.Fatalf("unable to find the actions in %s", apiPath)
+	}
+	want := []string{"/api/records", "/api/records/delete", "/api/records/{slug}"}
+	for _, a := range strings.Split(actions[1], "|") {
+		want = append(want, "/api/records/{slug}/"+a)
+	}
+	for _, p := range want {
+		if _, ok := spec.Paths[p]; !ok {
+			t.Errorf("expected the spec to document %s", p)
+		}
+	}
+}
+
+func TestAPIDocs(t *testing.T) {
+	w := httptest.NewRecorder()
+	apiDocsHandler(w, httptest.NewRequest("GET", "/api/docs", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	nonce := regexp.MustCompile(`nonce="([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+	if nonce == nil {
+		t.Fatalf("expected a script nonce in %s", w.Body.String())
+	}
+	csp := w.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "'nonce-"+nonce[1]+"'") || !strings.Contains(csp, swaggerUIBase) {
+		t.Fatalf("expected the policy to allow the page's scripts, got %q", csp)
+	}
+	if !strings.Contains(w.Body.String(), "/api/openapi.json") {
+		t.Fatal("expected the page to load the spec")
+	}
+}
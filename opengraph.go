@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// siteTitle names the blog in page titles and feeds, and with
+	// siteDescription stands in for a post's own title and description
+	// where it doesn't have them. BLOG_SITE_TITLE is its older name.
+	siteTitle       = envOr("BLOG_TITLE", envOr("BLOG_SITE_TITLE", "Crud Engine with net/http"))
+	siteDescription = envOr("BLOG_SITE_DESCRIPTION", "")
+	// siteImage is shared with posts that have no featured image
+	siteImage = envOr("BLOG_SITE_IMAGE", "")
+)
+
+// featuredImageKey is the metadata key holding a post's featured image.
+const featuredImageKey = "image"
+
+// FeaturedImage is the URL of the post's featured image, if it has one that
+// is safe to link to.
+func (r *Record) FeaturedImage() string {
+	img := strings.TrimSpace(r.GetMeta(featuredImageKey))
+	if img == "" || !safeURL(img) {
+		return ""
+	}
+	return img
+}
+
+// OpenGraph is what the show page's head tells social sites about a post.
+type OpenGraph struct {
+	SiteName    string
+	Title       string
+	Description string
+	Image       string
+	URL         string
+}
+
+// Card is the Twitter card type: a large one when there is an image.
+func (og *OpenGraph) Card() string {
+	if og.Image != "" {
+		return "summary_large_image"
+	}
+	return "summary"
+}
+
+// openGraphFor describes rec for sharing, falling back to the site's title,
+// description and image. Social sites need absolute URLs, so relative ones
+// are resolved against the site's address.
+func openGraphFor(r *http.Request, rec *Record) *OpenGraph {
+	base := baseURL(r)
+	og := &OpenGraph{
+		SiteName:    siteTitle,
+		Title:       rec.Title,
+		Description: excerpt(rec.Content, feedExcerptWords),
+		Image:       rec.FeaturedImage(),
+		URL:         canonicalURL(base, rec),
+	}
+	if og.Title == "" {
+		og.Title = siteTitle
+	}
+	if og.Description == "" {
+		og.Description = siteDescription
+	}
+	if og.Image == "" {
+		og.Image = siteImage
+	}
+	og.Image = absoluteURL(base, og.Image)
+	return og
+}
+
+// canonicalURL is the address search engines should index rec under: its
+// own CanonicalURL when it was moved or cross-posted, otherwise its show
+// page on base.
+func canonicalURL(base string, rec *Record) string {
+	if rec.CanonicalURL != "" {
+		return rec.CanonicalURL
+	}
+	return base + "/show/" + rec.Slug()
+}
+
+// absoluteHTTPURL reports whether u is a full http or https URL.
+func absoluteHTTPURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// absoluteURL resolves a site-relative path like "/static/a.png" against
+// base, leaving full URLs alone.
+func absoluteURL(base, u string) string {
+	if strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") {
+		return base + u
+	}
+	return u
+}
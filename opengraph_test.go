@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenGraphFor(t *testing.T) {
+	oldTitle, oldDesc, oldImage, oldURL := siteTitle, siteDescription, siteImage, siteURL
+	siteTitle, siteDescription, siteImage, siteURL = "My Blog", "Notes on things", "/static/site.png", ""
+	t.Cleanup(func() { siteTitle, siteDescription, siteImage, siteURL = oldTitle, oldDesc, oldImage, oldURL })
+
+	r := httptest.NewRequest("GET", "http://blog.example/show/x", nil)
+	tt := []struct {
+		name string
+		rec  *Record
+		want OpenGraph
+	}{
+		{"full", &Record{Title: "Hello", Content: "Some *text*.", Meta: map[string]string{"image": "https://cdn.example/a.png"}},
+			OpenGraph{"My Blog", "Hello", "Some text.", "https://cdn.example/a.png", "http://blog.example/show/hello"}},
+		{"relative image", &Record{Title: "Hello", Content: "x", Meta: map[string]string{"image": "/static/a.png"}},
+			OpenGraph{"My Blog", "Hello", "x", "http://blog.example/static/a.png", "http://blog.example/show/hello"}},
+		{"fallbacks", &Record{StoredSlug: "empty"},
+			OpenGraph{"My Blog", "My Blog", "Notes on things", "http://blog.example/static/site.png", "http://blog.example/show/empty"}},
+		{"unsafe image", &Record{Title: "Hello", Content: "x", Meta: map[string]string{"image": "javascript:alert(1)"}},
+			OpenGraph{"My Blog", "Hello", "x", "http://blog.example/static/site.png", "http://blog.example/show/hello"}},
+		{"canonical", &Record{Title: "Hello", Content: "x", CanonicalURL: "https://elsewhere.example/hello"},
+			OpenGraph{"My Blog", "Hello", "x", "http://blog.example/static/site.png", "https://elsewhere.example/hello"}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := openGraphFor(r, tc.rec); *got != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, *got)
+			}
+		})
+	}
+}
+
+func TestShowPageOpenGraph(t *testing.T) {
+	useTempRecords(t)
+	rec := &Record{Title: "Shared", Content: "Worth a click", Published: true, Meta: map[string]string{"image": "https://cdn.example/s.png"}}
+	if err := CreateRecord(rec, false); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "http://blog.example/show/shared", nil))
+	for _, want := range []string{
+		`<meta property="og:title" content="Shared">`,
+		`<meta property="og:description" content="Worth a click">`,
+		`<meta property="og:image" content="https://cdn.example/s.png">`,
+		`<meta property="og:url" content="http://blog.example/show/shared">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected %s in:\n%s", want, w.Body)
+		}
+	}
+}
+
+func TestShowPageCanonical(t *testing.T) {
+	useTempRecords(t)
+	for _, rec := range []*Record{
+		{Title: "Here", Published: true},
+		{Title: "Cross posted", Published: true, CanonicalURL: "https://elsewhere.example/post?id=1&lang=en"},
+	} {
+		if err := CreateRecord(rec, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		slug string
+		want string
+	}{
+		{"here", `<link rel="canonical" href="http://blog.example/show/here">`},
+		{"cross-posted", `<link rel="canonical" href="https://elsewhere.example/post?id=1&amp;lang=en">`},
+	}
+	for _, tc := range tt {
+		w := httptest.NewRecorder()
+		showHandler(w, httptest.NewRequest("GET", "http://blog.example/show/"+tc.slug, nil))
+		if body := w.Body.String(); !strings.Contains(body, tc.want) || strings.Count(body, `rel="canonical"`) != 1 {
+			t.Errorf("expected one %s in:\n%s", tc.want, body)
+		}
+	}
+}
+
+func TestCanonicalURLValidation(t *testing.T) {
+	for u, ok := range map[string]bool{
+		"https://elsewhere.example/post": true,
+		"http://elsewhere.example":       true,
+		"/show/here":                     false,
+		"javascript:alert(1)":            false,
+		"https://":                       false,
+	} {
+		errs := validateRecord(&Record{Title: "Hello", CanonicalURL: u})
+		if (len(errs) == 0) != ok {
+			t.Errorf("%q: expected valid %v, got %q", u, ok, errs)
+		}
+	}
+}
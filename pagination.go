@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errPageOutOfRange = errors.New("page out of range")
+
+var pageSize = envInt("BLOG_PAGE_SIZE", 20)
+
+// Pagination describes one page of a listing. Start and End index into the
+// full, already sorted list.
+type Pagination struct {
+	Page       int
+	TotalPages int
+	PrevURL    string
+	NextURL    string
+	Start      int
+	End        int
+}
+
+// paginate works out which slice of total items belongs on the given page of
+// the listing at base. Page 1 is always the bare base URL. Pages past the end
+// are an error rather than being clamped, so crawlers see a 404.
+func paginate(total, page, size int, base string) (*Pagination, error) {
+	if size < 1 {
+		size = 1
+	}
+	pages := (total + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+	if page < 1 || page > pages {
+		return nil, errPageOutOfRange
+	}
+
+	p := &Pagination{Page: page, TotalPages: pages}
+	p.Start = (page - 1) * size
+	p.End = p.Start + size
+	if p.End > total {
+		p.End = total
+	}
+	if page > 1 {
+		p.PrevURL = pageURL(base, page-1)
+	}
+	if page < pages {
+		p.NextURL = pageURL(base, page+1)
+	}
+	return p, nil
+}
+
+func pageURL(base string, page int) string {
+	if page == 1 {
+		return base
+	}
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%spage=%d", base, sep, page)
+}
+
+// parsePage reads the ?page= parameter, treating a missing one as page 1.
+func parsePage(raw string) (int, error) {
+	if raw == "" {
+		return 1, nil
+	}
+	return strconv.Atoi(raw)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPaginate(t *testing.T) {
+	tt := []struct {
+		name   string
+		total  int
+		page   int
+		want   *Pagination
+		outErr error
+	}{
+		{"empty listing", 0, 1, &Pagination{Page: 1, TotalPages: 1}, nil},
+		{"first page", 25, 1, &Pagination{Page: 1, TotalPages: 3, NextURL: "/?page=2", Start: 0, End: 10}, nil},
+		{"middle page", 25, 2, &Pagination{Page: 2, TotalPages: 3, PrevURL: "/", NextURL: "/?page=3", Start: 10, End: 20}, nil},
+		{"last page", 25, 3, &Pagination{Page: 3, TotalPages: 3, PrevURL: "/?page=2", Start: 20, End: 25}, nil},
+		{"past the end", 25, 4, nil, errPageOutOfRange},
+		{"zero", 25, 0, nil, errPageOutOfRange},
+		{"negative", 25, -1, nil, errPageOutOfRange},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := paginate(tc.total, tc.page, 10, "/")
+			if err != tc.outErr {
+				t.Fatalf("expected error %v, got %v", tc.outErr, err)
+			}
+			if tc.want == nil {
+				return
+			}
+			if *p != *tc.want {
+				t.Fatalf("\nexpected: %+v\nactual: %+v", *tc.want, *p)
+			}
+		})
+	}
+}
+
+func TestPageURLWithQuery(t *testing.T) {
+	if got := pageURL("/tag/go?sort=updated", 2); got != "/tag/go?sort=updated&page=2" {
+		t.Fatalf("unexpected url %s", got)
+	}
+}
+
+func TestIndexPagination(t *testing.T) {
+	useTempRecords(t)
+	old := pageSize
+	pageSize = 2
+	t.Cleanup(func() { pageSize = old })
+
+	for i := 1; i <= 3; i++ {
+		rec := &Record{Title: fmt.Sprintf("Post %d", i), Published: true, CreatedAt: time.Date(2020, 1, i, 0, 0, 0, 0, time.UTC)}
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		target string
+		code   int
+	}{
+		{"/", http.StatusOK},
+		{"/?page=2", http.StatusOK},
+		{"/?page=1", http.StatusMovedPermanently},
+		{"/?page=3", http.StatusNotFound},
+		{"/?page=abc", http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			indexHandler(w, httptest.NewRequest("GET", tc.target, nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+)
+
+var pinPath = regexp.MustCompile(`^/admin/(pin|unpin)/([a-zA-Z0-9\-]+)$`)
+
+// splitPinned separates the pinned records, newest first, from the rest,
+// which keep their order.
+func splitPinned(records []*Record) (pinned, rest []*Record) {
+	rest = make([]*Record, 0, len(records))
+	for _, r := range records {
+		if r.Pinned {
+			pinned = append(pinned, r)
+		} else {
+			rest = append(rest, r)
+		}
+	}
+	sortNewestFirst(pinned)
+	return pinned, rest
+}
+
+// pinHandler serves POST /admin/pin/{slug} and /admin/unpin/{slug}.
+func pinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m := pinPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	pin, slug := m[1] == "pin", m[2]
+
+	rec, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		errorf("unable to load %s: %v", slug, err)
+		http.Error(w, "unable to load the post", http.StatusInternalServerError)
+		return
+	}
+	if !allowed(r, actionEdit, rec) {
+		forbidden(w, r, "pin this post")
+		return
+	}
+
+	if rec.Pinned != pin {
+		rec.Pinned = pin
+		if err := rec.Save(); err != nil {
+			errorf("unable to save %s: %v", slug, err)
+			http.Error(w, "unable to save the post", http.StatusInternalServerError)
+			return
+		}
+	}
+	if pin {
+		setFlash(w, flashInfo, "Pinned "+rec.Title)
+	} else {
+		setFlash(w, flashInfo, "Unpinned "+rec.Title)
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
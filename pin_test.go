@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitPinned(t *testing.T) {
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []*Record{
+		{Title: "c"}, {Title: "older", Pinned: true, CreatedAt: day}, {Title: "a"},
+		{Title: "newer", Pinned: true, CreatedAt: day.Add(time.Hour)}, {Title: "b"},
+	}
+	pinned, rest := splitPinned(records)
+	if got := titles(pinned); got != "newer,older" {
+		t.Fatalf("expected pinned records newest first, got %s", got)
+	}
+	if got := titles(rest); got != "c,a,b" {
+		t.Fatalf("expected the rest in their original order, got %s", got)
+	}
+}
+
+func TestPinHandler(t *testing.T) {
+	dir := useTempRecords(t)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeRecordAt(t, dir, &Record{Title: "About", Published: true}, start)
+	writeRecordAt(t, dir, &Record{Title: "Latest news", Published: true}, start.Add(time.Hour))
+
+	tt := []struct {
+		method string
+		target string
+		code   int
+	}{
+		{"POST", "/admin/pin/about", http.StatusSeeOther},
+		{"POST", "/admin/pin/about", http.StatusSeeOther},
+		{"GET", "/admin/pin/about", http.StatusMethodNotAllowed},
+		{"POST", "/admin/pin/nope", http.StatusNotFound},
+		{"POST", "/admin/pin/", http.StatusNotFound},
+	}
+	for _, tc := range tt {
+		w := httptest.NewRecorder()
+		pinHandler(w, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.target, tc.code, w.Code)
+		}
+	}
+
+	rec, err := LoadRecord("about")
+	if err != nil || !rec.Pinned {
+		t.Fatalf("expected about to be pinned, got %+v %v", rec, err)
+	}
+
+	w := httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	about, news := strings.Index(body, "/show/about"), strings.Index(body, "/show/latest-news")
+	if about < 0 || news < 0 || about > news || strings.Count(body, "/show/about") != 1 {
+		t.Fatalf("expected the pinned post once, above newer ones:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	pinHandler(w, httptest.NewRequest("POST", "/admin/unpin/about", nil))
+	if rec, _ := LoadRecord("about"); rec.Pinned {
+		t.Fatal("expected about to be unpinned")
+	}
+}
+
+func TestPinnedPostsLeadIndex(t *testing.T) {
+	dir := useTempRecords(t)
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeRecordAt(t, dir, &Record{Title: "Ancient notice", Published: true, Pinned: true}, start)
+	writeRecordAt(t, dir, &Record{Title: "Fresh post", Published: true}, start.Add(48*time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Old notice", Published: true, Pinned: true}, start.Add(time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "Middle post", Published: true}, start.Add(24*time.Hour))
+
+	w := httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	order := []string{"/show/old-notice", "/show/ancient-notice", "/show/fresh-post", "/show/middle-post"}
+	last := -1
+	for _, link := range order {
+		i := strings.Index(body, link)
+		if i <= last {
+			t.Fatalf("expected the order %v, got:\n%s", order, body)
+		}
+		last = i
+	}
+}
+
+func TestEditFormTogglesPinned(t *testing.T) {
+	useTempRecords(t)
+	rec := &Record{Title: "Notice", Published: true}
+	if err := CreateRecord(rec, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pinned := range []bool{true, false} {
+		form := url.Values{"title": {"Notice"}, "content": {"x"}, "published": {"1"}}
+		if pinned {
+			form.Set("pinned", "1")
+		}
+		w := httptest.NewRecorder()
+		saveHandler(w, formRequest("/save/notice", form, nil))
+		if w.Code != http.StatusFound {
+			t.Fatalf("expected a redirect, got %d: %s", w.Code, w.Body)
+		}
+		rec, err := LoadRecord("notice")
+		if err != nil || rec.Pinned != pinned {
+			t.Fatalf("expected pinned=%v, got %+v %v", pinned, rec, err)
+		}
+	}
+}
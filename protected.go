@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	postAccessCookie = "post_access"
+	// postAccessMaxAge is how long a passphrase, once given, opens a post
+	postAccessMaxAge = 60 * 60
+)
+
+// wrongPassphraseDelay slows down guessing a post's passphrase.
+var wrongPassphraseDelay = time.Second
+
+// Protected reports whether the post needs a passphrase to be read. Such
+// posts are left out of feeds, search and the sitemap.
+func (r *Record) Protected() bool {
+	return r.AccessPassword != ""
+}
+
+// accessFingerprint ties an access cookie to the passphrase it was given
+// for, so changing the passphrase shuts out everyone who had the old one.
+func accessFingerprint(rec *Record) string {
+	sum := sha256.Sum256([]byte(rec.AccessPassword))
+	return hex.EncodeToString(sum[:8])
+}
+
+// hasPostAccess reports whether r carries an unexpired access cookie for rec.
+func hasPostAccess(r *http.Request, rec *Record) bool {
+	c, err := r.Cookie(postAccessCookie)
+	if err != nil {
+		return false
+	}
+	v, err := verifyValue(c.Value)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(v, " ")
+	if len(parts) != 3 || parts[0] != rec.Slug() || parts[1] != accessFingerprint(rec) {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	return err == nil && time.Now().Unix() < expires
+}
+
+// grantPostAccess lets the browser read rec for postAccessMaxAge. The cookie
+// is only sent back for the post's own page.
+func grantPostAccess(w http.ResponseWriter, rec *Record) {
+	expires := time.Now().Add(postAccessMaxAge * time.Second).Unix()
+	http.SetCookie(w, &http.Cookie{
+		Name:     postAccessCookie,
+		Value:    signValue(fmt.Sprintf("%s %s %d", rec.Slug(), accessFingerprint(rec), expires)),
+		Path:     "/show/" + rec.Slug(),
+		MaxAge:   postAccessMaxAge,
+		HttpOnly: true,
+		Secure:   tlsDomain != "",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// canRead reports whether r may read rec: anyone can read an unprotected
+// post, and a protected one needs its passphrase or the right to edit it.
+func canRead(r *http.Request, rec *Record) bool {
+	if !rec.Protected() || hasPostAccess(r, rec) {
+		return true
+	}
+	return currentUser(r) != "" && allowed(r, actionEdit, rec)
+}
+
+// unlockHandler asks for the passphrase of rec, and on a POST checks it.
+func unlockHandler(w http.ResponseWriter, r *http.Request, rec *Record) {
+	w.Header().Set("Cache-Control", "no-store")
+	data := &TemplateData{Record: &Record{Title: rec.Title, StoredSlug: rec.Slug()}}
+	if r.Method != http.MethodPost {
+		renderTemplate(w, r, "unlock", data)
+		return
+	}
+	pass := r.PostFormValue("passphrase")
+	if pass == "" || bcrypt.CompareHashAndPassword([]byte(rec.AccessPassword), []byte(pass)) != nil {
+		infof("wrong passphrase for %s from %s", rec.Slug(), clientIP(r))
+		time.Sleep(wrongPassphraseDelay)
+		data.Errors = []string{"That passphrase isn't right."}
+		renderTemplateStatus(w, r, http.StatusUnauthorized, "unlock", data)
+		return
+	}
+	grantPostAccess(w, rec)
+	http.Redirect(w, r, "/show/"+rec.Slug(), http.StatusSeeOther)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// saveProtected saves a published post readable with the passphrase.
+func saveProtected(t *testing.T, title, content, passphrase string) *Record {
+	t.Helper()
+	hash, err := hashPassword(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &Record{Title: title, Content: content, Published: true, AccessPassword: hash}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func unlock(slug, passphrase string) *httptest.ResponseRecorder {
+	form := url.Values{"passphrase": {passphrase}}
+	r := httptest.NewRequest("POST", "/show/"+slug, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	requireFormContentType(limitBody(showHandler))(w, r)
+	return w
+}
+
+func TestProtectedPost(t *testing.T) {
+	useTempRecords(t)
+	old := wrongPassphraseDelay
+	wrongPassphraseDelay = 0
+	t.Cleanup(func() { wrongPassphraseDelay = old })
+	saveProtected(t, "Family News", "the secret content", "open sesame")
+
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/family-news", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="passphrase"`) || strings.Contains(w.Body.String(), "secret content") {
+		t.Fatalf("expected a passphrase prompt, got %d: %s", w.Code, w.Body)
+	}
+
+	w = unlock("family-news", "wrong")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "isn&#39;t right") {
+		t.Fatalf("expected the prompt again, got %d: %s", w.Code, w.Body)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Fatal("expected no cookie for a wrong passphrase")
+	}
+
+	w = unlock("family-news", "open sesame")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect to the post, got %d", w.Code)
+	}
+	c := w.Result().Cookies()[0]
+	if c.Name != postAccessCookie || c.Path != "/show/family-news" {
+		t.Fatalf("expected a cookie for the post only, got %+v", c)
+	}
+
+	r := httptest.NewRequest("GET", "/show/family-news", nil)
+	r.AddCookie(c)
+	w = httptest.NewRecorder()
+	showHandler(w, r)
+	if !strings.Contains(w.Body.String(), "secret content") || w.Header().Get("Cache-Control") != "private, no-store" {
+		t.Fatalf("expected the post with the cookie, got %s", w.Body)
+	}
+
+	// a new passphrase shuts out the old cookie
+	saveProtected(t, "Family News", "the secret content", "new words")
+	r = httptest.NewRequest("GET", "/show/family-news", nil)
+	r.AddCookie(c)
+	w = httptest.NewRecorder()
+	showHandler(w, r)
+	if strings.Contains(w.Body.String(), "secret content") {
+		t.Fatal("expected the old cookie to stop working")
+	}
+}
+
+func TestProtectedPostAccessCookie(t *testing.T) {
+	rec := &Record{Title: "One", AccessPassword: "hash"}
+	other := &Record{Title: "Two", AccessPassword: "hash"}
+	w := httptest.NewRecorder()
+	grantPostAccess(w, rec)
+	c := w.Result().Cookies()[0]
+
+	r := httptest.NewRequest("GET", "/show/one", nil)
+	r.AddCookie(c)
+	if !hasPostAccess(r, rec) || hasPostAccess(r, other) {
+		t.Fatal("expected the cookie to open its own post only")
+	}
+	r = httptest.NewRequest("GET", "/show/one", nil)
+	r.AddCookie(&http.Cookie{Name: postAccessCookie, Value: signValue("one " + accessFingerprint(rec) + " 1")})
+	if hasPostAccess(r, rec) {
+		t.Fatal("expected an expired cookie to be refused")
+	}
+}
+
+func TestProtectedPostsHidden(t *testing.T) {
+	useTempRecords(t)
+	sitemaps.reset()
+	t.Cleanup(sitemaps.reset)
+	saveProtected(t, "Hidden Needle", "needle in the secret", "pw")
+	if err := (&Record{Title: "Open Needle", Content: "needle in the open", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := SearchRecords("needle")
+	if err != nil || len(results) != 1 || results[0].Title != "Open Needle" {
+		t.Fatalf("expected only the open post in search, got %v, %v", results, err)
+	}
+	records, err := feedRecords()
+	if err != nil || len(records) != 1 || records[0].Title != "Open Needle" {
+		t.Fatalf("expected only the open post in feeds, got %v, %v", records, err)
+	}
+
+	w := httptest.NewRecorder()
+	monthlySitemapHandler(w, httptest.NewRequest("GET", "/sitemap/"+records[0].CreatedAt.UTC().Format(sitemapMonthLayout)+".xml", nil))
+	if strings.Contains(w.Body.String(), "hidden-needle") || !strings.Contains(w.Body.String(), "open-needle") {
+		t.Fatalf("expected only the open post in the sitemap, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	indexHandler(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); strings.Contains(body, "in the secret") || !strings.Contains(body, "Hidden Needle") {
+		t.Fatalf("expected the protected post listed without an excerpt, got %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	listRecordsHandler(w, httptest.NewRequest("GET", "/api/records", nil))
+	if strings.Contains(w.Body.String(), "access_password") {
+		t.Fatalf("expected the passphrase hash to stay out of the API, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/history/hidden-needle", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected the protected post's history to be hidden, got %d", w.Code)
+	}
+}
+
+func TestPassphraseForm(t *testing.T) {
+	useTempRecords(t)
+	rec := saveProtected(t, "Form Post", "body", "first")
+
+	post := func(form url.Values) *Record {
+		r := httptest.NewRequest("POST", "/save/form-post", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		got, errs := parseRecordForm(r, modeUpdate, rec)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		return got
+	}
+	if got := post(url.Values{"title": {"Form Post"}, "content": {"body"}}); got.AccessPassword != rec.AccessPassword {
+		t.Fatal("expected an empty field to keep the passphrase")
+	}
+	if got := post(url.Values{"title": {"Form Post"}, "content": {"body"}, "access_password": {"second"}}); got.AccessPassword == rec.AccessPassword || got.AccessPassword == "second" {
+		t.Fatal("expected a new hashed passphrase")
+	}
+	if got := post(url.Values{"title": {"Form Post"}, "content": {"body"}, "remove_access_password": {"1"}}); got.Protected() {
+		t.Fatal("expected the passphrase to be removed")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(2)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("1.2.3.4"); !ok {
+			t.Fatalf("request %d should be within the burst", i)
+		}
+	}
+	ok, wait := l.allow("1.2.3.4")
+	if ok {
+		t.Fatal("third request should be limited")
+	}
+	if wait != 30*time.Second {
+		t.Fatalf("expected to wait 30s for a token, got %v", wait)
+	}
+
+	// other clients have their own bucket
+	if ok, _ := l.allow("5.6.7.8"); !ok {
+		t.Fatal("a different client should not be limited")
+	}
+
+	now = now.Add(30 * time.Second)
+	if ok, _ := l.allow("1.2.3.4"); !ok {
+		t.Fatal("a token should have refilled after 30s")
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(60)
+	l.now = func() time.Time { return now }
+
+	l.allow("stale")
+	now = now.Add(30 * time.Second)
+	l.allow("recent")
+	now = now.Add(45 * time.Second)
+	l.cleanup()
+
+	if _, ok := l.buckets["stale"]; ok {
+		t.Fatal("stale client was not cleaned up")
+	}
+	if _, ok := l.buckets["recent"]; !ok {
+		t.Fatal("recent client should be kept")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	l := newRateLimiter(1)
+	h := l.limit(func(w http.ResponseWriter, r *http.Request) {})
+
+	codes := []int{http.StatusOK, http.StatusTooManyRequests}
+	for _, code := range codes {
+		r := httptest.NewRequest("POST", "/create/", nil)
+		r.RemoteAddr = "1.2.3.4:5678"
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != code {
+			t.Fatalf("expected %d, got %d", code, w.Code)
+		}
+		if code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+			t.Fatalf("expected Retry-After: 60, got %q", w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	h := newRateLimiter(0).limit(func(w http.ResponseWriter, r *http.Request) {})
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("POST", "/create/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200 with limiting off, got %d", w.Code)
+		}
+	}
+}
+
+func TestAPIRecordsLimitsOnlyWrites(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	old := writes
+	writes = newRateLimiter(1)
+	t.Cleanup(func() { writes = old })
+	key := useTestAPIKey(t)
+	if err := (&Record{Title: "Limited", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reading stats and related posts doesn't use up the write budget
+	for i := 0; i < 3; i++ {
+		for _, target := range []string{"/api/records/limited/stats", "/api/records/limited/related"} {
+			w := httptest.NewRecorder()
+			apiRecordsHandler(w, keyRequest("GET", target, key, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: expected 200, got %d", target, w.Code)
+			}
+		}
+	}
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		apiRecordsHandler(w, keyRequest("POST", "/api/records/limited/unpublish", key, nil))
+		if w.Code != want {
+			t.Fatalf("write %d: expected %d, got %d", i, want, w.Code)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// readOnly keeps the blog readable but turns away every change to it, for
+// maintenance. Logging in still works, since sessions live in memory.
+var readOnly = envBool("BLOG_READONLY", false)
+
+const readOnlyMessage = "The blog is read-only for maintenance. Please try again later."
+
+// requireWritable answers 503 while the blog is read-only. It wraps the
+// routes that change posts or settings and the forms leading to them.
+func requireWritable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, http.StatusServiceUnavailable, "the blog is read-only for maintenance")
+			} else {
+				renderTemplateStatus(w, r, http.StatusServiceUnavailable, "error", &TemplateData{Errors: []string{readOnlyMessage}})
+			}
+			return
+		}
+		h(w, r)
+	}
+}
+
+// healthzHandler serves /healthz for load balancers and monitoring.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	mode := "read-write"
+	if readOnly {
+		mode = "read-only"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "mode": mode})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useReadOnly(t *testing.T) {
+	t.Helper()
+	old := readOnly
+	readOnly = true
+	t.Cleanup(func() { readOnly = old })
+}
+
+func TestReadOnly(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	useReadOnly(t)
+
+	if err := (&Record{Title: "Frozen", Content: "still here", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	form := func(target string) *http.Request {
+		r := authRequest("POST", target)
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Body = http.NoBody
+		return r
+	}
+	tt := []struct {
+		name string
+		h    http.HandlerFunc
+		r    *http.Request
+	}{
+		{"edit form", requireWritable(requireWriteAuth(editHandler)), authRequest("GET", "/edit/frozen")},
+		{"save", requireWritable(requireWriteAuth(saveHandler)), form("/save/frozen")},
+		{"delete", requireWritable(requireWriteAuth(deleteHandler)), form("/delete/frozen")},
+		{"publish API", apiRecordsHandler, authRequest("POST", "/api/records/frozen/unpublish")},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tc.h(w, tc.r)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503, got %d", w.Code)
+			}
+			if strings.HasPrefix(tc.r.URL.Path, "/api/") {
+				if !strings.Contains(w.Body.String(), `"error"`) {
+					t.Fatalf("expected a JSON error, got %s", w.Body)
+				}
+			} else if !strings.Contains(w.Body.String(), "read-only for maintenance") {
+				t.Fatalf("expected the maintenance message, got %s", w.Body)
+			}
+		})
+	}
+
+	rec, err := LoadRecord("frozen")
+	if err != nil || !rec.Published {
+		t.Fatalf("expected the post to be untouched, got %+v, %v", rec, err)
+	}
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/frozen", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected posts to stay readable, got %d", w.Code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	for _, ro := range []bool{false, true} {
+		old := readOnly
+		readOnly = ro
+		w := httptest.NewRecorder()
+		healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+		readOnly = old
+
+		var got map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := map[bool]string{false: "read-write", true: "read-only"}[ro]
+		if w.Code != http.StatusOK || got["status"] != "ok" || got["mode"] != want {
+			t.Fatalf("read-only %v: unexpected health %d %v", ro, w.Code, got)
+		}
+	}
+}
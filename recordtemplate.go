@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// placeholders are what a template record's content can refer to, e.g.
+// {{.Date}} for the day the new post is started.
+type placeholders struct {
+	Date   string
+	Year   int
+	Author string
+}
+
+// fillPlaceholders expands the placeholders in content. Content that isn't
+// a valid template, say a post about Go templates, is used as it is.
+func fillPlaceholders(content, user string, now time.Time) string {
+	t, err := template.New("").Option("missingkey=zero").Parse(content)
+	if err != nil {
+		debugf("not filling placeholders: %v", err)
+		return content
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, placeholders{Date: now.Format("2006-01-02"), Year: now.Year(), Author: authorName(user)})
+	if err != nil {
+		debugf("not filling placeholders: %v", err)
+		return content
+	}
+	return buf.String()
+}
+
+// fromTemplate is the unsaved record the new form starts with when it's
+// opened from tmpl.
+func fromTemplate(tmpl *Record, user string, now time.Time) *Record {
+	rec := &Record{Content: fillPlaceholders(tmpl.Content, user, now), Published: true}
+	if len(tmpl.Meta) > 0 {
+		rec.Meta = make(map[string]string, len(tmpl.Meta))
+		for k, v := range tmpl.Meta {
+			rec.Meta[k] = v
+		}
+	}
+	return rec
+}
+
+// loadTemplate loads the template record with slug. Records that aren't
+// templates are reported as not found.
+func loadTemplate(slug string) (*Record, error) {
+	rec, err := LoadRecord(slug)
+	if err != nil {
+		return nil, err
+	}
+	if !rec.IsTemplate {
+		return nil, ErrNotFound
+	}
+	return rec, nil
+}
+
+// templatesHandler serves /admin/templates, listing the records new posts
+// can be started from.
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowed(r, actionCreate, nil) {
+		forbidden(w, r, "write posts")
+		return
+	}
+	records, err := AllRecords()
+	if err != nil {
+		errorf("unable to load records: %v", err)
+		http.Error(w, "unable to load records", http.StatusInternalServerError)
+		return
+	}
+	templates := make([]*Record, 0)
+	for _, rec := range records {
+		if rec.IsTemplate {
+			templates = append(templates, rec)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return strings.ToLower(templates[i].Title) < strings.ToLower(templates[j].Title)
+	})
+	renderTemplate(w, r, "templates", &TemplateData{Records: templates})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFillPlaceholders(t *testing.T) {
+	now := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	tt := []struct {
+		content, want string
+	}{
+		{"Read on {{.Date}} by {{ .Author }}, {{.Year}}", "Read on 2023-04-05 by ada, 2023"},
+		{"no placeholders", "no placeholders"},
+		{"unknown {{.Rating}} stays", "unknown {{.Rating}} stays"},
+		{"a Go template: {{ range .Items }}", "a Go template: {{ range .Items }}"},
+	}
+	for _, tc := range tt {
+		if got := fillPlaceholders(tc.content, "github:ada", now); got != tc.want {
+			t.Errorf("fillPlaceholders(%q) = %q, want %q", tc.content, got, tc.want)
+		}
+	}
+}
+
+func TestNewFromTemplate(t *testing.T) {
+	useTempRecords(t)
+
+	tmpl := &Record{Title: "Book Review", Content: "## {{.Date}}\n\nRating:", IsTemplate: true, Meta: map[string]string{"rating": "?"}}
+	if err := tmpl.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Record{Title: "Plain Post", Content: "hello", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	newHandler(w, httptest.NewRequest("GET", "/new/?template=book-review", nil))
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "## "+time.Now().Format("2006-01-02")+"\n\nRating:") {
+		t.Fatalf("expected the form filled from the template, got %d: %s", w.Code, body)
+	}
+	if !strings.Contains(body, "rating=?") || strings.Contains(body, `value="Book Review"`) {
+		t.Fatalf("expected the template's metadata but not its title, got %s", body)
+	}
+
+	for _, slug := range []string{"plain-post", "missing"} {
+		w := httptest.NewRecorder()
+		newHandler(w, httptest.NewRequest("GET", "/new/?template="+slug, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", slug, w.Code)
+		}
+	}
+}
+
+func TestTemplatesPage(t *testing.T) {
+	useTempRecords(t)
+	for _, rec := range []*Record{
+		{Title: "Tutorial", IsTemplate: true},
+		{Title: "Book Review", IsTemplate: true},
+		{Title: "Ordinary", Published: true},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	templatesHandler(w, httptest.NewRequest("GET", "/admin/templates", nil))
+	body := w.Body.String()
+	review, tutorial := strings.Index(body, "/new/?template=book-review"), strings.Index(body, "/new/?template=tutorial")
+	if w.Code != http.StatusOK || review < 0 || tutorial < review {
+		t.Fatalf("expected both templates listed by title, got %d: %s", w.Code, body)
+	}
+	if strings.Contains(body, "ordinary") {
+		t.Fatal("expected ordinary posts to be left out")
+	}
+}
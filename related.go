@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+const maxRelated = 5
+
+// RelatedRecord is a post that shares tags with another one.
+type RelatedRecord struct {
+	Slug           string `json:"slug"`
+	Title          string `json:"title"`
+	SharedTagCount int    `json:"sharedTagCount"`
+}
+
+// relatedRecords returns up to limit published records from records that
+// share tags with rec, those sharing the most first and newer ones first
+// among equals. rec itself and records sharing nothing are left out.
+func relatedRecords(rec *Record, records []*Record, limit int) []RelatedRecord {
+	tags := make(map[string]bool)
+	for _, t := range rec.Tags {
+		if t = normalizeTag(t); t != "" {
+			tags[t] = true
+		}
+	}
+
+	type scored struct {
+		rec    *Record
+		shared int
+	}
+	var candidates []scored
+	for _, other := range records {
+		if !other.Published || !other.Listed() || other.Slug() == rec.Slug() {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, t := range other.Tags {
+			if t = normalizeTag(t); tags[t] && !seen[t] {
+				seen[t] = true
+			}
+		}
+		if len(seen) > 0 {
+			candidates = append(candidates, scored{other, len(seen)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].shared != candidates[j].shared {
+			return candidates[i].shared > candidates[j].shared
+		}
+		return candidates[i].rec.CreatedAt.After(candidates[j].rec.CreatedAt)
+	})
+
+	related := make([]RelatedRecord, 0, limit)
+	for _, c := range candidates {
+		if len(related) == limit {
+			break
+		}
+		related = append(related, RelatedRecord{Slug: c.rec.Slug(), Title: c.rec.Title, SharedTagCount: c.shared})
+	}
+	return related
+}
+
+// relatedHandler serves GET /api/records/{slug}/related.
+func relatedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	rec, err := LoadRecord(getAPISlug(r))
+	if errors.Is(err, ErrNotFound) || (err == nil && !rec.Published) {
+		writeJSONError(w, http.StatusNotFound, "record not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	records, err := AllRecords()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, relatedRecords(rec, records, maxRelated))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRelatedRecords(t *testing.T) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rec := &Record{StoredSlug: "target", Published: true, Tags: []string{"go", "web", "testing"}}
+	records := []*Record{
+		rec,
+		{StoredSlug: "one", Title: "One", Published: true, Tags: []string{"Go"}, CreatedAt: day},
+		{StoredSlug: "three", Title: "Three", Published: true, Tags: []string{"go", "web", "testing"}, CreatedAt: day},
+		{StoredSlug: "two-old", Title: "Two old", Published: true, Tags: []string{"go", "web", "go"}, CreatedAt: day},
+		{StoredSlug: "two-new", Title: "Two new", Published: true, Tags: []string{"web", "testing"}, CreatedAt: day.Add(time.Hour)},
+		{StoredSlug: "none", Title: "None", Published: true, Tags: []string{"cooking"}},
+		{StoredSlug: "draft", Title: "Draft", Published: false, Tags: []string{"go", "web", "testing"}},
+	}
+
+	got := relatedRecords(rec, records, 3)
+	want := []RelatedRecord{{"three", "Three", 3}, {"two-new", "Two new", 2}, {"two-old", "Two old", 2}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRelatedHandler(t *testing.T) {
+	useTempRecords(t)
+	for _, rec := range []*Record{
+		{Title: "Tagged", Published: true, Tags: []string{"go"}},
+		{Title: "Also tagged", Published: true, Tags: []string{"go"}},
+		{Title: "Alone", Published: true, Tags: []string{"solo"}},
+		{Title: "Hidden", Tags: []string{"go"}},
+	} {
+		if err := CreateRecord(rec, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		name   string
+		method string
+		slug   string
+		code   int
+		body   string
+	}{
+		{"related", "GET", "tagged", http.StatusOK, `[{"slug":"also-tagged","title":"Also tagged","sharedTagCount":1}]`},
+		{"none related", "GET", "alone", http.StatusOK, `[]`},
+		{"draft", "GET", "hidden", http.StatusNotFound, ""},
+		{"missing", "GET", "nope", http.StatusNotFound, ""},
+		{"wrong method", "POST", "tagged", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			relatedHandler(w, httptest.NewRequest(tc.method, "/api/records/"+tc.slug+"/related", nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			if tc.body == "" {
+				return
+			}
+			var got, want interface{}
+			json.Unmarshal(w.Body.Bytes(), &got)
+			json.Unmarshal([]byte(tc.body), &want)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("expected %s, got %s", tc.body, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// revisionLayout names revision files; the fixed width keeps them sortable.
+const revisionLayout = "20060102T150405.000000000Z"
+
+var (
+	historyPath = regexp.MustCompile("^/history/([a-zA-Z0-9\\-]+)(/diff)?$")
+	diffPath    = regexp.MustCompile("^/diff/([a-zA-Z0-9\\-]+)/([0-9TZ.]+)$")
+)
+
+func revisionsDir(slug string) (string, error) {
+	return safePath(filepath.Join(recordsDir, "revisions"), slug, "")
+}
+
+// saveRevision keeps a copy of every version of a record that gets saved.
+func saveRevision(slug string, data []byte) error {
+	dir, err := revisionsDir(slug)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format(revisionLayout) + ".json"
+	return ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+}
+
+// deleteRevisions removes a deleted record's history.
+func deleteRevisions(slug string) error {
+	dir, err := revisionsDir(slug)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// moveRevisions carries a record's history over when its slug changes.
+func moveRevisions(oldSlug, newSlug string) error {
+	oldDir, err := revisionsDir(oldSlug)
+	if err != nil {
+		return err
+	}
+	newDir, err := revisionsDir(newSlug)
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(oldDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(newDir, 0700); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Rename(filepath.Join(oldDir, f.Name()), filepath.Join(newDir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return os.Remove(oldDir)
+}
+
+// Revisions lists when each saved version of a record was written, oldest
+// first.
+func Revisions(slug string) ([]time.Time, error) {
+	dir, err := revisionsDir(slug)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	revs := make([]time.Time, 0, len(files))
+	for _, f := range files {
+		t, err := time.Parse(revisionLayout, strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		revs = append(revs, t)
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Before(revs[j]) })
+	return revs, nil
+}
+
+func LoadRevision(slug string, t time.Time) (*Record, error) {
+	dir, err := revisionsDir(slug)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.ReadFile(filepath.Join(dir, t.UTC().Format(revisionLayout)+".json"))
+	if err != nil {
+		return nil, err
+	}
+	return decodeRecord(file)
+}
+
+// historyReadable reports whether r may see the history of slug, which
+// would give away a protected post it can't read. Without the post there
+// is no telling who could read it, so its history stays hidden.
+func historyReadable(r *http.Request, slug string) bool {
+	rec, err := LoadRecord(slug)
+	return err == nil && visibleTo(r, rec) && canRead(r, rec)
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	m := historyPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	slug := m[1]
+	if !historyReadable(r, slug) {
+		http.NotFound(w, r)
+		return
+	}
+	if m[2] != "" {
+		revisionDiffHandler(w, r, slug)
+		return
+	}
+
+	revs, err := Revisions(slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(revs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	renderTemplate(w, r, "history", &TemplateData{Record: &Record{StoredSlug: slug}, Revisions: revs})
+}
+
+func revisionDiffHandler(w http.ResponseWriter, r *http.Request, slug string) {
+	q := r.URL.Query()
+	from, err := time.Parse(revisionLayout, q.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from revision", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse(revisionLayout, q.Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to revision", http.StatusBadRequest)
+		return
+	}
+
+	old, err := LoadRevision(slug, from)
+	if os.IsNotExist(err) {
+		http.Error(w, "no such revision", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cur, err := LoadRevision(slug, to)
+	if os.IsNotExist(err) {
+		http.Error(w, "no such revision", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	diff := diffLines(strings.Split(old.Content, "\n"), strings.Split(cur.Content, "\n"))
+	renderTemplate(w, r, "diff", &TemplateData{Record: cur, Diff: diff, Revisions: []time.Time{from, to}})
+}
+
+// diffHandler shows what changed between a saved revision and the record as
+// it is now.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	m := diffPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	slug := m[1]
+	if !historyReadable(r, slug) {
+		http.NotFound(w, r)
+		return
+	}
+	from, err := time.Parse(revisionLayout, m[2])
+	if err != nil {
+		http.Error(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	old, err := LoadRevision(slug, from)
+	if os.IsNotExist(err) {
+		http.Error(w, "no such revision", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cur, err := LoadRecord(slug)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "no such record", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	diff := diffLines(strings.Split(old.Content, "\n"), strings.Split(cur.Content, "\n"))
+	renderTemplate(w, r, "diff", &TemplateData{Record: cur, Diff: diff, Revisions: []time.Time{from, cur.UpdatedAt}})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRevisionDiff(t *testing.T) {
+	useTempRecords(t)
+
+	rec := &Record{Title: "Evolving", Content: "line one\nline two", Published: true}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	rec.Content = "line one\nline 2\nline three"
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// revisions must not show up as records
+	records, err := AllRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+
+	revs, err := Revisions("evolving")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(revs))
+	}
+	from, to := revs[0].Format(revisionLayout), revs[1].Format(revisionLayout)
+
+	tt := []struct {
+		name   string
+		target string
+		code   int
+		want   []string
+	}{
+		{"history", "/history/evolving", http.StatusOK, []string{"changes"}},
+		{"diff", "/history/evolving/diff?from=" + from + "&to=" + to, http.StatusOK, []string{
+			`<span class="diff-same"> line one</span>`,
+			`<span class="diff-remove">-line two</span>`,
+			`<span class="diff-add">+line 2</span>`,
+			`<span class="diff-add">+line three</span>`,
+		}},
+		{"bad from", "/history/evolving/diff?from=yesterday&to=" + to, http.StatusBadRequest, nil},
+		{"missing to", "/history/evolving/diff?from=" + from, http.StatusBadRequest, nil},
+		{"unknown revision", "/history/evolving/diff?from=20000101T000000.000000000Z&to=" + to, http.StatusNotFound, nil},
+		{"unknown record", "/history/nope", http.StatusNotFound, nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			historyHandler(w, httptest.NewRequest("GET", tc.target, nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Fatalf("expected body to contain %q, got:\n%s", want, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffAgainstCurrent(t *testing.T) {
+	useTempRecords(t)
+
+	rec := &Record{Title: "Drifting", Content: "alpha\nbeta", Published: true}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	revs, err := Revisions("drifting")
+	if err != nil || len(revs) != 1 {
+		t.Fatalf("expected 1 revision, got %v %v", revs, err)
+	}
+	first := revs[0].Format(revisionLayout)
+	rec.Content = "alpha\ngamma"
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name   string
+		target string
+		code   int
+		want   []string
+	}{
+		{"diff", "/diff/drifting/" + first, http.StatusOK, []string{
+			`<span class="diff-same"> alpha</span>`,
+			`<span class="diff-remove">-beta</span>`,
+			`<span class="diff-add">+gamma</span>`,
+		}},
+		{"unknown revision", "/diff/drifting/20000101T000000.000000000Z", http.StatusNotFound, nil},
+		{"unknown record", "/diff/nope/" + first, http.StatusNotFound, nil},
+		{"bad timestamp", "/diff/drifting/20001301T000000.000000000Z", http.StatusBadRequest, nil},
+		{"no timestamp", "/diff/drifting", http.StatusNotFound, nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			diffHandler(w, httptest.NewRequest("GET", tc.target, nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Fatalf("expected body to contain %q, got:\n%s", want, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestRevisionsRejectHostileSlugs(t *testing.T) {
+	useTempRecords(t)
+
+	for _, slug := range hostileSlugs {
+		t.Run(slug, func(t *testing.T) {
+			if _, err := Revisions(slug); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("Revisions: expected ErrInvalidSlug, got %v", err)
+			}
+			if _, err := LoadRevision(slug, time.Now()); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("LoadRevision: expected ErrInvalidSlug, got %v", err)
+			}
+			if err := saveRevision(slug, []byte("{}")); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("saveRevision: expected ErrInvalidSlug, got %v", err)
+			}
+			if err := moveRevisions("fine", slug); !errors.Is(err, ErrInvalidSlug) {
+				t.Errorf("moveRevisions: expected ErrInvalidSlug, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDeletedPostHistoryIsGone(t *testing.T) {
+	useTempRecords(t)
+	rec := &Record{Title: "Diary", Content: "very private", Published: true, Visibility: visibilityPrivate}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	deleteHandler(w, httptest.NewRequest("POST", "/delete/diary", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected the post to be deleted, got %d", w.Code)
+	}
+	if revs, err := Revisions("diary"); err != nil || len(revs) != 0 {
+		t.Fatalf("expected the history deleted with the post, got %v %v", revs, err)
+	}
+
+	// even history left behind isn't shown without its post
+	if err := saveRevision("diary", []byte(`{"title": "Diary", "content": "very private"}`)); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	historyHandler(w, httptest.NewRequest("GET", "/history/diary", nil))
+	if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "very private") {
+		t.Fatalf("expected 404 for the history of a deleted post, got %d", w.Code)
+	}
+}
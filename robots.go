@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	// robotsFile, when set, is served as /robots.txt; otherwise robotsText
+	// is, and without either defaultRobots
+	robotsFile = envOr("BLOG_ROBOTS_FILE", "")
+	robotsText = envOr("BLOG_ROBOTS_TXT", "")
+)
+
+// defaultRobots lets crawlers into everything but the pages for changing
+// the blog and the API.
+const defaultRobots = `User-agent: *
+Allow: /
+Disallow: /edit/
+Disallow: /new/
+Disallow: /delete/
+Disallow: /admin
+Disallow: /api/
+`
+
+var sitemapLine = regexp.MustCompile(`(?im)^\s*sitemap\s*:`)
+
+// robots is the robots.txt to serve. A custom one that doesn't point at a
+// sitemap gets the blog's added.
+func robots(base string) string {
+	text := defaultRobots
+	if robotsFile != "" {
+		data, err := ioutil.ReadFile(robotsFile)
+		if err != nil {
+			errorf("unable to read %s, serving the default robots.txt: %v", robotsFile, err)
+		} else {
+			text = string(data)
+		}
+	} else if robotsText != "" {
+		// a single line in the environment can use \n for line breaks
+		text = strings.ReplaceAll(robotsText, `\n`, "\n")
+	}
+	if !sitemapLine.MatchString(text) {
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		text += "\nSitemap: " + base + "/sitemap-index.xml\n"
+	}
+	return text
+}
+
+func robotsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(robots(baseURL(r))))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRobots(t *testing.T) {
+	oldFile, oldText := robotsFile, robotsText
+	t.Cleanup(func() { robotsFile, robotsText = oldFile, oldText })
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		robotsHandler(w, httptest.NewRequest("GET", "http://blog.example/robots.txt", nil))
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		return w.Body.String()
+	}
+
+	robotsFile, robotsText = "", ""
+	body := get()
+	for _, want := range []string{"Allow: /\n", "Disallow: /edit/\n", "Disallow: /delete/\n", "Disallow: /admin\n", "Disallow: /api/\n", "Sitemap: http://blog.example/sitemap-index.xml\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the default robots.txt:\n%s", want, body)
+		}
+	}
+
+	robotsText = `User-agent: *\nDisallow: /`
+	if body := get(); body != "User-agent: *\nDisallow: /\n\nSitemap: http://blog.example/sitemap-index.xml\n" {
+		t.Fatalf("unexpected robots.txt from the environment:\n%s", body)
+	}
+
+	robotsFile = filepath.Join(t.TempDir(), "robots.txt")
+	custom := "User-agent: *\nDisallow: /private/\nsitemap: https://elsewhere.example/map.xml\n"
+	if err := ioutil.WriteFile(robotsFile, []byte(custom), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(); body != custom {
+		t.Fatalf("expected the file as it is, got:\n%s", body)
+	}
+
+	robotsFile = filepath.Join(t.TempDir(), "missing.txt")
+	if body := get(); !strings.Contains(body, "Disallow: /admin\n") {
+		t.Fatalf("expected the default when the file is missing, got:\n%s", body)
+	}
+}
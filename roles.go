@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// The roles a user can have. Accounts from before roles existed have none
+// recorded and keep the full access they had, as admins.
+const (
+	roleAdmin  = "admin"
+	roleEditor = "editor"
+	roleViewer = "viewer"
+)
+
+func validRole(role string) bool {
+	return role == roleAdmin || role == roleEditor || role == roleViewer
+}
+
+// action is something a user may or may not be allowed to do.
+type action string
+
+const (
+	actionCreate action = "create"
+	actionEdit   action = "edit"
+	actionDelete action = "delete"
+	// actionManage covers the users and the site settings
+	actionManage action = "manage"
+)
+
+// roleOf is the role of the account username. The user from the flags or
+// environment and the GitHub users on the allowlist are admins; anyone else
+// has no role at all.
+func roleOf(username string) string {
+	if login := strings.TrimPrefix(username, githubUserPrefix); login != username {
+		if githubLoginEnabled() && githubAllowed(login) {
+			return roleAdmin
+		}
+		return ""
+	}
+	if u, ok := users.get(username); ok {
+		if u.Role == "" {
+			return roleAdmin
+		}
+		return u.Role
+	}
+	if username != "" && authUser != "" && authPass != "" && username == authUser {
+		return roleAdmin
+	}
+	return ""
+}
+
+// authorize reports whether user may take act on rec, which is nil for
+// actions not about one post. Admins may do anything, editors may create
+// posts and edit their own, and viewers, like everyone else, only read.
+func authorize(user string, act action, rec *Record) bool {
+	switch roleOf(user) {
+	case roleAdmin:
+		return true
+	case roleEditor:
+		switch act {
+		case actionCreate:
+			return true
+		case actionEdit:
+			return rec != nil && rec.Author != "" && rec.Author == user
+		}
+	}
+	return false
+}
+
+// apiKeyContextKey marks a request requireAPIKey let in with an API key.
+type apiKeyContextKey struct{}
+
+func withAPIKey(r *http.Request, k *APIKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k))
+}
+
+// requestUser is who made r: the user its API key acts for, or the logged
+// in user.
+func requestUser(r *http.Request) string {
+	if k, ok := r.Context().Value(apiKeyContextKey{}).(*APIKey); ok {
+		return k.actsFor()
+	}
+	return currentUser(r)
+}
+
+// allowed applies authorize to whoever made r, on the HTML and API routes
+// alike. Without any accounts the route's own guard is all there is, so
+// anyone it let in may go ahead.
+func allowed(r *http.Request, act action, rec *Record) bool {
+	if !authConfigured() {
+		return true
+	}
+	return authorize(requestUser(r), act, rec)
+}
+
+// requireManage guards the site's admin pages, which only admins may use.
+func requireManage(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(r, actionManage, nil) {
+			forbidden(w, r, "manage the site")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// forbidden renders the error page for an action r may not take.
+func forbidden(w http.ResponseWriter, r *http.Request, what string) {
+	renderTemplateStatus(w, r, http.StatusForbidden, "error", &TemplateData{Errors: []string{"You don't have permission to " + what + "."}})
+}
+
+// forbiddenJSON is forbidden for the API.
+func forbiddenJSON(w http.ResponseWriter, what string) {
+	writeJSONError(w, http.StatusForbidden, "not allowed to "+what)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useTestRoles gives the user store an account per role, each with the
+// password "pw".
+func useTestRoles(t *testing.T) {
+	t.Helper()
+	useTestUsers(t)
+	hash, err := hashPassword("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{roleAdmin, roleEditor, roleViewer} {
+		users.users[name] = &User{Username: name, PasswordHash: hash, Role: name}
+	}
+	users.users["other-editor"] = &User{Username: "other-editor", PasswordHash: hash, Role: roleEditor}
+}
+
+func TestAuthorize(t *testing.T) {
+	useTestRoles(t)
+	own := &Record{Title: "Mine", Author: "editor"}
+	theirs := &Record{Title: "Theirs", Author: "admin"}
+
+	tt := []struct {
+		user string
+		act  action
+		rec  *Record
+		want bool
+	}{
+		{"admin", actionDelete, own, true},
+		{"admin", actionManage, nil, true},
+		{"editor", actionCreate, nil, true},
+		{"editor", actionEdit, own, true},
+		{"editor", actionEdit, theirs, false},
+		{"editor", actionEdit, &Record{Title: "Nobody's"}, false},
+		{"editor", actionDelete, own, false},
+		{"editor", actionManage, nil, false},
+		{"viewer", actionCreate, nil, false},
+		{"viewer", actionEdit, own, false},
+		{"stranger", actionCreate, nil, false},
+		{"", actionEdit, own, false},
+	}
+	for _, tc := range tt {
+		if got := authorize(tc.user, tc.act, tc.rec); got != tc.want {
+			t.Errorf("authorize(%q, %s, %v) = %v, want %v", tc.user, tc.act, tc.rec, got, tc.want)
+		}
+	}
+
+	// accounts from before roles keep their access
+	users.users["old"] = &User{Username: "old"}
+	if !authorize("old", actionDelete, theirs) {
+		t.Error("expected an account without a role to be an admin")
+	}
+}
+
+func TestEditorCannotDeleteOthersPosts(t *testing.T) {
+	useTempRecords(t)
+	useTestRoles(t)
+	basicLogin(t, "editor", "pw")
+	basicLogin(t, "admin", "pw")
+	if err := (&Record{Title: "Theirs", Author: "other-editor"}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the HTML form
+	r := httptest.NewRequest("POST", "/delete/theirs", nil)
+	r.SetBasicAuth("editor", "pw")
+	w := httptest.NewRecorder()
+	deleteHandler(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "permission to delete this post") {
+		t.Fatalf("expected a 403 page, got %d: %s", w.Code, w.Body)
+	}
+
+	// the API takes keys, not an editor's password
+	r = httptest.NewRequest("POST", "/api/records/delete", strings.NewReader(`["theirs"]`))
+	r.SetBasicAuth("editor", "pw")
+	w = httptest.NewRecorder()
+	requireAPIKey(bulkDeleteHandler)(w, r)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"error"`) {
+		t.Fatalf("expected a JSON 401, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := LoadRecord("theirs"); err != nil {
+		t.Fatalf("expected the post to survive, got %v", err)
+	}
+
+	// an admin may
+	r = httptest.NewRequest("POST", "/delete/theirs", nil)
+	r.SetBasicAuth("admin", "pw")
+	w = httptest.NewRecorder()
+	deleteHandler(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected the admin's delete to succeed, got %d", w.Code)
+	}
+}
+
+func TestAPIKeysFollowRoles(t *testing.T) {
+	useTempRecords(t)
+	useTestRoles(t)
+	for _, rec := range []*Record{{Title: "Mine", Author: "editor"}, {Title: "Theirs", Author: "other-editor"}} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyFor := func(user string) string {
+		t.Helper()
+		key, _, err := createAPIKey(user, user+" script")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	editor, viewer, admin := keyFor("editor"), keyFor("viewer"), keyFor("admin")
+
+	call := func(h http.HandlerFunc, method, target, key, body string) int {
+		t.Helper()
+		r := keyRequest(method, target, key, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		requireAPIKey(h)(w, r)
+		return w.Code
+	}
+	tt := []struct {
+		name   string
+		h      http.HandlerFunc
+		method string
+		target string
+		key    string
+		body   string
+		code   int
+	}{
+		{"editor publishes own", apiRecordsHandler, "POST", "/api/records/mine/publish", editor, "", http.StatusOK},
+		{"editor publishes others", apiRecordsHandler, "POST", "/api/records/theirs/publish", editor, "", http.StatusForbidden},
+		{"viewer publishes", apiRecordsHandler, "POST", "/api/records/mine/unpublish", viewer, "", http.StatusForbidden},
+		{"viewer creates", createRecordHandler, "POST", "/api/records", viewer, `{"title": "Nope"}`, http.StatusForbidden},
+		{"editor creates", createRecordHandler, "POST", "/api/records", editor, `{"title": "Drafted"}`, http.StatusCreated},
+		{"admin publishes others", apiRecordsHandler, "POST", "/api/records/theirs/publish", admin, "", http.StatusOK},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := call(tc.h, tc.method, tc.target, tc.key, tc.body); code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, code)
+			}
+		})
+	}
+	if rec, err := LoadRecord("drafted"); err != nil || rec.Author != "editor" {
+		t.Fatalf("expected the editor's key to be recorded as the author, got %+v %v", rec, err)
+	}
+
+	// a key acts with its user's role as it is now
+	users.users["editor"].Role = roleViewer
+	if code := call(apiRecordsHandler, "POST", "/api/records/mine/unpublish", editor, ""); code != http.StatusForbidden {
+		t.Fatalf("expected the demoted editor's key to be refused, got %d", code)
+	}
+}
+
+func TestEditorEditsOwnPosts(t *testing.T) {
+	useTempRecords(t)
+	useTestRoles(t)
+	basicLogin(t, "editor", "pw")
+	for _, rec := range []*Record{{Title: "Mine", Author: "editor"}, {Title: "Theirs", Author: "admin"}} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for slug, code := range map[string]int{"mine": http.StatusOK, "theirs": http.StatusForbidden} {
+		r := httptest.NewRequest("GET", "/edit/"+slug, nil)
+		r.SetBasicAuth("editor", "pw")
+		w := httptest.NewRecorder()
+		editHandler(w, r)
+		if w.Code != code {
+			t.Errorf("editing %s: expected %d, got %d", slug, code, w.Code)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/new/", nil)
+	r.SetBasicAuth("viewer", "pw")
+	w := httptest.NewRecorder()
+	newHandler(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected a viewer to be refused the new post form, got %d", w.Code)
+	}
+}
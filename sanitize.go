@@ -0,0 +1,209 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// allowedTags maps each tag the sanitizer keeps to the attributes it may
+// keep on it. Everything else is dropped, though the text inside stays.
+var allowedTags = map[string][]string{
+	"a": {"href", "title"}, "img": {"src", "alt", "title"},
+	"p": nil, "br": nil, "hr": nil, "blockquote": nil, "pre": nil, "code": {"class"},
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"em": nil, "strong": nil, "b": nil, "i": nil, "u": nil, "s": nil, "del": nil, "sup": nil, "sub": nil,
+	"ul": nil, "ol": nil, "li": nil, "dl": nil, "dt": nil, "dd": nil,
+	"table": nil, "thead": nil, "tbody": nil, "tr": nil, "th": nil, "td": nil,
+}
+
+// droppedTags lose their content as well, since it's code rather than text.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"noscript": true, "template": true, "textarea": true, "title": true, "svg": true, "math": true,
+}
+
+var voidTags = map[string]bool{"br": true, "hr": true, "img": true}
+
+// urlAttrs hold URLs, which must use one of allowedSchemes or be relative.
+var urlAttrs = map[string]bool{"href": true, "src": true}
+
+var allowedSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// sanitizeHTML makes HTML from rendered post content safe to put on a page:
+// only tags and attributes in allowedTags survive, scripts, styles and event
+// handlers are removed, links only go to http(s) and mailto URLs and get
+// rel="nofollow noopener". Everything that renders Content as HTML, pages
+// and feeds alike, must pass it through here.
+func sanitizeHTML(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			b.WriteString(escapeText(s))
+			break
+		}
+		b.WriteString(escapeText(s[:i]))
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			s = skipPast(s, "-->")
+		case strings.HasPrefix(s, "<!") || strings.HasPrefix(s, "<?"):
+			// doctypes, CDATA and processing instructions
+			s = skipPast(s, ">")
+		case len(s) > 1 && (isLetter(s[1]) || s[1] == '/'):
+			var name, tag string
+			var closing bool
+			var attrs [][2]string
+			name, closing, attrs, s = parseTag(s)
+			if droppedTags[name] {
+				if !closing {
+					s = skipElement(s, name)
+				}
+				continue
+			}
+			allowed, ok := allowedTags[name]
+			if !ok {
+				continue
+			}
+			if closing {
+				if !voidTags[name] {
+					b.WriteString("</" + name + ">")
+				}
+				continue
+			}
+			tag = "<" + name
+			for _, a := range attrs {
+				if !contains(allowed, a[0]) {
+					continue
+				}
+				if urlAttrs[a[0]] && !safeURL(a[1]) {
+					continue
+				}
+				tag += " " + a[0] + `="` + html.EscapeString(a[1]) + `"`
+			}
+			if name == "a" {
+				tag += ` rel="nofollow noopener"`
+			}
+			b.WriteString(tag + ">")
+		default:
+			b.WriteString("&lt;")
+			s = s[1:]
+		}
+	}
+	return b.String()
+}
+
+// escapeText re-escapes text between tags. Entities are decoded first so
+// ones that were already there aren't escaped twice.
+func escapeText(s string) string {
+	return html.EscapeString(html.UnescapeString(s))
+}
+
+func skipPast(s, end string) string {
+	if i := strings.Index(s, end); i >= 0 {
+		return s[i+len(end):]
+	}
+	return ""
+}
+
+// skipElement drops everything up to and including name's closing tag.
+func skipElement(s, name string) string {
+	lower := strings.ToLower(s)
+	if i := strings.Index(lower, "</"+name); i >= 0 {
+		return skipPast(s[i:], ">")
+	}
+	return ""
+}
+
+// parseTag reads one tag from the start of s, returning its lower-cased
+// name, whether it is a closing tag, its attributes with values decoded,
+// and what follows it.
+func parseTag(s string) (name string, closing bool, attrs [][2]string, rest string) {
+	s = s[1:]
+	if strings.HasPrefix(s, "/") {
+		closing = true
+		s = s[1:]
+	}
+	n := 0
+	for n < len(s) && (isLetter(s[n]) || isDigit(s[n])) {
+		n++
+	}
+	name, s = strings.ToLower(s[:n]), s[n:]
+
+	for {
+		s = strings.TrimLeft(s, " \t\r\n\f/")
+		if s == "" {
+			return name, closing, attrs, ""
+		}
+		if s[0] == '>' {
+			return name, closing, attrs, s[1:]
+		}
+
+		n = 0
+		for n < len(s) && !strings.ContainsRune(" \t\r\n\f/>=", rune(s[n])) {
+			n++
+		}
+		if n == 0 {
+			// a stray "=", skip it
+			s = s[1:]
+			continue
+		}
+		key := strings.ToLower(s[:n])
+		s = strings.TrimLeft(s[n:], " \t\r\n\f")
+
+		var value string
+		if strings.HasPrefix(s, "=") {
+			s = strings.TrimLeft(s[1:], " \t\r\n\f")
+			if s != "" && (s[0] == '"' || s[0] == '\'') {
+				q := s[0]
+				end := strings.IndexByte(s[1:], q)
+				if end < 0 {
+					return name, closing, attrs, ""
+				}
+				value, s = s[1:end+1], s[end+2:]
+			} else {
+				n = 0
+				for n < len(s) && !strings.ContainsRune(" \t\r\n\f>", rune(s[n])) {
+					n++
+				}
+				value, s = s[:n], s[n:]
+			}
+		}
+		attrs = append(attrs, [2]string{key, html.UnescapeString(value)})
+	}
+}
+
+// safeURL reports whether u is relative or uses an allowed scheme. Browsers
+// ignore whitespace and control characters inside a scheme, so they are
+// ignored here too before looking for one.
+func safeURL(u string) bool {
+	clean := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, u)
+	colon := strings.IndexByte(clean, ':')
+	if colon < 0 {
+		return true
+	}
+	// a colon after the path starts is not a scheme
+	if i := strings.IndexAny(clean, "/?#"); i >= 0 && i < colon {
+		return true
+	}
+	return allowedSchemes[strings.ToLower(clean[:colon])]
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isLetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
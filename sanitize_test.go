@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tt := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "just words", "just words"},
+		{"allowed tags", "<p>Hi <em>there</em></p>", "<p>Hi <em>there</em></p>"},
+		{"entities kept", "<p>a &amp; b &lt; c</p>", "<p>a &amp; b &lt; c</p>"},
+		{"bare angle bracket", "1 < 2 > 0", "1 &lt; 2 &gt; 0"},
+		{"script dropped", "a<script>alert(1)</script>b", "ab"},
+		{"script upper case", "a<SCRIPT src=x></SCRIPT>b", "ab"},
+		{"unclosed script", "a<script>alert(1)", "a"},
+		{"style dropped", "<style>body{}</style><p>x</p>", "<p>x</p>"},
+		{"unknown tag unwrapped", "<blink>hi</blink>", "hi"},
+		{"event handler stripped", `<img src="/a.png" alt="A" onerror="alert(1)">`, `<img src="/a.png" alt="A">`},
+		{"unquoted event handler", `<p onclick=alert(1)>x</p>`, `<p>x</p>`},
+		{"link rel", `<a href="https://example.com">x</a>`, `<a href="https://example.com" rel="nofollow noopener">x</a>`},
+		{"link rel replaced", `<a href="/p" rel="me" target="_blank">x</a>`, `<a href="/p" rel="nofollow noopener">x</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"obfuscated javascript", `<a href=" jav&#x09;ascript:alert(1)">x</a>`, `<a rel="nofollow noopener">x</a>`},
+		{"data image", `<img src="data:image/svg+xml,<svg onload=alert(1)>">`, `<img>`},
+		{"mailto", `<a href="mailto:me@example.com">x</a>`, `<a href="mailto:me@example.com" rel="nofollow noopener">x</a>`},
+		{"colon in path", `<a href="/tags/a:b">x</a>`, `<a href="/tags/a:b" rel="nofollow noopener">x</a>`},
+		{"attribute quoting", `<img alt='say "hi"' src=/a.png>`, `<img alt="say &#34;hi&#34;" src="/a.png">`},
+		{"comment", "a<!-- <script>x</script> -->b", "ab"},
+		{"self closing", "a<br/>b<hr />", "a<br>b<hr>"},
+		{"iframe", `<iframe src="https://evil.example"></iframe>ok`, "ok"},
+		{"code class", `<pre><code class="language-go" style="x">x</code></pre>`, `<pre><code class="language-go">x</code></pre>`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sanitizeHTML(tc.in); got != tc.want {
+				t.Fatalf("\nexpected: %s\nactual: %s", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// fieldSchema describes what one key of a record file may hold.
+type fieldSchema struct {
+	kind     string // "string", "bool", "int", "[]string" or "map[string]string"
+	required bool
+	// dateTime strings must be RFC 3339 timestamps
+	dateTime bool
+}
+
+// recordSchema is the shape of a record file. Keys are matched without
+// regard to case, since records written before the JSON tags were added use
+// the Go field names.
+var recordSchema = map[string]fieldSchema{
+	"title":           {kind: "string", required: true},
+	"content":         {kind: "string"},
+	"published":       {kind: "bool"},
+	"created_at":      {kind: "string", dateTime: true},
+	"updated_at":      {kind: "string", dateTime: true},
+	"tags":            {kind: "[]string"},
+	"pinned":          {kind: "bool"},
+	"featured":        {kind: "bool"},
+	"canonical_url":   {kind: "string"},
+	"author":          {kind: "string"},
+	"series":          {kind: "string"},
+	"series_part":     {kind: "int"},
+	"slug":            {kind: "string"},
+	"meta":            {kind: "map[string]string"},
+	"is_template":     {kind: "bool"},
+	"access_password": {kind: "string"},
+	"visibility":      {kind: "string"},
+}
+
+// ValidationError reports a record file that doesn't match recordSchema.
+type ValidationError struct {
+	File    string
+	Field   string
+	Problem string
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Problem
+	if e.Field != "" {
+		msg = fmt.Sprintf("field %q: %s", e.Field, e.Problem)
+	}
+	if e.File != "" {
+		msg = e.File + ": " + msg
+	}
+	return "invalid record: " + msg
+}
+
+// validateRecordJSON checks a record file against recordSchema before it is
+// unmarshaled, so a wrongly typed field is an error instead of a zero value.
+func validateRecordJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return &ValidationError{Problem: "not a JSON object: " + err.Error()}
+	}
+
+	seen := make(map[string]bool)
+	for key, raw := range fields {
+		name := strings.ToLower(key)
+		schema, ok := recordSchema[name]
+		if !ok {
+			// unknown keys are left alone so newer files still load
+			continue
+		}
+		seen[name] = true
+		if err := checkField(schema, raw); err != "" {
+			return &ValidationError{Field: name, Problem: err}
+		}
+	}
+
+	for name, schema := range recordSchema {
+		if schema.required && !seen[name] {
+			return &ValidationError{Field: name, Problem: "is required"}
+		}
+	}
+	return nil
+}
+
+// parseRecordFile validates and decodes a record file. Every failure is a
+// *ValidationError, so the caller only has to fill in the file name.
+func parseRecordFile(data []byte) (*Record, error) {
+	if err := validateRecordJSON(data); err != nil {
+		return nil, err
+	}
+	r, err := decodeRecord(data)
+	if err != nil {
+		return nil, &ValidationError{Problem: err.Error()}
+	}
+	if err := checkRecord(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkRecord covers the rules recordSchema can't express, once the record
+// has been unmarshaled.
+func checkRecord(r *Record) error {
+	if strings.TrimSpace(r.Title) == "" {
+		return &ValidationError{Field: "title", Problem: "must not be empty"}
+	}
+	if !r.CreatedAt.IsZero() && !r.UpdatedAt.IsZero() && r.UpdatedAt.Before(r.CreatedAt) {
+		return &ValidationError{Field: "updated_at", Problem: "is before created_at"}
+	}
+	for i, tag := range r.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return &ValidationError{Field: "tags", Problem: fmt.Sprintf("item %d: must not be empty", i)}
+		}
+	}
+	if r.Visibility != "" && !validVisibility(r.Visibility) {
+		return &ValidationError{Field: "visibility", Problem: fmt.Sprintf("must be public, unlisted or private, not %q", r.Visibility)}
+	}
+	return nil
+}
+
+// checkField describes what is wrong with raw, or returns "" if it fits.
+func checkField(schema fieldSchema, raw json.RawMessage) string {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err.Error()
+	}
+
+	switch schema.kind {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return "expected a string, got " + jsonType(v)
+		}
+		if schema.dateTime {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return "expected an RFC 3339 timestamp"
+			}
+		}
+	case "int":
+		n, ok := v.(float64)
+		if !ok {
+			return "expected a number, got " + jsonType(v)
+		}
+		if n != math.Trunc(n) {
+			return "expected a whole number"
+		}
+	case "bool":
+		if _, ok := v.(bool); !ok {
+			return "expected a boolean, got " + jsonType(v)
+		}
+	case "[]string":
+		if v == nil {
+			return ""
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return "expected an array of strings, got " + jsonType(v)
+		}
+		for i, item := range items {
+			if _, ok := item.(string); !ok {
+				return fmt.Sprintf("item %d: expected a string, got %s", i, jsonType(item))
+			}
+		}
+	case "map[string]string":
+		if v == nil {
+			return ""
+		}
+		fields, ok := v.(map[string]interface{})
+		if !ok {
+			return "expected an object of strings, got " + jsonType(v)
+		}
+		for k, item := range fields {
+			if _, ok := item.(string); !ok {
+				return fmt.Sprintf("key %q: expected a string, got %s", k, jsonType(item))
+			}
+		}
+	}
+	return ""
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateRecordJSON(t *testing.T) {
+	tt := []struct {
+		name  string
+		data  string
+		valid bool
+		field string
+	}{
+		{"valid", `{"title":"x","content":"y","published":true,"created_at":"2020-01-01T00:00:00Z","tags":["a"]}`, true, ""},
+		{"legacy keys", `{"Title":"x","Content":"y"}`, true, ""},
+		{"unknown keys", `{"title":"x","colour":"blue"}`, true, ""},
+		{"null tags", `{"title":"x","tags":null}`, true, ""},
+		{"not an object", `["title"]`, false, ""},
+		{"truncated", `{"title":"x"`, false, ""},
+		{"missing title", `{"content":"y"}`, false, "title"},
+		{"numeric title", `{"title":42}`, false, "title"},
+		{"string published", `{"title":"x","published":"yes"}`, false, "published"},
+		{"bad timestamp", `{"title":"x","created_at":"yesterday"}`, false, "created_at"},
+		{"tags not an array", `{"title":"x","tags":"go"}`, false, "tags"},
+		{"numeric tag", `{"title":"x","tags":["go",1]}`, false, "tags"},
+		{"meta", `{"title":"x","meta":{"a":"b"}}`, true, ""},
+		{"meta not an object", `{"title":"x","meta":["a"]}`, false, "meta"},
+		{"numeric meta value", `{"title":"x","meta":{"a":1}}`, false, "meta"},
+		{"series part", `{"title":"x","series":"s","series_part":2}`, true, ""},
+		{"fractional series part", `{"title":"x","series_part":1.5}`, false, "series_part"},
+		{"string series part", `{"title":"x","series_part":"2"}`, false, "series_part"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRecordJSON([]byte(tc.data))
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if ve.Field != tc.field {
+				t.Fatalf("expected field %q, got %q (%v)", tc.field, ve.Field, err)
+			}
+		})
+	}
+}
+
+func TestLoadRecordValidates(t *testing.T) {
+	dir := useTempRecords(t)
+	filename := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(filename, []byte(`{"title":["not","a","string"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadRecord("bad")
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if ve.File != filename || ve.Field != "title" {
+		t.Fatalf("unexpected error details: %+v", ve)
+	}
+}
+
+func TestParseRecordFile(t *testing.T) {
+	tt := []struct {
+		name  string
+		data  string
+		valid bool
+		field string
+	}{
+		{"valid", `{"title":"x","created_at":"2020-01-01T00:00:00Z","updated_at":"2020-01-02T00:00:00Z","tags":["a"]}`, true, ""},
+		{"blank title", `{"title":"  "}`, false, "title"},
+		{"updated before created", `{"title":"x","created_at":"2020-01-02T00:00:00Z","updated_at":"2020-01-01T00:00:00Z"}`, false, "updated_at"},
+		{"empty tag", `{"title":"x","tags":["go",""]}`, false, "tags"},
+		{"truncated", `{"title":"x"`, false, ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRecordFile([]byte(tc.data))
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if ve.Field != tc.field {
+				t.Fatalf("expected field %q, got %q (%v)", tc.field, ve.Field, err)
+			}
+		})
+	}
+}
+
+func TestAllRecordsSkipsInvalid(t *testing.T) {
+	dir := useTempRecords(t)
+	files := map[string]string{
+		"good.json":  `{"title":"Good"}`,
+		"blank.json": `{"title":""}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, problems, err := LoadAllRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Title != "Good" {
+		t.Fatalf("expected only the good record, got %v", titles(records))
+	}
+	var ve *ValidationError
+	if len(problems) != 1 || !errors.As(problems[0].Err, &ve) || ve.File != filepath.Join(dir, "blank.json") {
+		t.Fatalf("expected blank.json to be reported by name, got %+v", problems)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const defaultFuzzyDistance = 2
+
+// snippetLen caps the length of a search result's snippet, in characters.
+const snippetLen = 200
+
+// SearchResult is a record that matched a search, with a snippet of its
+// text around the first hit.
+type SearchResult struct {
+	*Record
+	// Snippet is escaped text with the query's words wrapped in <mark>
+	Snippet template.HTML
+}
+
+// searchTerms splits a query into the lower-cased words it searches for.
+func searchTerms(query string) [][]rune {
+	seen := make(map[string]bool)
+	terms := make([][]rune, 0)
+	for _, w := range strings.Fields(query) {
+		w = string(lowerRunes([]rune(w)))
+		if !seen[w] {
+			seen[w] = true
+			terms = append(terms, []rune(w))
+		}
+	}
+	return terms
+}
+
+// lowerRunes lower-cases rune by rune, unlike strings.ToLower, so offsets
+// into the result are offsets into rs.
+func lowerRunes(rs []rune) []rune {
+	low := make([]rune, len(rs))
+	for i, r := range rs {
+		low[i] = unicode.ToLower(r)
+	}
+	return low
+}
+
+// SearchRecords finds published records whose title or text contains every
+// word of the query, ignoring case.
+func SearchRecords(query string) ([]SearchResult, error) {
+	records, err := AllRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := searchTerms(query)
+	results := make([]SearchResult, 0)
+	if len(terms) == 0 {
+		return results, nil
+	}
+	for _, r := range records {
+		if !r.Published || !r.Listed() || r.Protected() {
+			continue
+		}
+		text := []rune(plainText(r.Content))
+		title, low := lowerRunes([]rune(r.Title)), lowerRunes(text)
+		matched := true
+		for _, t := range terms {
+			if indexRunes(title, t, 0) < 0 && indexRunes(low, t, 0) < 0 {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			results = append(results, SearchResult{Record: r, Snippet: snippet(text, low, terms)})
+		}
+	}
+	return results, nil
+}
+
+// indexRunes is the index of the first sub in s at or after from, or -1.
+func indexRunes(s, sub []rune, from int) int {
+	for i := from; i+len(sub) <= len(s); i++ {
+		if hasPrefixRunes(s[i:], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasPrefixRunes(s, prefix []rune) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i := range prefix {
+		if s[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// snippet cuts up to snippetLen characters of text around the first hit,
+// at word boundaries, and marks every hit in it. low is text lower-cased by
+// lowerRunes. All of the text is escaped, so only the marks are markup.
+func snippet(text, low []rune, terms [][]rune) template.HTML {
+	first := -1
+	for _, t := range terms {
+		if i := indexRunes(low, t, 0); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+
+	// start a little before the first hit so it has some context
+	start := 0
+	if first > snippetLen/4 {
+		start = first - snippetLen/4
+		for start < first && text[start-1] != ' ' {
+			start++
+		}
+	}
+	end := len(text)
+	if end-start > snippetLen {
+		end = start + snippetLen
+		for end > start && text[end] != ' ' {
+			end--
+		}
+		if end == start {
+			end = start + snippetLen
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	plain := start
+	for i := start; i < end; {
+		n := 0
+		for _, t := range terms {
+			if len(t) > n && i+len(t) <= end && hasPrefixRunes(low[i:], t) {
+				n = len(t)
+			}
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(string(text[plain:i])))
+		b.WriteString("<mark>" + template.HTMLEscapeString(string(text[i:i+n])) + "</mark>")
+		i += n
+		plain = i
+	}
+	b.WriteString(template.HTMLEscapeString(string(text[plain:end])))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return template.HTML(b.String())
+}
+
+// FuzzySearch finds published records whose title is within maxDistance
+// edits of the query, closest first, so small typos still match.
+func FuzzySearch(query string, maxDistance int) ([]*Record, error) {
+	records, err := AllRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(query)
+	distances := make(map[*Record]int)
+	results := make([]*Record, 0)
+	for _, r := range records {
+		if !r.Published || !r.Listed() || r.Protected() {
+			continue
+		}
+		d := levenshtein(query, strings.ToLower(r.Title))
+		if d <= maxDistance {
+			distances[r] = d
+			results = append(results, r)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return distances[results[i]] < distances[results[j]]
+	})
+	return results, nil
+}
+
+// levenshtein counts the single rune insertions, deletions and substitutions
+// needed to turn a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		renderTemplate(w, r, "search", &TemplateData{})
+		return
+	}
+
+	var results []SearchResult
+	var err error
+	if r.URL.Query().Get("fuzzy") == "1" {
+		// fuzzy matches are on the title, so there is nothing to highlight
+		var records []*Record
+		records, err = FuzzySearch(q, defaultFuzzyDistance)
+		for _, rec := range records {
+			results = append(results, SearchResult{Record: rec})
+		}
+	} else {
+		results, err = SearchRecords(q)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, r, "search", &TemplateData{Results: results, Query: q})
+}
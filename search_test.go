@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tt := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"go", "", 2},
+		{"", "go", 2},
+		{"kitten", "sitting", 3},
+		{"golang", "golnag", 2},
+		{"café", "cafe", 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.a+"/"+tc.b, func(t *testing.T) {
+			if got := levenshtein(tc.a, tc.b); got != tc.want {
+				t.Fatalf("expected %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func saveSearchFixtures(t *testing.T) {
+	t.Helper()
+	useTempRecords(t)
+	for _, rec := range []*Record{
+		{Title: "Golang", Content: "all about go", Published: true},
+		{Title: "Goland", Content: "an editor", Published: true},
+		{Title: "Python", Content: "snakes, mentions golang", Published: true},
+		{Title: "Golang Draft", Content: "unfinished", Published: false},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func titles(records []*Record) string {
+	ts := make([]string, 0, len(records))
+	for _, r := range records {
+		ts = append(ts, r.Title)
+	}
+	return strings.Join(ts, ",")
+}
+
+func TestFuzzySearch(t *testing.T) {
+	saveSearchFixtures(t)
+
+	tt := []struct {
+		query    string
+		distance int
+		want     string
+	}{
+		{"golang", 0, "Golang"},
+		{"GOLANG", 0, "Golang"},
+		{"golnag", 2, "Golang"},
+		{"golang", 2, "Golang,Goland"},
+		{"goland", 1, "Goland,Golang"},
+		{"rust", 2, ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.query, func(t *testing.T) {
+			results, err := FuzzySearch(tc.query, tc.distance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := titles(results); got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	saveSearchFixtures(t)
+
+	tt := []struct {
+		target string
+		want   []string
+		absent []string
+	}{
+		{"/search?q=golang", []string{"Golang", "Python"}, []string{"Goland", "Golang Draft"}},
+		{"/search?q=golnag&fuzzy=1", []string{"Golang"}, []string{"Goland", "Python"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			searchHandler(w, httptest.NewRequest("GET", tc.target, nil))
+			body := w.Body.String()
+			for _, want := range tc.want {
+				if !strings.Contains(body, ">"+want+"<") {
+					t.Fatalf("expected %q in results:\n%s", want, body)
+				}
+			}
+			for _, absent := range tc.absent {
+				if strings.Contains(body, ">"+absent+"<") {
+					t.Fatalf("did not expect %q in results:\n%s", absent, body)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchRecordsSnippets(t *testing.T) {
+	useTempRecords(t)
+	long := strings.Repeat("filler words here ", 30)
+	for _, rec := range []*Record{
+		{Title: "Tags", Content: "Use <b>bold</b> & **Go** generics, go on", Published: true},
+		{Title: "Deep", Content: long + "the needle is here " + long, Published: true},
+		{Title: "Both words", Content: "only one of them: needle", Published: true},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snippets := func(query string) map[string]string {
+		t.Helper()
+		results, err := SearchRecords(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]string)
+		for _, r := range results {
+			m[r.Title] = string(r.Snippet)
+		}
+		return m
+	}
+
+	got := snippets("GO")
+	want := "Use &lt;b&gt;bold&lt;/b&gt; &amp; <mark>Go</mark> generics, <mark>go</mark> on"
+	if got["Tags"] != want {
+		t.Fatalf("expected escaped text with marks:\n%s\ngot:\n%s", want, got["Tags"])
+	}
+
+	deep := snippets("needle")["Deep"]
+	if !strings.HasPrefix(deep, "…") || !strings.HasSuffix(deep, "…") || !strings.Contains(deep, "the <mark>needle</mark> is") {
+		t.Fatalf("expected a cut snippet around the hit, got %q", deep)
+	}
+	if n := len([]rune(strings.NewReplacer("<mark>", "", "</mark>", "", "…", "").Replace(deep))); n > snippetLen {
+		t.Fatalf("expected at most %d characters, got %d", snippetLen, n)
+	}
+
+	// every word must match, in the title or the text
+	if got := snippets("needle them"); len(got) != 1 || got["Both words"] == "" {
+		t.Fatalf("expected only the post with both words, got %v", got)
+	}
+	if got := snippets("   "); len(got) != 0 {
+		t.Fatalf("expected nothing for an empty query, got %v", got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sameSeries compares series names the way people type them, ignoring case
+// and surrounding space.
+func sameSeries(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// SeriesURL is the page listing the record's series.
+func (r *Record) SeriesURL() string {
+	return "/series/" + url.PathEscape(strings.TrimSpace(r.Series))
+}
+
+// AllSeriesParts returns every record in the named series, drafts
+// included, ordered by SeriesPart and then by creation time.
+func AllSeriesParts(seriesName string) ([]*Record, error) {
+	records, err := AllRecords()
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]*Record, 0)
+	for _, r := range records {
+		if r.Series != "" && sameSeries(r.Series, seriesName) {
+			parts = append(parts, r)
+		}
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		if parts[i].SeriesPart != parts[j].SeriesPart {
+			return parts[i].SeriesPart < parts[j].SeriesPart
+		}
+		return parts[i].CreatedAt.Before(parts[j].CreatedAt)
+	})
+	return parts, nil
+}
+
+// seriesNav returns the parts of rec's series the reader of r may see, the
+// published ones they're allowed to know about and rec itself, and the
+// parts either side of rec.
+func seriesNav(r *http.Request, rec *Record) (parts []*Record, prev, next *Record, err error) {
+	all, err := AllSeriesParts(rec.Series)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, p := range all {
+		if p.Published && visibleTo(r, p) || p.Slug() == rec.Slug() {
+			parts = append(parts, p)
+		}
+	}
+	for i, p := range parts {
+		if p.Slug() != rec.Slug() {
+			continue
+		}
+		if i > 0 {
+			prev = parts[i-1]
+		}
+		if i < len(parts)-1 {
+			next = parts[i+1]
+		}
+	}
+	return parts, prev, next, nil
+}
+
+// parseSeriesForm reads the series fields of a post form into rec. A part
+// number only means something within a series, so it is cleared without
+// one.
+func parseSeriesForm(r *http.Request, rec *Record) []string {
+	rec.Series = strings.TrimSpace(r.FormValue("series"))
+	rec.SeriesPart = 0
+	if rec.Series == "" {
+		return nil
+	}
+	raw := strings.TrimSpace(r.FormValue("series_part"))
+	if raw == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return []string{fmt.Sprintf("Part %q must be a whole number from 1 up", raw)}
+	}
+	rec.SeriesPart = n
+	return nil
+}
+
+// seriesHandler serves /series/{name}, the published parts of a series in
+// order. Like the index it leaves out unlisted and private parts.
+func seriesHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/series/"))
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	all, err := AllSeriesParts(name)
+	if err != nil {
+		errorf("unable to load series %q: %v", name, err)
+		http.Error(w, "unable to load the series", http.StatusInternalServerError)
+		return
+	}
+	parts := make([]*Record, 0, len(all))
+	for _, p := range all {
+		if p.Published && p.Listed() {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		http.Error(w, "no such series", http.StatusNotFound)
+		return
+	}
+	// show the name as the author wrote it rather than as it was typed
+	renderTemplate(w, r, "series", &TemplateData{SeriesName: parts[0].Series, SeriesParts: parts})
+}
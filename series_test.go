@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func saveSeriesFixtures(t *testing.T) {
+	t.Helper()
+	dir := useTempRecords(t)
+	start := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, rec := range []*Record{
+		{Title: "Part two", Series: "Go Basics", SeriesPart: 2, Published: true},
+		{Title: "Part one", Series: "go basics ", SeriesPart: 1, Published: true},
+		{Title: "Part three", Series: "Go Basics", SeriesPart: 3, Published: false},
+		{Title: "Part four", Series: "Go Basics", SeriesPart: 4, Published: true},
+		{Title: "Elsewhere", Series: "Cooking", SeriesPart: 1, Published: true},
+		{Title: "Standalone", Published: true},
+	} {
+		writeRecordAt(t, dir, rec, start.Add(time.Duration(i)*time.Hour))
+	}
+}
+
+func TestAllSeriesParts(t *testing.T) {
+	saveSeriesFixtures(t)
+	parts, err := AllSeriesParts("GO BASICS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(parts); got != "Part one,Part two,Part three,Part four" {
+		t.Fatalf("unexpected parts %s", got)
+	}
+	if parts, _ := AllSeriesParts("nope"); len(parts) != 0 {
+		t.Fatalf("expected no parts, got %s", titles(parts))
+	}
+}
+
+func TestShowSeriesNav(t *testing.T) {
+	saveSeriesFixtures(t)
+
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/part-two", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`<a href="/series/Go%20Basics">Go Basics</a>`,
+		`<strong>Part two</strong>`,
+		`<a rel="prev" href="/show/part-one">&larr; previous part</a>`,
+		// the draft third part is skipped
+		`<a rel="next" href="/show/part-four">next part &rarr;</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "part-three") {
+		t.Errorf("the draft part should not be linked:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/standalone", nil))
+	if strings.Contains(w.Body.String(), `class="series"`) {
+		t.Error("a post outside any series should have no series box")
+	}
+}
+
+func TestSeriesHandler(t *testing.T) {
+	saveSeriesFixtures(t)
+
+	tt := []struct {
+		target string
+		code   int
+		want   string
+	}{
+		{"/series/go%20basics", http.StatusOK, "/show/part-one"},
+		{"/series/Cooking", http.StatusOK, "/show/elsewhere"},
+		{"/series/nope", http.StatusNotFound, ""},
+		{"/series/", http.StatusNotFound, ""},
+	}
+	for _, tc := range tt {
+		w := httptest.NewRecorder()
+		seriesHandler(w, httptest.NewRequest("GET", tc.target, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d", tc.target, tc.code, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Fatalf("%s: expected %s in:\n%s", tc.target, tc.want, w.Body)
+		}
+	}
+}
+
+func TestSeriesHidesRestrictedParts(t *testing.T) {
+	saveSeriesFixtures(t)
+	useTestAuth(t)
+	basicLogin(t, "admin", "secret")
+	for _, rec := range []*Record{
+		{Title: "Part five", Series: "Go Basics", SeriesPart: 5, Published: true, Visibility: visibilityPrivate},
+		{Title: "Part six", Series: "Go Basics", SeriesPart: 6, Published: true, Visibility: visibilityUnlisted},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/part-four", nil))
+	if body := w.Body.String(); strings.Contains(body, "part-five") || !strings.Contains(body, "/show/part-six") {
+		t.Errorf("anonymous readers should see the unlisted part but not the private one:\n%s", body)
+	}
+	w = httptest.NewRecorder()
+	showHandler(w, authRequest("GET", "/show/part-four"))
+	if !strings.Contains(w.Body.String(), `<a rel="next" href="/show/part-five">`) {
+		t.Errorf("logged in users should see the private part:\n%s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	seriesHandler(w, authRequest("GET", "/series/go%20basics"))
+	if body := w.Body.String(); strings.Contains(body, "part-five") || strings.Contains(body, "part-six") {
+		t.Errorf("the series index should only list public parts:\n%s", body)
+	}
+}
+
+func TestParseSeriesForm(t *testing.T) {
+	tt := []struct {
+		series, part string
+		wantSeries   string
+		wantPart     int
+		ok           bool
+	}{
+		{" Go Basics ", "2", "Go Basics", 2, true},
+		{"Go Basics", "", "Go Basics", 0, true},
+		{"", "3", "", 0, true},
+		{"Go Basics", "0", "Go Basics", 0, false},
+		{"Go Basics", "two", "Go Basics", 0, false},
+	}
+	for _, tc := range tt {
+		form := url.Values{"series": {tc.series}, "series_part": {tc.part}}
+		r := formRequest("/create/", form, nil)
+		rec := &Record{}
+		errs := parseSeriesForm(r, rec)
+		if (len(errs) == 0) != tc.ok || rec.Series != tc.wantSeries || rec.SeriesPart != tc.wantPart {
+			t.Errorf("%q/%q: got %q/%d %v", tc.series, tc.part, rec.Series, rec.SeriesPart, errs)
+		}
+	}
+}
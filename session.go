@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sessionCookie = "session"
+
+// sessionTTL is how long a login lasts, in minutes.
+var sessionTTL = time.Duration(envInt("BLOG_SESSION_TTL", 12*60)) * time.Minute
+
+// sessionStore keeps the logins made through /login. Sessions live in memory,
+// so a restart logs everyone out; the cookie only carries the signed ID.
+type sessionStore struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	sessions map[string]*session
+	now      func() time.Time
+}
+
+type session struct {
+	user    string
+	expires time.Time
+	// csrf is the token the session's forms must send back
+	csrf string
+}
+
+func newSessionStore(ttl time.Duration) *sessionStore {
+	return &sessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]*session),
+		now:      time.Now,
+	}
+}
+
+var sessions = newSessionStore(sessionTTL)
+
+// create starts a session for user and returns its ID. Expired sessions are
+// dropped while the lock is held anyway.
+func (s *sessionStore) create(user string) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for k, sess := range s.sessions {
+		if !now.Before(sess.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[id] = &session{user: user, expires: now.Add(s.ttl), csrf: csrf}
+	return id, nil
+}
+
+// get returns the live session id names, if there is one.
+func (s *sessionStore) get(id string) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return session{}, false
+	}
+	if !s.now().Before(sess.expires) {
+		delete(s.sessions, id)
+		return session{}, false
+	}
+	return *sess, true
+}
+
+// user returns who id belongs to, if it is a live session.
+func (s *sessionStore) user(id string) (string, bool) {
+	sess, ok := s.get(id)
+	return sess.user, ok
+}
+
+func (s *sessionStore) destroy(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sessionID returns the verified session ID from r's cookie, or "".
+func sessionID(r *http.Request) string {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	id, err := verifyValue(c.Value)
+	if err != nil {
+		debugf("discarding session cookie: %v", err)
+		return ""
+	}
+	return id
+}
+
+// loggedIn reports whether r carries a live session.
+func loggedIn(r *http.Request) bool {
+	id := sessionID(r)
+	if id == "" {
+		return false
+	}
+	_, ok := sessions.user(id)
+	return ok
+}
+
+func setSessionCookie(w http.ResponseWriter, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    signValue(id),
+		Path:     "/",
+		MaxAge:   int(sessions.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   tlsDomain != "",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   tlsDomain != "",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// safeNext returns next if it is a path on this site, and "/" otherwise, so
+// the login form can't be used to send people elsewhere.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Host != "" || u.Scheme != "" {
+		return "/"
+	}
+	return next
+}
+
+// loginURL is where requireLogin sends r to sign in first.
+func loginURL(r *http.Request) string {
+	return "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+}
+
+// startSession logs user in, however they proved who they are.
+func startSession(w http.ResponseWriter, r *http.Request, user string) error {
+	// a new ID on every login, so one planted before it is useless
+	if old := sessionID(r); old != "" {
+		sessions.destroy(old)
+	}
+	id, err := sessions.create(user)
+	if err != nil {
+		return err
+	}
+	setSessionCookie(w, id)
+	return nil
+}
+
+// loginHandler shows the login form and, on POST, starts a session and sends
+// the user back to where they were going.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		renderTemplate(w, r, "login", &TemplateData{ReturnTo: safeNext(r.URL.Query().Get("next")), GitHubLogin: githubLoginEnabled()})
+	case http.MethodPost:
+		next := safeNext(r.PostFormValue("next"))
+		keys := loginKeys(r.PostFormValue("username"), clientIP(r))
+		if wait, locked := logins.locked(keys...); locked {
+			mins := int(math.Ceil(wait.Minutes()))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			renderTemplateStatus(w, r, http.StatusTooManyRequests, "login", &TemplateData{
+				ReturnTo:    next,
+				GitHubLogin: githubLoginEnabled(),
+				Errors:      []string{fmt.Sprintf("Too many failed logins. Try again in %d minutes.", mins)},
+			})
+			return
+		}
+		user, ok := authenticate(r.PostFormValue("username"), r.PostFormValue("password"))
+		if !ok {
+			logins.fail(keys...)
+			renderTemplateStatus(w, r, http.StatusUnauthorized, "login", &TemplateData{
+				ReturnTo:    next,
+				GitHubLogin: githubLoginEnabled(),
+				Errors:      []string{"Invalid credentials"},
+			})
+			return
+		}
+		logins.reset(keys...)
+
+		if err := startSession(w, r, user); err != nil {
+			errorf("unable to start session: %v", err)
+			http.Error(w, "unable to log in", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// logoutHandler ends the session. It only accepts POST so a link or a
+// prefetch can't log anyone out.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if id := sessionID(r); id != "" {
+		sessions.destroy(id)
+	}
+	clearSessionCookie(w)
+	setFlash(w, flashInfo, "Logged out")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
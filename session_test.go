@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestSessions gives the test its own session store with a clock it
+// controls.
+func useTestSessions(t *testing.T) *time.Time {
+	t.Helper()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	old := sessions
+	sessions = newSessionStore(time.Hour)
+	sessions.now = func() time.Time { return now }
+	t.Cleanup(func() { sessions = old })
+	return &now
+}
+
+func login(t *testing.T, next string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	form := url.Values{"username": {"admin"}, "password": {"secret"}, "next": {next}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	loginHandler(w, r)
+	return w
+}
+
+func sessionCookieFrom(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie set: %v", w.Header())
+	return nil
+}
+
+func TestLoginRedirectsBack(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+	h := requireWriteAuth(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/edit/hello?x=1", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect, got %d", w.Code)
+	}
+	loc, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || loc.Path != "/login" {
+		t.Fatalf("expected a redirect to /login, got %q", w.Header().Get("Location"))
+	}
+	next := loc.Query().Get("next")
+
+	w = login(t, next)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/edit/hello?x=1" {
+		t.Fatalf("expected to be sent back to /edit/hello?x=1, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	c := sessionCookieFrom(t, w)
+	if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("session cookie should be HttpOnly and SameSite=Lax: %+v", c)
+	}
+
+	r := httptest.NewRequest("GET", "/edit/hello?x=1", nil)
+	r.AddCookie(c)
+	w = httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the session to be let in, got %d", w.Code)
+	}
+}
+
+func TestLoginRejectsBadCredentials(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+
+	form := url.Values{"username": {"admin"}, "password": {"nope"}, "next": {"/new/"}}
+	r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	loginHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			t.Fatal("a failed login must not set a session cookie")
+		}
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	tt := map[string]string{
+		"/edit/hello":         "/edit/hello",
+		"/search?q=go":        "/search?q=go",
+		"":                    "/",
+		"https://example.com": "/",
+		"//example.com/x":     "/",
+		"/\\example.com":      "/",
+		"edit/hello":          "/",
+	}
+	for next, want := range tt {
+		if got := safeNext(next); got != want {
+			t.Errorf("safeNext(%q) = %q, expected %q", next, got, want)
+		}
+	}
+}
+
+func TestSessionCookies(t *testing.T) {
+	useTestAuth(t)
+	now := useTestSessions(t)
+
+	expired := sessionCookieFrom(t, login(t, "/"))
+	*now = now.Add(2 * time.Hour)
+	valid := sessionCookieFrom(t, login(t, "/"))
+
+	tampered := *valid
+	tampered.Value = valid.Value[:len(valid.Value)-2] + "xx"
+
+	forged := &http.Cookie{Name: sessionCookie, Value: signValue("not-a-session")}
+
+	tt := []struct {
+		name   string
+		cookie *http.Cookie
+		ok     bool
+	}{
+		{"valid", valid, true},
+		{"expired", expired, false},
+		{"tampered", &tampered, false},
+		{"unknown id", forged, false},
+		{"garbage", &http.Cookie{Name: sessionCookie, Value: "garbage"}, false},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(tc.cookie)
+			if got := loggedIn(r); got != tc.ok {
+				t.Fatalf("expected loggedIn %v, got %v", tc.ok, got)
+			}
+		})
+	}
+}
+
+func TestLoginRotatesSession(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+
+	first := sessionCookieFrom(t, login(t, "/"))
+	second := sessionCookieFrom(t, login(t, "/", first))
+	if first.Value == second.Value {
+		t.Fatal("expected a new session ID on login")
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(first)
+	if loggedIn(r) {
+		t.Fatal("the old session should be gone after logging in again")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	useTestAuth(t)
+	useTestSessions(t)
+	c := sessionCookieFrom(t, login(t, "/"))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/logout", nil)
+	r.AddCookie(c)
+	logoutHandler(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET to be refused, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/logout", nil)
+	r.AddCookie(c)
+	logoutHandler(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected a redirect, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(c)
+	if loggedIn(r) {
+		t.Fatal("the session should not survive logging out, even with its cookie")
+	}
+}
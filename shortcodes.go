@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// shortcodesFile, when set, is a JSON object of shortcode names and the HTML
+// each expands to, added to defaultShortcodes and replacing any of the same
+// name
+var shortcodesFile = envOr("BLOG_SHORTCODES_FILE", "")
+
+// defaultShortcodes are available without any configuration.
+var defaultShortcodes = map[string]string{
+	"signup": `<p><strong>Enjoyed this?</strong> <a href="/subscribe">Subscribe</a> to get new posts by email.</p>`,
+}
+
+// shortcodes maps each name usable as {{name}} in post content to its HTML.
+var shortcodes = defaultShortcodes
+
+var (
+	shortcodeName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	// a shortcode alone in a paragraph replaces the paragraph, so fragments
+	// with their own blocks don't end up nested in a <p>
+	shortcodeRef = regexp.MustCompile(`<p>\{\{\s*([a-z0-9_-]+)\s*\}\}</p>|\{\{\s*([a-z0-9_-]+)\s*\}\}`)
+)
+
+// loadShortcodes reads path into shortcodes. A missing file is fine.
+func loadShortcodes(path string) error {
+	codes := make(map[string]string, len(defaultShortcodes))
+	for name, frag := range defaultShortcodes {
+		codes[name] = frag
+	}
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			var custom map[string]string
+			if err := json.Unmarshal(data, &custom); err != nil {
+				return err
+			}
+			for name, frag := range custom {
+				if !shortcodeName.MatchString(name) {
+					return fmt.Errorf("bad shortcode name %q: use lower-case letters, digits, - and _", name)
+				}
+				codes[name] = frag
+			}
+		}
+	}
+	shortcodes = codes
+	return nil
+}
+
+// expandShortcodes replaces the {{name}} shortcodes in HTML from
+// renderMarkdown with their fragments. Code is left alone, and so are
+// unknown names, which stay as they were written. The result still has to
+// go through sanitizeHTML, so fragments can't add anything a post couldn't.
+func expandShortcodes(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		// renderMarkdown escapes the source, so any <code> is its own
+		i := strings.Index(s, "<code")
+		if i < 0 {
+			b.WriteString(expandRefs(s))
+			return b.String()
+		}
+		b.WriteString(expandRefs(s[:i]))
+		end := strings.Index(s[i:], "</code>")
+		if end < 0 {
+			b.WriteString(s[i:])
+			return b.String()
+		}
+		end += i + len("</code>")
+		b.WriteString(s[i:end])
+		s = s[end:]
+	}
+}
+
+func expandRefs(s string) string {
+	return replaceShortcodes(s, func(frag string) string { return frag })
+}
+
+// stripShortcodes removes the known shortcodes from s, for plain text
+// where their HTML has no place.
+func stripShortcodes(s string) string {
+	return replaceShortcodes(s, func(string) string { return "" })
+}
+
+// replaceShortcodes replaces each known shortcode in s with what repl makes
+// of its fragment.
+func replaceShortcodes(s string, repl func(frag string) string) string {
+	return shortcodeRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := shortcodeRef.FindStringSubmatch(ref)
+		frag, ok := shortcodes[m[1]+m[2]]
+		if !ok {
+			return ref
+		}
+		return repl(frag)
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func useTestShortcodes(t *testing.T, codes map[string]string) {
+	t.Helper()
+	old := shortcodes
+	shortcodes = codes
+	t.Cleanup(func() { shortcodes = old })
+}
+
+func TestExpandShortcodes(t *testing.T) {
+	useTestShortcodes(t, map[string]string{
+		"signup": `<p><a href="/subscribe">Subscribe</a></p>`,
+		"bad":    `<script>alert(1)</script><a href="javascript:alert(1)" onclick="x">click</a>`,
+	})
+
+	tt := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"own paragraph", "Hi\n\n{{signup}}", "<p>Hi</p>\n<p><a href=\"/subscribe\" rel=\"nofollow noopener\">Subscribe</a></p>\n"},
+		{"spaces", "{{ signup }}", "<p><a href=\"/subscribe\" rel=\"nofollow noopener\">Subscribe</a></p>\n"},
+		{"unknown", "{{nope}} stays", "<p>{{nope}} stays</p>\n"},
+		{"code span", "type `{{signup}}`", "<p>type <code>{{signup}}</code></p>\n"},
+		{"code block", "```\n{{signup}}\n```", "<pre><code>{{signup}}</code></pre>\n"},
+		{"sanitized", "{{bad}}", "<a rel=\"nofollow noopener\">click</a>\n"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := string(renderContent(tc.src)); got != tc.want {
+				t.Fatalf("\nexpected: %q\nactual: %q", tc.want, got)
+			}
+		})
+	}
+
+	if got := excerpt("Read on.\n\n{{signup}}", 10); got != "Read on." {
+		t.Fatalf("expected shortcodes left out of excerpts, got %q", got)
+	}
+}
+
+func TestLoadShortcodes(t *testing.T) {
+	useTestShortcodes(t, defaultShortcodes)
+	dir := t.TempDir()
+
+	if err := loadShortcodes(filepath.Join(dir, "missing.json")); err != nil {
+		t.Fatalf("expected a missing file to be fine: %v", err)
+	}
+	if shortcodes["signup"] == "" {
+		t.Fatal("expected the default shortcodes")
+	}
+
+	path := filepath.Join(dir, "shortcodes.json")
+	if err := ioutil.WriteFile(path, []byte(`{"signup": "<p>custom</p>", "ad": "<p>ad</p>"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadShortcodes(path); err != nil {
+		t.Fatal(err)
+	}
+	if shortcodes["signup"] != "<p>custom</p>" || shortcodes["ad"] != "<p>ad</p>" {
+		t.Fatalf("unexpected shortcodes %v", shortcodes)
+	}
+	if defaultShortcodes["signup"] == "<p>custom</p>" {
+		t.Fatal("expected the defaults to be left alone")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"Bad Name": "x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadShortcodes(path); err == nil {
+		t.Fatal("expected an error for a bad name")
+	}
+}
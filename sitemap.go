@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const sitemapTTL = 10 * time.Minute
+
+// maxCachedSitemaps bounds the sitemap cache. Without BLOG_BASE_URL the key
+// includes the Host header, which clients choose freely.
+const maxCachedSitemaps = 100
+
+// siteURL is the blog's public address, used where links must be absolute.
+// When unset it is taken from the request.
+var siteURL = strings.TrimSuffix(envOr("BLOG_BASE_URL", ""), "/")
+
+var monthlySitemapPath = regexp.MustCompile(`^/sitemap/(\d{4}-\d{2})\.xml$`)
+
+const sitemapMonthLayout = "2006-01"
+
+func baseURL(r *http.Request) string {
+	if siteURL != "" {
+		return siteURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name       `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapEntry `xml:"url"`
+}
+
+type cachedSitemap struct {
+	data    []byte
+	expires time.Time
+}
+
+// sitemapCache keeps rendered sitemaps for sitemapTTL, so crawlers don't
+// cause a full read of the records directory on every fetch. Writes to the
+// records reset it.
+type sitemapCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedSitemap
+}
+
+var sitemaps = &sitemapCache{entries: make(map[string]cachedSitemap)}
+
+// get returns the cached sitemap for key, building it if there is none or
+// it expired. A nil result from build means there is no such sitemap.
+func (c *sitemapCache) get(key string, build func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.data, nil
+	}
+
+	data, err := build()
+	if err != nil || data == nil {
+		// misses aren't cached, or any month a crawler made up would be
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCachedSitemaps {
+		c.evict(now)
+	}
+	c.entries[key] = cachedSitemap{data: data, expires: now.Add(sitemapTTL)}
+	return data, nil
+}
+
+// evict makes room for another entry: it drops the expired ones, or failing
+// that the one closest to expiring. c.mu must be held.
+func (c *sitemapCache) evict(now time.Time) {
+	var oldest string
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		} else if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(c.entries) >= maxCachedSitemaps {
+		delete(c.entries, oldest)
+	}
+}
+
+func (c *sitemapCache) reset() {
+	c.mu.Lock()
+	c.entries = make(map[string]cachedSitemap)
+	c.mu.Unlock()
+}
+
+// recordsByMonth groups the published records by the month they were
+// created in.
+func recordsByMonth() (map[string][]*Record, error) {
+	records, err := AllRecords()
+	if err != nil {
+		return nil, err
+	}
+	months := make(map[string][]*Record)
+	for _, r := range records {
+		if !r.Published || !r.Listed() || r.Protected() {
+			continue
+		}
+		m := r.CreatedAt.UTC().Format(sitemapMonthLayout)
+		months[m] = append(months[m], r)
+	}
+	return months, nil
+}
+
+func latestUpdate(records []*Record) time.Time {
+	var t time.Time
+	for _, r := range records {
+		if r.UpdatedAt.After(t) {
+			t = r.UpdatedAt
+		}
+	}
+	return t
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeXML(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write(data)
+}
+
+// sitemapIndexHandler lists one sitemap per month that has posts, keeping
+// each well under the 50,000 URL limit of a single sitemap.
+func sitemapIndexHandler(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	data, err := sitemaps.get(base+"/sitemap-index.xml", func() ([]byte, error) {
+		months, err := recordsByMonth()
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(months))
+		for m := range months {
+			names = append(names, m)
+		}
+		sort.Strings(names)
+
+		idx := sitemapIndex{Sitemaps: make([]sitemapEntry, 0, len(names))}
+		for _, m := range names {
+			idx.Sitemaps = append(idx.Sitemaps, sitemapEntry{
+				Loc:     base + "/sitemap/" + m + ".xml",
+				LastMod: latestUpdate(months[m]).UTC().Format(time.RFC3339),
+			})
+		}
+		return marshalXML(idx)
+	})
+	if err != nil {
+		errorf("unable to build sitemap index: %v", err)
+		http.Error(w, "unable to build sitemap", http.StatusInternalServerError)
+		return
+	}
+	writeXML(w, data)
+}
+
+// monthlySitemapHandler serves /sitemap/{year}-{month}.xml.
+func monthlySitemapHandler(w http.ResponseWriter, r *http.Request) {
+	m := monthlySitemapPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := time.Parse(sitemapMonthLayout, m[1]); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	base := baseURL(r)
+	data, err := sitemaps.get(base+r.URL.Path, func() ([]byte, error) {
+		months, err := recordsByMonth()
+		if err != nil {
+			return nil, err
+		}
+		records := months[m[1]]
+		if len(records) == 0 {
+			return nil, nil
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+
+		set := urlSet{URLs: make([]sitemapEntry, 0, len(records))}
+		for _, rec := range records {
+			set.URLs = append(set.URLs, sitemapEntry{
+				Loc:     base + "/show/" + rec.Slug(),
+				LastMod: rec.UpdatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		return marshalXML(set)
+	})
+	if err != nil {
+		errorf("unable to build sitemap %s: %v", m[1], err)
+		http.Error(w, "unable to build sitemap", http.StatusInternalServerError)
+		return
+	}
+	if data == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeXML(w, data)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRecordAt stores a record with a fixed creation time, which Save
+// would otherwise set to now.
+func writeRecordAt(t *testing.T, dir string, r *Record, created time.Time) {
+	t.Helper()
+	r.CreatedAt, r.UpdatedAt = created, created
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, r.Slug()+".json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSitemaps(t *testing.T) {
+	dir := useTempRecords(t)
+	sitemaps.reset()
+	t.Cleanup(sitemaps.reset)
+
+	jan := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)
+	writeRecordAt(t, dir, &Record{Title: "January One", Published: true}, jan)
+	writeRecordAt(t, dir, &Record{Title: "January Two", Published: true}, jan.Add(time.Hour))
+	writeRecordAt(t, dir, &Record{Title: "February", Published: true}, feb)
+	writeRecordAt(t, dir, &Record{Title: "Draft", Published: false}, feb)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", target, nil)
+		if strings.HasPrefix(target, "/sitemap/") {
+			monthlySitemapHandler(w, r)
+		} else {
+			sitemapIndexHandler(w, r)
+		}
+		return w
+	}
+
+	w := get("/sitemap-index.xml")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	for _, want := range []string{
+		"<loc>http://example.com/sitemap/2024-01.xml</loc>",
+		"<loc>http://example.com/sitemap/2024-02.xml</loc>",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("expected the index to contain %q, got:\n%s", want, w.Body)
+		}
+	}
+
+	w = get("/sitemap/2024-01.xml")
+	body := w.Body.String()
+	if !strings.Contains(body, "/show/january-one</loc>") || !strings.Contains(body, "/show/january-two</loc>") || strings.Contains(body, "february") {
+		t.Fatalf("unexpected January sitemap:\n%s", body)
+	}
+	if w = get("/sitemap/2024-02.xml"); strings.Contains(w.Body.String(), "draft") {
+		t.Fatalf("drafts must not be listed:\n%s", w.Body)
+	}
+
+	for _, target := range []string{"/sitemap/2023-12.xml", "/sitemap/2024-13.xml", "/sitemap/latest.xml"} {
+		if w := get(target); w.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404, got %d", target, w.Code)
+		}
+	}
+
+	// a new post shows up once the cached copy is gone
+	writeRecordAt(t, dir, &Record{Title: "March", Published: true}, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	if w := get("/sitemap-index.xml"); strings.Contains(w.Body.String(), "2024-03") {
+		t.Fatal("expected the cached index to be served")
+	}
+	sitemaps.reset()
+	if w := get("/sitemap-index.xml"); !strings.Contains(w.Body.String(), "2024-03") {
+		t.Fatalf("expected March after the cache was cleared, got:\n%s", w.Body)
+	}
+}
+
+func TestSitemapCacheIsBounded(t *testing.T) {
+	dir := useTempRecords(t)
+	sitemaps.reset()
+	t.Cleanup(sitemaps.reset)
+	writeRecordAt(t, dir, &Record{Title: "Only", Published: true}, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+
+	for i := 0; i < maxCachedSitemaps+20; i++ {
+		r := httptest.NewRequest("GET", "/sitemap-index.xml", nil)
+		r.Host = fmt.Sprintf("host%d.example", i)
+		sitemapIndexHandler(httptest.NewRecorder(), r)
+	}
+	sitemaps.mu.Lock()
+	n := len(sitemaps.entries)
+	sitemaps.mu.Unlock()
+	if n > maxCachedSitemaps {
+		t.Fatalf("expected at most %d cached sitemaps, got %d", maxCachedSitemaps, n)
+	}
+}
+
+func TestSitemapFollowsWrites(t *testing.T) {
+	dir := useTempRecords(t)
+	sitemaps.reset()
+	t.Cleanup(sitemaps.reset)
+	writeRecordAt(t, dir, &Record{Title: "Old", Published: true}, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC))
+
+	index := func() string {
+		w := httptest.NewRecorder()
+		sitemapIndexHandler(w, httptest.NewRequest("GET", "/sitemap-index.xml", nil))
+		return w.Body.String()
+	}
+	month := "/sitemap/" + time.Now().Format(sitemapMonthLayout) + ".xml"
+	if strings.Contains(index(), month) {
+		t.Fatalf("did not expect %s before anything was posted this month", month)
+	}
+	if err := CreateRecord(&Record{Title: "Fresh", Published: true}, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(index(), month) {
+		t.Fatalf("expected the new post's month %s in the index", month)
+	}
+	if err := DeleteRecord("fresh"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(index(), month) {
+		t.Fatalf("expected %s to go with the deleted post", month)
+	}
+}
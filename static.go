@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// staticMaxAge is how many seconds browsers may cache static assets
+// without asking again; 0 makes them revalidate every time.
+var staticMaxAge = envInt("BLOG_STATIC_MAX_AGE", 3600)
+
+// fingerprinted matches names like style.3f2a9c1b.css, whose content never
+// changes under the same name, so they can be cached for good.
+var fingerprinted = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-zA-Z0-9]+$`)
+
+const fingerprintMaxAge = 365 * 24 * 60 * 60
+
+type etagKey struct {
+	source string
+	name   string
+	mod    time.Time
+	size   int64
+}
+
+// sourcedFS is a file system that picks where each file comes from, like
+// themeFS; source names that place so two copies of a file that share a
+// name, size and modtime don't share an ETag.
+type sourcedFS interface {
+	openSource(name string) (f http.File, source string, err error)
+}
+
+// staticETags caches the hash of each asset version, so files are only read
+// to hash them the first time they are served.
+var staticETags sync.Map
+
+// staticHandler serves fsys with caching headers. http.FileServer already
+// answers If-None-Match and If-Modified-Since once ETag is set.
+func staticHandler(fsys http.FileSystem) http.Handler {
+	files := http.FileServer(fsys)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag, err := fileETag(fsys, r.URL.Path); err == nil {
+			w.Header().Set("ETag", etag)
+			switch {
+			case fingerprinted.MatchString(r.URL.Path):
+				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", fingerprintMaxAge))
+			case staticMaxAge > 0:
+				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", staticMaxAge))
+			default:
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+func fileETag(fsys http.FileSystem, name string) (string, error) {
+	name = path.Clean("/" + name)
+	var (
+		f      http.File
+		source string
+		err    error
+	)
+	if s, ok := fsys.(sourcedFS); ok {
+		f, source, err = s.openSource(name)
+	} else {
+		f, err = fsys.Open(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("%s is a directory", name)
+	}
+
+	key := etagKey{source, name, fi.ModTime(), fi.Size()}
+	if etag, ok := staticETags.Load(key); ok {
+		return etag.(string), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	staticETags.Store(key, etag)
+	return etag, nil
+}
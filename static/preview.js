@@ -0,0 +1,21 @@
+// Renders the post being edited below the form as it is typed.
+(function () {
+	var content = document.querySelector('textarea[name="content"]');
+	var target = document.getElementById("live-preview");
+	if (!content || !target) {
+		return;
+	}
+	var timer;
+	function update() {
+		var body = new URLSearchParams();
+		body.set("content", content.value);
+		fetch("/preview", { method: "POST", body: body })
+			.then(function (r) { return r.ok ? r.text() : ""; })
+			.then(function (html) { target.innerHTML = html; });
+	}
+	content.addEventListener("input", function () {
+		clearTimeout(timer);
+		timer = setTimeout(update, 300);
+	});
+	update();
+})();
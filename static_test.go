@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticHandlerCaching(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"style.css", "app.0123abcd.js"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("/* "+name+" */"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := http.StripPrefix("/static/", staticHandler(http.Dir(dir)))
+
+	tt := []struct {
+		path  string
+		code  int
+		cache string
+	}{
+		{"/static/style.css", http.StatusOK, "public, max-age=3600"},
+		{"/static/app.0123abcd.js", http.StatusOK, "public, max-age=31536000, immutable"},
+		{"/static/missing.css", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tc.cache {
+				t.Fatalf("expected Cache-Control %q, got %q", tc.cache, got)
+			}
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			etag := w.Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("expected an ETag")
+			}
+			r := httptest.NewRequest("GET", tc.path, nil)
+			r.Header.Set("If-None-Match", etag)
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusNotModified {
+				t.Fatalf("expected 304 for a matching ETag, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestStaticHandlerEmbedded(t *testing.T) {
+	useTempConfig(t)
+	w := httptest.NewRecorder()
+	http.StripPrefix("/static/", staticHandler(themeFS{})).ServeHTTP(w, httptest.NewRequest("GET", "/static/style.css", nil))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == "" {
+		t.Fatalf("expected the embedded stylesheet with an ETag, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestStaticETagFollowsTheme(t *testing.T) {
+	useTempConfig(t)
+	useTempThemes(t)
+
+	// a copy of dark whose stylesheet has the same name, size and modtime
+	mod := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	light := filepath.Join(themesDir, "light", "static")
+	if err := os.MkdirAll(light, 0700); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path, content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(themesDir, "dark", "static", "style.css"), "/* dark! */")
+	write(filepath.Join(light, "style.css"), "/* light */")
+
+	h := http.StripPrefix("/static/", staticHandler(themeFS{}))
+	etag := func() string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/static/style.css", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		return w.Header().Get("ETag")
+	}
+
+	if err := setTheme("dark"); err != nil {
+		t.Fatal(err)
+	}
+	dark := etag()
+	if err := setTheme("light"); err != nil {
+		t.Fatal(err)
+	}
+	if etag() == dark {
+		t.Fatal("expected another theme's stylesheet to get its own ETag")
+	}
+
+	// an edit that keeps the size and modtime shows up after a reload
+	before := etag()
+	write(filepath.Join(light, "style.css"), "/* LIGHT */")
+	if err := setTheme("light"); err != nil {
+		t.Fatal(err)
+	}
+	if etag() == before {
+		t.Fatal("expected a theme reload to recompute ETags")
+	}
+}
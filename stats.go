@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SiteStats are the totals /admin/stats shows.
+type SiteStats struct {
+	Records    int       `json:"records"`
+	Published  int       `json:"published"`
+	Drafts     int       `json:"drafts"`
+	Tags       int       `json:"tags"`
+	Comments   int       `json:"comments"`
+	Pending    int       `json:"pending_comments"`
+	Views      int       `json:"views"`
+	DiskBytes  int64     `json:"disk_bytes"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// DiskSize is DiskBytes for people.
+func (s *SiteStats) DiskSize() string {
+	return formatBytes(s.DiskBytes)
+}
+
+func computeSiteStats(now time.Time) (*SiteStats, error) {
+	records, err := AllRecords()
+	if err != nil {
+		return nil, err
+	}
+	s := &SiteStats{Records: len(records), Tags: len(CountTags(records)), ComputedAt: now}
+	for _, r := range records {
+		if r.Published {
+			s.Published++
+		} else {
+			s.Drafts++
+		}
+		comments, err := LoadComments(r.Slug())
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			if c.Approved() {
+				s.Comments++
+			} else if c.Status == commentPending {
+				s.Pending++
+			}
+		}
+	}
+	for _, n := range views.snapshot() {
+		s.Views += n
+	}
+	if s.DiskBytes, err = dirSize(recordsDir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// statsCache keeps the last SiteStats for ttl, since computing them reads
+// every record.
+type statsCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	stats *SiteStats
+	now   func() time.Time
+}
+
+var siteStats = &statsCache{ttl: 30 * time.Second, now: time.Now}
+
+func (c *statsCache) get() (*SiteStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.stats != nil && now.Sub(c.stats.ComputedAt) < c.ttl {
+		return c.stats, nil
+	}
+	s, err := computeSiteStats(now)
+	if err != nil {
+		return nil, err
+	}
+	c.stats = s
+	return s, nil
+}
+
+// prefersJSON reports whether r's Accept header asks for JSON before HTML.
+// Quality values are ignored; clients list what they want first.
+func prefersJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
+}
+
+// statsHandler serves /admin/stats, as JSON to clients that ask for it and
+// as a page otherwise.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := siteStats.get()
+	if err != nil {
+		errorf("unable to compute stats: %v", err)
+		if prefersJSON(r) {
+			writeJSONError(w, http.StatusInternalServerError, "unable to compute stats")
+		} else {
+			http.Error(w, "unable to compute stats", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	if prefersJSON(r) {
+		writeJSON(w, http.StatusOK, s)
+		return
+	}
+	renderTemplate(w, r, "stats", &TemplateData{SiteStats: s})
+}
+
+// apiStatsHandler serves GET /api/stats, the same totals for monitoring.
+// They come from the same cache, so polling it every minute is cheap.
+func apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s, err := siteStats.get()
+	if err != nil {
+		errorf("unable to compute stats: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "unable to compute stats")
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// wordsPerMinute is the reading speed reading times assume.
+const wordsPerMinute = 200
+
+// RecordStats are one record's numbers, for GET /api/records/{slug}/stats.
+// The blog has no likes, so unlike the site's stats there is no count of
+// them.
+type RecordStats struct {
+	TotalViews         int `json:"total_views"`
+	ViewsLast7Days     int `json:"views_last_7_days"`
+	ViewsLast30Days    int `json:"views_last_30_days"`
+	CommentsCount      int `json:"comments_count"`
+	WordCount          int `json:"word_count"`
+	ReadingTimeMinutes int `json:"reading_time_minutes"`
+}
+
+// computeRecordStats works out rec's stats at now, counting only the
+// comments that are shown.
+func computeRecordStats(rec *Record, now time.Time) (*RecordStats, error) {
+	slug := rec.Slug()
+	comments, err := LoadComments(slug)
+	if err != nil {
+		return nil, err
+	}
+	s := &RecordStats{
+		TotalViews:      views.total(slug),
+		ViewsLast7Days:  views.lastDays(slug, 7, now),
+		ViewsLast30Days: views.lastDays(slug, viewDays, now),
+		CommentsCount:   len(approvedComments(comments)),
+		WordCount:       len(strings.Fields(plainText(rec.Content))),
+	}
+	// any words at all take a minute
+	s.ReadingTimeMinutes = (s.WordCount + wordsPerMinute - 1) / wordsPerMinute
+	return s, nil
+}
+
+// recordStatsHandler serves GET /api/records/{slug}/stats.
+func recordStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	rec, err := LoadRecord(getAPISlug(r))
+	if errors.Is(err, ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, "record not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s, err := computeRecordStats(rec, time.Now())
+	if err != nil {
+		errorf("unable to compute stats for %s: %v", rec.Slug(), err)
+		writeJSONError(w, http.StatusInternalServerError, "unable to compute stats")
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
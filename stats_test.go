@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsHandler(t *testing.T) {
+	useTempRecords(t)
+	oldViews, oldFile, oldStats := views, viewsFile, siteStats
+	views, viewsFile = &viewCounter{counts: make(map[string]int)}, filepath.Join(t.TempDir(), "views.json")
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	siteStats = &statsCache{ttl: 30 * time.Second, now: func() time.Time { return now }}
+	t.Cleanup(func() { views, viewsFile, siteStats = oldViews, oldFile, oldStats })
+
+	for _, rec := range []*Record{
+		{Title: "One", Published: true, Tags: []string{"go", "Web"}},
+		{Title: "Two", Published: true, Tags: []string{"web"}},
+		{Title: "Draft", Tags: []string{"later"}},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	views.add("one")
+	views.add("one")
+	views.add("two")
+	for i, slug := range []string{"one", "one", "two", "two"} {
+		status := commentApproved
+		if i == 3 {
+			status = commentPending
+		}
+		if err := addComment(slug, &Comment{Author: "A", Body: "hi", Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/admin/stats", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		statsHandler(w, r)
+		if w.Code != 200 {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		return w
+	}
+
+	var s SiteStats
+	if err := json.Unmarshal(get("application/json").Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Records != 3 || s.Published != 2 || s.Drafts != 1 || s.Tags != 3 || s.Comments != 3 || s.Pending != 1 || s.Views != 3 || s.DiskBytes == 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	body := get("text/html,application/xhtml+xml,application/json;q=0.9,*/*;q=0.8").Body.String()
+	if !strings.Contains(body, "<th>published</th><td>2</td>") {
+		t.Fatalf("expected the stats page, got %s", body)
+	}
+
+	// within the TTL the cached stats are served
+	if err := (&Record{Title: "Three", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(get("application/json").Body.Bytes(), &s)
+	if s.Records != 3 {
+		t.Fatalf("expected cached stats, got %+v", s)
+	}
+	now = now.Add(31 * time.Second)
+	json.Unmarshal(get("application/json").Body.Bytes(), &s)
+	if s.Records != 4 {
+		t.Fatalf("expected fresh stats after the TTL, got %+v", s)
+	}
+}
+
+func TestAPIStats(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	oldStats := siteStats
+	siteStats = &statsCache{ttl: 30 * time.Second, now: time.Now}
+	t.Cleanup(func() { siteStats = oldStats })
+
+	for _, rec := range []*Record{{Title: "Live", Published: true}, {Title: "Draft"}} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := useTestAPIKey(t)
+	h := requireAPIKey(apiStatsHandler)
+
+	w := httptest.NewRecorder()
+	h(w, keyRequest("GET", "/api/stats", key, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var s SiteStats
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Records != 2 || s.Drafts != 1 || s.DiskBytes == 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "/api/stats", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h(w, keyRequest("POST", "/api/stats", key, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", w.Code)
+	}
+}
+
+func TestRecordStats(t *testing.T) {
+	useTempRecords(t)
+	useTestAuth(t)
+	oldViews := views
+	views = &viewCounter{counts: make(map[string]int)}
+	t.Cleanup(func() { views = oldViews })
+
+	rec := &Record{Title: "Long read", Content: strings.Repeat("word ", 450), Published: true}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, ago := range []int{0, 3, 10, 40} {
+		views.addAt("long-read", now.AddDate(0, 0, -ago))
+	}
+	key := useTestAPIKey(t)
+	if err := writeComments("long-read", []*Comment{
+		{ID: "a", Author: "Ann", Body: "hi", Status: commentApproved},
+		{ID: "b", Author: "Bob", Body: "hm", Status: commentPending},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	apiRecordsHandler(w, keyRequest("GET", "/api/records/long-read/stats", key, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var s RecordStats
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	want := RecordStats{TotalViews: 4, ViewsLast7Days: 2, ViewsLast30Days: 3, CommentsCount: 1, WordCount: 450, ReadingTimeMinutes: 3}
+	if s != want {
+		t.Fatalf("expected %+v, got %+v", want, s)
+	}
+
+	w = httptest.NewRecorder()
+	apiRecordsHandler(w, keyRequest("GET", "/api/records/nope/stats", key, nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing record, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	apiRecordsHandler(w, httptest.NewRequest("GET", "/api/records/long-read/stats", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", w.Code)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const maxSuggestions = 10
+
+// Suggestion is one entry in the search box's autocomplete list.
+type Suggestion struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+}
+
+// titleIndex holds the titles of the published records for suggestions, so
+// typing in the search box doesn't read every record file on each key, and
+// their tag counts for the tag cloud. Any change to a record marks it stale
+// and the next lookup rebuilds it.
+type titleIndex struct {
+	mu      sync.Mutex
+	dir     string
+	stale   bool
+	entries []titleEntry
+	// tags counts the listed posts carrying each tag, normalized
+	tags map[string]int
+}
+
+type titleEntry struct {
+	Suggestion
+	lower string
+}
+
+var titleIdx = &titleIndex{stale: true}
+
+// invalidate is called whenever a record is created, saved or deleted.
+func (ix *titleIndex) invalidate() {
+	ix.mu.Lock()
+	ix.stale = true
+	ix.mu.Unlock()
+}
+
+// lookup returns up to limit published records whose title contains query,
+// ignoring case, in title order.
+func (ix *titleIndex) lookup(query string, limit int) ([]Suggestion, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := ix.refresh(); err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(query)
+	results := make([]Suggestion, 0)
+	for _, e := range ix.entries {
+		if len(results) == limit {
+			break
+		}
+		if strings.Contains(e.lower, query) {
+			results = append(results, e.Suggestion)
+		}
+	}
+	return results, nil
+}
+
+// tagCounts returns how many listed posts carry each tag, as CountTags
+// would, without reading every record when nothing changed.
+func (ix *titleIndex) tagCounts() (map[string]int, error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := ix.refresh(); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(ix.tags))
+	for tag, n := range ix.tags {
+		counts[tag] = n
+	}
+	return counts, nil
+}
+
+// refresh rebuilds the index if it is stale; ix.mu must be held.
+func (ix *titleIndex) refresh() error {
+	if ix.stale || ix.dir != recordsDir {
+		return ix.rebuild()
+	}
+	return nil
+}
+
+func (ix *titleIndex) rebuild() error {
+	records, err := AllRecords()
+	if err != nil {
+		return err
+	}
+	entries := make([]titleEntry, 0, len(records))
+	listed := make([]*Record, 0, len(records))
+	for _, r := range records {
+		if !r.Published || !r.Listed() {
+			continue
+		}
+		// protected posts show in the tag cloud, like on their tag's page,
+		// but their titles aren't suggested
+		listed = append(listed, r)
+		if r.Protected() {
+			continue
+		}
+		entries = append(entries, titleEntry{
+			Suggestion: Suggestion{Slug: r.Slug(), Title: r.Title},
+			lower:      strings.ToLower(r.Title),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].lower < entries[j].lower })
+
+	ix.entries, ix.tags, ix.dir, ix.stale = entries, CountTags(listed), recordsDir, false
+	return nil
+}
+
+// suggestionsHandler serves GET /search/suggestions?q=..., the titles the
+// search box offers as the user types.
+func suggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeJSON(w, http.StatusOK, []Suggestion{})
+		return
+	}
+	results, err := titleIdx.lookup(q, maxSuggestions)
+	if err != nil {
+		errorf("unable to load suggestions: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "unable to load suggestions")
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func suggest(t *testing.T, q string) []Suggestion {
+	t.Helper()
+	w := httptest.NewRecorder()
+	suggestionsHandler(w, httptest.NewRequest("GET", "/search/suggestions?q="+q, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var got []Suggestion
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("bad JSON %q: %v", w.Body, err)
+	}
+	return got
+}
+
+func TestSuggestions(t *testing.T) {
+	useTempRecords(t)
+	for _, rec := range []*Record{
+		{Title: "Learning Go", Published: true},
+		{Title: "Going places", Published: true},
+		{Title: "Cooking", Published: true},
+		{Title: "Go drafts", Published: false},
+	} {
+		if err := CreateRecord(rec, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := suggest(t, "GO")
+	want := []Suggestion{{"going-places", "Going places"}, {"learning-go", "Learning Go"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got := suggest(t, ""); len(got) != 0 {
+		t.Fatalf("expected nothing for an empty query, got %v", got)
+	}
+
+	// the index follows creates, renames and deletes
+	if err := CreateRecord(&Record{Title: "Gopher", Published: true}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteRecord("learning-go"); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := LoadRecord("going-places")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Title, rec.StoredSlug = "Gone places", ""
+	if err := RenameRecord("going-places", rec); err != nil {
+		t.Fatal(err)
+	}
+	got = suggest(t, "go")
+	want = []Suggestion{{"gone-places", "Gone places"}, {"gopher", "Gopher"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v after changes, got %v", want, got)
+	}
+}
+
+func TestSuggestionsLimit(t *testing.T) {
+	useTempRecords(t)
+	for i := 0; i < maxSuggestions+5; i++ {
+		if err := CreateRecord(&Record{Title: fmt.Sprintf("Post %02d", i), Published: true}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := suggest(t, "post"); len(got) != maxSuggestions {
+		t.Fatalf("expected %d suggestions, got %d", maxSuggestions, len(got))
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// tagSizes is how many font sizes the tag cloud uses.
+const tagSizes = 5
+
+// normalizeTag is the form tags are compared and counted in, so "Go" and
+// " go" are the same tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// CountTags returns how many of records carry each tag, normalized. A tag
+// listed twice on one record counts once.
+func CountTags(records []*Record) map[string]int {
+	counts := make(map[string]int)
+	for _, r := range records {
+		seen := make(map[string]bool)
+		for _, tag := range r.Tags {
+			tag = normalizeTag(tag)
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			counts[tag]++
+		}
+	}
+	return counts
+}
+
+// TagCount is a tag on the /tags page. Size runs from 1 for the least used
+// tags to tagSizes for the most used.
+type TagCount struct {
+	Name  string
+	Count int
+	Size  int
+}
+
+// URL is the tag's page.
+func (t TagCount) URL() string {
+	return "/tag/" + url.PathEscape(t.Name)
+}
+
+// tagCloud sorts counts by name and sizes each tag linearly between the
+// least and most used.
+func tagCloud(counts map[string]int) []TagCount {
+	tags := make([]TagCount, 0, len(counts))
+	lo, hi := 0, 0
+	for name, n := range counts {
+		tags = append(tags, TagCount{Name: name, Count: n})
+		if lo == 0 || n < lo {
+			lo = n
+		}
+		if n > hi {
+			hi = n
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	for i := range tags {
+		tags[i].Size = 1
+		if hi > lo {
+			tags[i].Size += (tags[i].Count - lo) * (tagSizes - 1) / (hi - lo)
+		}
+	}
+	return tags
+}
+
+func publishedRecords() ([]*Record, error) {
+	records, err := AllRecords()
+	if err != nil {
+		return nil, err
+	}
+	published := make([]*Record, 0, len(records))
+	for _, r := range records {
+		if r.Published {
+			published = append(published, r)
+		}
+	}
+	return published, nil
+}
+
+// The ways /tags can show the tags with ?view=.
+const (
+	tagViewCloud = "cloud"
+	tagViewList  = "list"
+)
+
+// byCount sorts tags most used first, then by name.
+func byCount(tags []TagCount) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+}
+
+// tagCloudHandler serves /tags, every tag used by a listed post, as a cloud
+// or, with ?view=list, a list counting their posts.
+func tagCloudHandler(w http.ResponseWriter, r *http.Request) {
+	view := r.URL.Query().Get("view")
+	if view == "" {
+		view = tagViewCloud
+	}
+	if view != tagViewCloud && view != tagViewList {
+		http.Error(w, `view must be "cloud" or "list"`, http.StatusBadRequest)
+		return
+	}
+	counts, err := titleIdx.tagCounts()
+	if err != nil {
+		errorf("unable to load records for tags: %v", err)
+		http.Error(w, "unable to load tags", http.StatusInternalServerError)
+		return
+	}
+	tags := tagCloud(counts)
+	if view == tagViewList {
+		byCount(tags)
+	}
+	renderTemplate(w, r, "tags", &TemplateData{TagCloud: tags, TagView: view})
+}
+
+// tagHandler serves /tag/{name}, the listed posts with that tag.
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := normalizeTag(strings.TrimPrefix(r.URL.Path, "/tag/"))
+	if tag == "" {
+		http.Redirect(w, r, "/tags", http.StatusFound)
+		return
+	}
+	records, err := listedRecords()
+	if err != nil {
+		errorf("unable to load records for tag %q: %v", tag, err)
+		http.Error(w, "unable to load posts", http.StatusInternalServerError)
+		return
+	}
+
+	tagged := make([]*Record, 0)
+	for _, rec := range records {
+		for _, t := range rec.Tags {
+			if normalizeTag(t) == tag {
+				tagged = append(tagged, rec)
+				break
+			}
+		}
+	}
+	if len(tagged) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	sortNewestFirst(tagged)
+	renderTemplate(w, r, "tag", &TemplateData{Records: tagged, Tag: tag})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCountTags(t *testing.T) {
+	records := []*Record{
+		{Tags: []string{"Go", " web "}},
+		{Tags: []string{"go", "GO", "testing"}},
+		{Tags: []string{"  "}},
+		{},
+	}
+	got := CountTags(records)
+	want := map[string]int{"go": 2, "web": 1, "testing": 1}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestTagCloud(t *testing.T) {
+	got := tagCloud(map[string]int{"go": 9, "web": 1, "testing": 5})
+	want := []TagCount{{"go", 9, 5}, {"testing", 5, 3}, {"web", 1, 1}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got := tagCloud(map[string]int{"a": 3, "b": 3}); got[0].Size != 1 || got[1].Size != 1 {
+		t.Fatalf("equally used tags should share a size, got %v", got)
+	}
+}
+
+func TestTagPages(t *testing.T) {
+	useTempRecords(t)
+	for _, rec := range []*Record{
+		{Title: "First", Published: true, Tags: []string{"Go"}},
+		{Title: "Second", Published: true, Tags: []string{"go", "web"}},
+		{Title: "Secret", Published: false, Tags: []string{"go", "hidden"}},
+		{Title: "Diary", Published: true, Visibility: visibilityPrivate, Tags: []string{"go", "private"}},
+	} {
+		if err := CreateRecord(rec, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tt := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		code    int
+		want    []string
+		notWant []string
+	}{
+		{"cloud", tagCloudHandler, "/tags", http.StatusOK,
+			[]string{`href="/tag/go" class="tag-size-5" title="2 posts"`, `href="/tag/web" class="tag-size-1" title="1 post"`}, []string{"hidden", "private"}},
+		{"list", tagCloudHandler, "/tags?view=list", http.StatusOK,
+			[]string{`<li><a href="/tag/go">go</a> (2)</li>`, `<li><a href="/tag/web">web</a> (1)</li>`}, []string{"hidden", "private", "tag-size"}},
+		{"bad view", tagCloudHandler, "/tags?view=pie", http.StatusBadRequest, nil, nil},
+		{"tag", tagHandler, "/tag/GO", http.StatusOK, []string{"/show/first", "/show/second", "(2 posts)"}, []string{"/show/secret", "/show/diary"}},
+		{"drafts only", tagHandler, "/tag/hidden", http.StatusNotFound, nil, nil},
+		{"unknown", tagHandler, "/tag/nope", http.StatusNotFound, nil, nil},
+		{"no tag", tagHandler, "/tag/", http.StatusFound, nil, nil},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tc.handler(w, httptest.NewRequest("GET", tc.target, nil))
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d: %s", tc.code, w.Code, w.Body)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("expected body to contain %q, got:\n%s", want, w.Body)
+				}
+			}
+			for _, s := range tc.notWant {
+				if strings.Contains(w.Body.String(), s) {
+					t.Errorf("body should not contain %q:\n%s", s, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestTagCountsFollowChanges(t *testing.T) {
+	useTempRecords(t)
+	rec := &Record{Title: "First", Published: true, Tags: []string{"go"}}
+	if err := CreateRecord(rec, false); err != nil {
+		t.Fatal(err)
+	}
+	if counts, err := titleIdx.tagCounts(); err != nil || counts["go"] != 1 {
+		t.Fatalf("expected go once, got %v %v", counts, err)
+	}
+
+	rec.Tags = []string{"web"}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := titleIdx.tagCounts()
+	if err != nil || counts["go"] != 0 || counts["web"] != 1 {
+		t.Fatalf("expected the saved tags, got %v %v", counts, err)
+	}
+	if err := DeleteRecord("first"); err != nil {
+		t.Fatal(err)
+	}
+	if counts, _ := titleIdx.tagCounts(); len(counts) != 0 {
+		t.Fatalf("expected no tags after deleting the post, got %v", counts)
+	}
+}
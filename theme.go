@@ -0,0 +1,293 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultTheme = "default"
+
+// The built-in theme every other theme falls back to is compiled into the
+// binary, so a deploy is a single file.
+//
+//go:embed templates/default static
+var embedded embed.FS
+
+var (
+	configFile = envOr("BLOG_CONFIG_FILE", "config.json")
+	// set these to work on the built-in theme without rebuilding
+	templatesDir = os.Getenv("BLOG_TEMPLATES_DIR")
+	staticDir    = os.Getenv("BLOG_STATIC_DIR")
+	themesDir    = "themes"
+)
+
+// templatesFS returns the default theme's templates: templatesDir when set,
+// the embedded copy otherwise.
+func templatesFS() fs.FS {
+	if templatesDir != "" {
+		return os.DirFS(templatesDir)
+	}
+	sub, err := fs.Sub(embedded, "templates/"+defaultTheme)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// staticFS is templatesFS for the default static assets.
+func staticFS() fs.FS {
+	if staticDir != "" {
+		return os.DirFS(staticDir)
+	}
+	sub, err := fs.Sub(embedded, "static")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// requiredTemplates are the pages the handlers render; the default theme
+// must provide all of them.
+var requiredTemplates = []string{
+	"index", "show", "edit", "new", "delete", "history", "diff", "search", "admin", "login",
+	"tags", "tag", "series", "error", "stats", "templates", "unlock", "comments", "moderate",
+	"featured",
+}
+
+// siteConfig holds the settings that can be changed while the blog is running.
+type siteConfig struct {
+	Theme string `json:"theme"`
+}
+
+var (
+	themeMu   sync.RWMutex
+	theme     = envOr("BLOG_THEME", defaultTheme)
+	templates *template.Template
+)
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func currentTheme() string {
+	themeMu.RLock()
+	defer themeMu.RUnlock()
+	return theme
+}
+
+// ThemesDir lists the available themes: the built-in default plus every
+// directory under themes/.
+func ThemesDir() ([]string, error) {
+	themes := []string{defaultTheme}
+	files, err := ioutil.ReadDir(themesDir)
+	if os.IsNotExist(err) {
+		return themes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() && f.Name() != defaultTheme {
+			themes = append(themes, f.Name())
+		}
+	}
+	sort.Strings(themes[1:])
+	return themes, nil
+}
+
+func themeExists(name string) bool {
+	themes, err := ThemesDir()
+	if err != nil {
+		return false
+	}
+	for _, t := range themes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFuncs are available to every theme.
+var templateFuncs = template.FuncMap{
+	"csrfField":   csrfInput,
+	"authorName":  authorName,
+	"commentView": newCommentView,
+}
+
+// parseTheme builds the template set for a theme. The defaults are parsed
+// first, in name order, and the theme's files after them, so any page or
+// {{define}} block the theme provides replaces the default one.
+func parseTheme(name string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).ParseFS(templatesFS(), "*.html")
+	if err != nil {
+		return nil, err
+	}
+	if name == defaultTheme {
+		return t, nil
+	}
+	// Glob sorts the overrides, keeping the merge order stable
+	overrides, err := filepath.Glob(filepath.Join(themesDir, name, "*.html"))
+	if err != nil || len(overrides) == 0 {
+		return t, err
+	}
+	return t.ParseFiles(overrides...)
+}
+
+// verifyTemplates checks that the default templates hold every page the
+// handlers need, so a bad BLOG_TEMPLATES_DIR fails at startup rather than on
+// the first request.
+func verifyTemplates() error {
+	fsys := templatesFS()
+	if _, err := fs.Stat(fsys, "."); err != nil {
+		return err
+	}
+	var missing []string
+	for _, name := range requiredTemplates {
+		if _, err := fs.Stat(fsys, name+".html"); err != nil {
+			missing = append(missing, name+".html")
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("templates are missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// setTheme parses the named theme and makes it the active one.
+func setTheme(name string) error {
+	t, err := parseTheme(name)
+	if err != nil {
+		return err
+	}
+	themeMu.Lock()
+	theme, templates = name, t
+	themeMu.Unlock()
+	// a reload is how edited assets get picked up, even ones whose size
+	// and modtime didn't change
+	staticETags.Range(func(k, _ interface{}) bool {
+		staticETags.Delete(k)
+		return true
+	})
+	return nil
+}
+
+func currentTemplates() (*template.Template, error) {
+	themeMu.RLock()
+	t := templates
+	themeMu.RUnlock()
+	if t != nil {
+		return t, nil
+	}
+	if err := setTheme(currentTheme()); err != nil {
+		return nil, err
+	}
+	return currentTemplates()
+}
+
+// loadConfig applies a previously saved theme selection, which wins over
+// BLOG_THEME so a choice made in /admin/theme survives restarts.
+func loadConfig() error {
+	data, err := ioutil.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var c siteConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	if c.Theme != "" {
+		themeMu.Lock()
+		theme = c.Theme
+		themeMu.Unlock()
+	}
+	return nil
+}
+
+func saveConfig(c siteConfig) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Clean(configFile), data, 0600)
+}
+
+// themeFS serves the active theme's static assets, falling back to the
+// default ones for anything the theme doesn't provide.
+type themeFS struct{}
+
+func (fsys themeFS) Open(name string) (http.File, error) {
+	f, _, err := fsys.openSource(name)
+	return f, err
+}
+
+// openSource is Open that also reports the directory the file came from,
+// "embedded" for the built-in assets.
+func (themeFS) openSource(name string) (http.File, string, error) {
+	if t := currentTheme(); t != defaultTheme {
+		dir := filepath.Join(themesDir, t, "static")
+		if f, err := http.Dir(dir).Open(name); err == nil {
+			return f, dir, nil
+		}
+	}
+	source := staticDir
+	if source == "" {
+		source = "embedded"
+	}
+	f, err := http.FS(staticFS()).Open(name)
+	return f, source, err
+}
+
+func themeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.FormValue("theme")
+	if !themeExists(name) {
+		http.Error(w, "no such theme", http.StatusBadRequest)
+		return
+	}
+	if err := setTheme(name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := saveConfig(siteConfig{Theme: name}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setFlash(w, flashInfo, "Theme changed to "+name)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// reloadThemeHandler re-reads the active theme's templates from disk.
+func reloadThemeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := setTheme(currentTheme()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, flashInfo, "Theme reloaded")
+	http.Redirect(w, r, "/", http.StatusFound)
+}
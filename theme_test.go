@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func useTempConfig(t *testing.T) string {
+	t.Helper()
+	oldFile, oldTheme := configFile, currentTheme()
+	configFile = filepath.Join(t.TempDir(), "config.json")
+	t.Cleanup(func() {
+		configFile = oldFile
+		theme, templates = oldTheme, nil
+	})
+	return configFile
+}
+
+// useTempThemes creates a "dark" theme that overrides only show.html and
+// ships one stylesheet of its own.
+func useTempThemes(t *testing.T) {
+	t.Helper()
+	old := themesDir
+	themesDir = t.TempDir()
+	t.Cleanup(func() { themesDir = old })
+
+	dark := filepath.Join(themesDir, "dark")
+	if err := os.MkdirAll(filepath.Join(dark, "static"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"show.html":        `dark {{ .Title }}`,
+		"static/dark.css":  `body { background: black; }`,
+		"static/style.css": `/* dark */`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dark, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestThemesDir(t *testing.T) {
+	useTempThemes(t)
+
+	themes, err := ThemesDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(themes, ",") != "default,dark" {
+		t.Fatalf("expected [default dark], got %v", themes)
+	}
+}
+
+func TestParseThemeFallsBack(t *testing.T) {
+	useTempThemes(t)
+
+	tmpl, err := parseTheme("dark")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "show.html", &TemplateData{Record: &Record{Title: "hi"}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "dark hi" {
+		t.Fatalf("expected the theme's show.html, got %q", buf.String())
+	}
+
+	// the theme has no index.html, so the default one is used
+	if tmpl.Lookup("index.html") == nil {
+		t.Fatal("expected index.html to fall back to the default theme")
+	}
+}
+
+func TestThemeStatic(t *testing.T) {
+	useTempConfig(t)
+	useTempThemes(t)
+	if err := setTheme("dark"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := http.StripPrefix("/static/", http.FileServer(themeFS{}))
+	tt := []struct {
+		path string
+		want string
+	}{
+		{"/static/dark.css", "background: black"},
+		{"/static/style.css", "/* dark */"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tc.want) {
+				t.Fatalf("expected %q, got %d %q", tc.want, w.Code, w.Body)
+			}
+		})
+	}
+
+	// switching back serves the defaults again
+	if err := setTheme(defaultTheme); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/static/dark.css", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a theme-only asset, got %d", w.Code)
+	}
+}
+
+func TestThemeHandler(t *testing.T) {
+	path := useTempConfig(t)
+	useTempThemes(t)
+
+	tt := []struct {
+		name  string
+		theme string
+		code  int
+	}{
+		{"unknown theme", "nope", http.StatusBadRequest},
+		{"path escape", "../dark", http.StatusBadRequest},
+		{"dark", "dark", http.StatusFound},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			form := url.Values{"theme": {tc.theme}}
+			r := httptest.NewRequest("POST", "/admin/theme", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			themeHandler(w, r)
+			if w.Code != tc.code {
+				t.Fatalf("expected %d, got %d", tc.code, w.Code)
+			}
+		})
+	}
+
+	if currentTheme() != "dark" {
+		t.Fatalf("expected theme to be switched, got %s", currentTheme())
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"theme": "dark"`) {
+		t.Fatalf("theme not persisted, config is %s", data)
+	}
+
+	// a restart should pick the saved theme back up
+	theme = defaultTheme
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if currentTheme() != "dark" {
+		t.Fatalf("expected saved theme to be loaded, got %s", currentTheme())
+	}
+}
+
+func TestVerifyTemplates(t *testing.T) {
+	old := templatesDir
+	t.Cleanup(func() { templatesDir = old })
+
+	templatesDir = ""
+	if err := verifyTemplates(); err != nil {
+		t.Fatalf("the embedded templates should verify: %v", err)
+	}
+
+	templatesDir = filepath.Join(t.TempDir(), "nope")
+	if err := verifyTemplates(); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+
+	templatesDir = t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(templatesDir, "index.html"), []byte("hi"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := verifyTemplates()
+	if err == nil || !strings.Contains(err.Error(), "show.html") {
+		t.Fatalf("expected the missing pages to be named, got %v", err)
+	}
+}
+
+func TestTemplatesDirOverride(t *testing.T) {
+	useTempConfig(t)
+	old := templatesDir
+	t.Cleanup(func() { templatesDir = old })
+
+	templatesDir = t.TempDir()
+	for _, name := range requiredTemplates {
+		page := []byte("disk " + name)
+		if err := ioutil.WriteFile(filepath.Join(templatesDir, name+".html"), page, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tmpl, err := parseTheme(defaultTheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "index.html", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "disk index" {
+		t.Fatalf("expected the override directory to win, got %q", buf.String())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	// tlsDomain turns on HTTPS with Let's Encrypt certificates; it may list
+	// several comma separated domains
+	tlsDomain   = os.Getenv("BLOG_TLS_DOMAIN")
+	tlsCacheDir = envOr("BLOG_TLS_CACHE_DIR", "certs")
+)
+
+// serve runs the blog over plain HTTP, or over HTTPS on :443 when
+// BLOG_TLS_DOMAIN is set. In that case :80 answers the ACME challenges and
+// sends everything else to HTTPS.
+func serve(handler http.Handler) error {
+	if tlsDomain == "" {
+		infof("Starting server on localhost:5050/")
+		return http.ListenAndServe(":5050", handler)
+	}
+
+	domains := strings.Split(tlsDomain, ",")
+	for i := range domains {
+		domains[i] = strings.TrimSpace(domains[i])
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(tlsCacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+	}
+
+	go func() {
+		err := http.ListenAndServe(":80", m.HTTPHandler(http.HandlerFunc(redirectToHTTPS)))
+		errorf("HTTP challenge server stopped: %v", err)
+	}()
+
+	srv := &http.Server{
+		Addr:      ":443",
+		Handler:   handler,
+		TLSConfig: m.TLSConfig(),
+	}
+	infof("Starting server on https://%s/", domains[0])
+	// the certificates come from TLSConfig, not from files
+	return srv.ListenAndServeTLS("", "")
+}
+
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tt := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"root", "http://blog.example.com/", "https://blog.example.com/"},
+		{"query", "http://blog.example.com/search?q=go", "https://blog.example.com/search?q=go"},
+		{"port", "http://blog.example.com:80/show/hello", "https://blog.example.com/show/hello"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			redirectToHTTPS(w, httptest.NewRequest("GET", tc.target, nil))
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("expected 301, got %d", w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tc.want {
+				t.Fatalf("\nexpected: %s\nactual: %s", tc.want, got)
+			}
+		})
+	}
+}
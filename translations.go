@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLang is the language of a post's own record; translations are
+// stored beside it as {slug}.{lang}.json
+var defaultLang = strings.ToLower(envOr("BLOG_DEFAULT_LANG", "en"))
+
+// validLang matches the language tags used in translation file names:
+// a language and optionally a region, like "de" or "pt-br".
+var validLang = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// isRecordFile reports whether name, in recordsDir, holds a post rather
+// than a translation of one, an in-progress write or anything else.
+func isRecordFile(name string) bool {
+	stem := strings.TrimSuffix(name, ".json")
+	return stem != name && !strings.HasPrefix(name, ".") && !strings.Contains(stem, ".")
+}
+
+// translationPath is where the lang translation of the post with slug is
+// stored.
+func translationPath(slug, lang string) (string, error) {
+	if !validLang.MatchString(lang) {
+		return "", fmt.Errorf("invalid language %q", lang)
+	}
+	return safePath(recordsDir, slug, "."+lang+".json")
+}
+
+// LoadTranslation returns the lang translation of the post with slug. Only
+// its title and content are used; everything else comes from the post.
+func LoadTranslation(slug, lang string) (*Record, error) {
+	filename, err := translationPath(slug, lang)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s in %s", ErrNotFound, slug, lang)
+	} else if err != nil {
+		return nil, err
+	}
+	t, err := parseRecordFile(data)
+	if err != nil {
+		if ve, ok := err.(*ValidationError); ok {
+			ve.File = filename
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// translations lists the languages the post with slug is translated into,
+// sorted, not counting defaultLang.
+func translations(slug string) ([]string, error) {
+	if _, err := recordPath(slug); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(recordsDir, slug+".*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var langs []string
+	for _, f := range files {
+		lang := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), slug+"."), ".json")
+		if validLang.MatchString(lang) && lang != defaultLang {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs, nil
+}
+
+// translated is rec in the language of t: its title and content, and
+// everything else, the slug included, from rec.
+func translated(rec, t *Record) *Record {
+	tr := *rec
+	tr.StoredSlug = rec.Slug()
+	tr.Title, tr.Content = t.Title, t.Content
+	return &tr
+}
+
+// acceptedLanguages are the languages in an Accept-Language header, most
+// wanted first, lower-cased and without the ones refused with q=0.
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if lang == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, weighted{lang, q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	langs := make([]string, len(accepted))
+	for i, a := range accepted {
+		langs[i] = a.lang
+	}
+	return langs
+}
+
+// pickLanguage chooses which of available to show r, preferring a ?lang=
+// the language switcher set, then the Accept-Language header, where "de-at"
+// is also satisfied by "de", and falling back to defaultLang.
+func pickLanguage(r *http.Request, available []string) string {
+	has := func(lang string) bool {
+		return lang == defaultLang || contains(available, lang)
+	}
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); lang != "" && has(lang) {
+		return lang
+	}
+	for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		if has(lang) {
+			return lang
+		}
+		if i := strings.IndexByte(lang, '-'); i > 0 && has(lang[:i]) {
+			return lang[:i]
+		}
+	}
+	return defaultLang
+}
+
+// deleteTranslations removes the translations of slug, when its post is
+// deleted.
+func deleteTranslations(slug string) error {
+	langs, err := translations(slug)
+	if err != nil {
+		return err
+	}
+	for _, lang := range langs {
+		filename, err := translationPath(slug, lang)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveTranslations carries a post's translations over when its slug
+// changes.
+func moveTranslations(oldSlug, newSlug string) error {
+	langs, err := translations(oldSlug)
+	if err != nil {
+		return err
+	}
+	for _, lang := range langs {
+		from, err := translationPath(oldSlug, lang)
+		if err != nil {
+			return err
+		}
+		to, err := translationPath(newSlug, lang)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTranslation(t *testing.T, slug, lang string, tr *Record) {
+	t.Helper()
+	path, err := translationPath(slug, lang)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	tt := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"de", []string{"de"}},
+		{"fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", []string{"fr-ch", "fr", "en", "*"}},
+		{"en;q=0.5, de", []string{"de", "en"}},
+		{"de;q=0, en", []string{"en"}},
+	}
+	for _, tc := range tt {
+		if got := acceptedLanguages(tc.header); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.header, tc.want, got)
+		}
+	}
+}
+
+func TestPickLanguage(t *testing.T) {
+	available := []string{"de", "pt-br"}
+	tt := []struct {
+		name   string
+		target string
+		accept string
+		want   string
+	}{
+		{"no header", "/", "", "en"},
+		{"exact", "/", "de", "de"},
+		{"region falls back to language", "/", "de-AT", "de"},
+		{"region", "/", "pt-BR", "pt-br"},
+		{"preference order", "/", "fr, en;q=0.9, de;q=0.8", "en"},
+		{"nothing matches", "/", "fr, ja", "en"},
+		{"switcher wins", "/?lang=de", "en", "de"},
+		{"unknown switcher value", "/?lang=fr", "de", "de"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			r.Header.Set("Accept-Language", tc.accept)
+			if got := pickLanguage(r, available); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestShowTranslations(t *testing.T) {
+	dir := useTempRecords(t)
+	if err := (&Record{Title: "Hello", Content: "Good morning", Published: true}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	writeTranslation(t, "hello", "de", &Record{Title: "Hallo", Content: "Guten Morgen"})
+
+	records, problems, err := LoadAllRecords()
+	if err != nil || len(records) != 1 || len(problems) != 0 {
+		t.Fatalf("expected the translation not listed as a post, got %d records and %v, %v", len(records), problems, err)
+	}
+
+	show := func(target, accept string) string {
+		t.Helper()
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Accept-Language", accept)
+		w := httptest.NewRecorder()
+		showHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Language") {
+			t.Fatal("expected the page to vary by Accept-Language")
+		}
+		return w.Body.String()
+	}
+
+	if body := show("/show/hello", "de-DE, en;q=0.5"); !strings.Contains(body, "Guten Morgen") || !strings.Contains(body, `<html lang="de">`) {
+		t.Fatalf("expected the German translation, got %s", body)
+	}
+	body := show("/show/hello", "fr")
+	if !strings.Contains(body, "Good morning") || !strings.Contains(body, `<html lang="en">`) {
+		t.Fatalf("expected the default language as a fallback, got %s", body)
+	}
+	if !strings.Contains(body, `<a href="?lang=de"`) {
+		t.Fatalf("expected a switcher to German, got %s", body)
+	}
+	if body := show("/show/hello?lang=de", "en"); !strings.Contains(body, "Hallo") {
+		t.Fatalf("expected the switcher to pick German, got %s", body)
+	}
+
+	rec, _ := LoadRecord("hello")
+	rec.Title = "Hello Again"
+	if err := RenameRecord("hello", rec); err != nil {
+		t.Fatal(err)
+	}
+	if tr, err := LoadTranslation("hello-again", "de"); err != nil || tr.Title != "Hallo" {
+		t.Fatalf("expected the translation to follow the rename, got %+v, %v", tr, err)
+	}
+
+	w := httptest.NewRecorder()
+	deleteHandler(w, httptest.NewRequest("POST", "/delete/hello-again", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected the post deleted, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "hello-again.de.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected the translation deleted with the post, got %v", err)
+	}
+}
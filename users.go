@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// usersFile holds the accounts that can log in, managed with
+	// "blog user ..."
+	usersFile = envOr("BLOG_USERS_FILE", "users.json")
+	// bcryptCost is the work factor for new password hashes; existing
+	// hashes keep the cost they were made with
+	bcryptCost = envInt("BLOG_BCRYPT_COST", bcrypt.DefaultCost)
+)
+
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,32}$`)
+
+// User is a local account. Only the bcrypt hash of the password is kept.
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	DisplayName  string `json:"display_name,omitempty"`
+	// Role is what the user may do, see authorize; empty means admin
+	Role string `json:"role,omitempty"`
+}
+
+// Name is how the user is shown on posts.
+func (u *User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Username
+}
+
+// userStore is the accounts from usersFile. It is loaded at startup and
+// reloaded by watch when the file changes, so "blog user ..." takes effect
+// without a restart.
+type userStore struct {
+	mu      sync.RWMutex
+	users   map[string]*User
+	modTime time.Time
+}
+
+var users = &userStore{users: make(map[string]*User)}
+
+func (s *userStore) get(name string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	return u, ok
+}
+
+func (s *userStore) count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// load reads filename, which doesn't have to exist.
+func (s *userStore) load(filename string) error {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		s.mu.Lock()
+		s.users, s.modTime = make(map[string]*User), time.Time{}
+		s.mu.Unlock()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	list, err := readUsersFile(filename)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*User, len(list))
+	for _, u := range list {
+		byName[u.Username] = u
+	}
+	s.mu.Lock()
+	s.users, s.modTime = byName, info.ModTime()
+	s.mu.Unlock()
+	return nil
+}
+
+// reloadIfChanged loads filename again if it was modified, created or
+// removed since it was last read.
+func (s *userStore) reloadIfChanged(filename string) (bool, error) {
+	var mod time.Time
+	info, err := os.Stat(filename)
+	if err == nil {
+		mod = info.ModTime()
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	s.mu.RLock()
+	same := mod.Equal(s.modTime)
+	s.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+	return true, s.load(filename)
+}
+
+func (s *userStore) watch(filename string, every time.Duration) {
+	for range time.Tick(every) {
+		changed, err := s.reloadIfChanged(filename)
+		if err != nil {
+			errorf("unable to reload %s: %v", filename, err)
+		} else if changed {
+			infof("reloaded %s: %d users", filename, s.count())
+		}
+	}
+}
+
+func readUsersFile(filename string) ([]*User, error) {
+	list := make([]*User, 0)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return list, nil
+}
+
+func writeUsersFile(filename string, list []*User) error {
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicWriteFile(filename, data, 0600)
+}
+
+// dummyHash is compared against when the username is unknown, so a login
+// takes as long whether or not the account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no such user"), bcryptCost)
+
+// authenticate checks a username and password against the local accounts
+// and then the credentials from the flags or environment, returning the
+// username to log in as.
+func authenticate(user, pass string) (string, bool) {
+	if u, ok := users.get(user); ok {
+		if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(pass)) == nil {
+			return u.Username, true
+		}
+		return "", false
+	}
+	if users.count() > 0 {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+	}
+	if authUser != "" && authPass != "" &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(authUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(authPass)) == 1 {
+		return authUser, true
+	}
+	return "", false
+}
+
+// authorName is how username is shown on the posts they wrote.
+func authorName(username string) string {
+	if u, ok := users.get(username); ok {
+		return u.Name()
+	}
+	return strings.TrimPrefix(username, githubUserPrefix)
+}
+
+func hashPassword(pass string) (string, error) {
+	if pass == "" {
+		return "", errors.New("the password must not be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcryptCost)
+	return string(hash), err
+}
+
+// runUserCommand runs "blog user ...", which manages the accounts in
+// usersFile. Passwords are read from the first line of in. New accounts are
+// editors until "user role" says otherwise.
+func runUserCommand(args []string, in io.Reader, out io.Writer) error {
+	usage := errors.New("usage: user add <name> [display name] | user passwd <name> | user role <name> admin|editor|viewer | user rm <name>")
+	if len(args) < 2 {
+		return usage
+	}
+	cmd, name := args[0], args[1]
+	readPassword := func() (string, error) {
+		fmt.Fprint(out, "password: ")
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return hashPassword(strings.TrimRight(line, "\r\n"))
+	}
+
+	list, err := readUsersFile(usersFile)
+	if err != nil {
+		return err
+	}
+	i := -1
+	for j, u := range list {
+		if u.Username == name {
+			i = j
+		}
+	}
+
+	switch cmd {
+	case "add":
+		if !validUsername.MatchString(name) {
+			return fmt.Errorf("invalid username %q: use up to 32 letters, digits, dots, dashes or underscores", name)
+		}
+		if i >= 0 {
+			return fmt.Errorf("user %s already exists", name)
+		}
+		hash, err := readPassword()
+		if err != nil {
+			return err
+		}
+		list = append(list, &User{Username: name, PasswordHash: hash, DisplayName: strings.Join(args[2:], " "), Role: roleEditor})
+	case "passwd":
+		if i < 0 {
+			return fmt.Errorf("%w: user %s", ErrNotFound, name)
+		}
+		hash, err := readPassword()
+		if err != nil {
+			return err
+		}
+		list[i].PasswordHash = hash
+	case "role":
+		if i < 0 {
+			return fmt.Errorf("%w: user %s", ErrNotFound, name)
+		}
+		if len(args) != 3 || !validRole(args[2]) {
+			return usage
+		}
+		list[i].Role = args[2]
+	case "rm":
+		if i < 0 {
+			return fmt.Errorf("%w: user %s", ErrNotFound, name)
+		}
+		list = append(list[:i], list[i+1:]...)
+	default:
+		return usage
+	}
+	if err := writeUsersFile(usersFile, list); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nsaved %s\n", usersFile)
+	return nil
+}
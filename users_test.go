@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useTestUsers points the user store at an empty file of the test's own.
+func useTestUsers(t *testing.T) string {
+	t.Helper()
+	oldFile, oldUsers, oldCost := usersFile, users, bcryptCost
+	usersFile = filepath.Join(t.TempDir(), "users.json")
+	users = &userStore{users: make(map[string]*User)}
+	bcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { usersFile, users, bcryptCost = oldFile, oldUsers, oldCost })
+	return usersFile
+}
+
+func userCommand(t *testing.T, input string, args ...string) error {
+	t.Helper()
+	var out bytes.Buffer
+	return runUserCommand(args, strings.NewReader(input), &out)
+}
+
+func TestUserCommand(t *testing.T) {
+	filename := useTestUsers(t)
+
+	if err := userCommand(t, "hunter2\n", "add", "ada", "Ada", "Lovelace"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.load(filename); err != nil {
+		t.Fatal(err)
+	}
+	u, ok := users.get("ada")
+	if !ok || u.DisplayName != "Ada Lovelace" || strings.Contains(u.PasswordHash, "hunter2") {
+		t.Fatalf("unexpected user %+v", u)
+	}
+	if cost, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil || cost != bcryptCost {
+		t.Fatalf("expected cost %d, got %d %v", bcryptCost, cost, err)
+	}
+	if name, ok := authenticate("ada", "hunter2"); !ok || name != "ada" {
+		t.Fatal("expected the new password to work")
+	}
+
+	if err := userCommand(t, "correct horse\n", "passwd", "ada"); err != nil {
+		t.Fatal(err)
+	}
+	users.load(filename)
+	if _, ok := authenticate("ada", "hunter2"); ok {
+		t.Fatal("the old password should no longer work")
+	}
+	if _, ok := authenticate("ada", "correct horse"); !ok {
+		t.Fatal("expected the changed password to work")
+	}
+
+	for _, tc := range []struct {
+		input string
+		args  []string
+	}{
+		{"pw\n", []string{"add", "ada"}},
+		{"pw\n", []string{"add", "no spaces allowed"}},
+		{"\n", []string{"add", "bob"}},
+		{"pw\n", []string{"passwd", "nobody"}},
+		{"", []string{"rm", "nobody"}},
+		{"", []string{"add"}},
+		{"", []string{"rename", "ada"}},
+		{"", []string{"role", "ada", "owner"}},
+		{"", []string{"role", "nobody", "admin"}},
+	} {
+		if err := userCommand(t, tc.input, tc.args...); err == nil {
+			t.Errorf("%q: expected an error", tc.args)
+		}
+	}
+
+	if u, _ := users.get("ada"); u.Role != roleEditor {
+		t.Fatalf("expected new users to be editors, got %q", u.Role)
+	}
+	if err := userCommand(t, "", "role", "ada", "viewer"); err != nil {
+		t.Fatal(err)
+	}
+	users.load(filename)
+	if u, _ := users.get("ada"); u.Role != roleViewer {
+		t.Fatalf("expected ada to be a viewer, got %q", u.Role)
+	}
+
+	if err := userCommand(t, "", "rm", "ada"); err != nil {
+		t.Fatal(err)
+	}
+	users.load(filename)
+	if users.count() != 0 {
+		t.Fatal("expected ada to be removed")
+	}
+	if err := userCommand(t, "", "rm", "ada"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUsersReloadOnChange(t *testing.T) {
+	filename := useTestUsers(t)
+	if changed, err := users.reloadIfChanged(filename); err != nil || changed {
+		t.Fatalf("nothing should change without a file, got %v %v", changed, err)
+	}
+
+	if err := userCommand(t, "pw\n", "add", "grace"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := users.reloadIfChanged(filename); err != nil || !changed || users.count() != 1 {
+		t.Fatalf("expected the new file to be loaded, got %v %v %d", changed, err, users.count())
+	}
+	if changed, _ := users.reloadIfChanged(filename); changed {
+		t.Fatal("an unchanged file should not be reloaded")
+	}
+
+	// make sure the modification time moves even on coarse filesystems
+	if err := userCommand(t, "", "rm", "grace"); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filename, later, later)
+	if changed, err := users.reloadIfChanged(filename); err != nil || !changed || users.count() != 0 {
+		t.Fatalf("expected the removal to be picked up, got %v %v %d", changed, err, users.count())
+	}
+}
+
+func TestLoginAsUserSetsAuthor(t *testing.T) {
+	useTempRecords(t)
+	filename := useTestUsers(t)
+	useTestSessions(t)
+	if err := userCommand(t, "s3cret\n", "add", "linus", "Linus"); err != nil {
+		t.Fatal(err)
+	}
+	users.load(filename)
+	if !authConfigured() {
+		t.Fatal("a local account should count as configured credentials")
+	}
+
+	form := url.Values{"username": {"linus"}, "password": {"s3cret"}, "next": {"/new/"}}
+	w := httptest.NewRecorder()
+	loginHandler(w, formRequest("/login", form, nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected the login to succeed, got %d: %s", w.Code, w.Body)
+	}
+	c := sessionCookieFrom(t, w)
+
+	form = url.Values{"title": {"Kernel notes"}, "content": {"x"}, csrfFormField: {tokenFor(c)}}
+	w = httptest.NewRecorder()
+	requireCSRF(createHandler)(w, formRequest("/create/", form, c))
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected the post to be created, got %d: %s", w.Code, w.Body)
+	}
+	rec, err := LoadRecord("kernel-notes")
+	if err != nil || rec.Author != "linus" {
+		t.Fatalf("expected linus as the author, got %+v %v", rec, err)
+	}
+
+	w = httptest.NewRecorder()
+	showHandler(w, httptest.NewRequest("GET", "/show/kernel-notes", nil))
+	if !strings.Contains(w.Body.String(), `<p class="byline">by Linus</p>`) {
+		t.Fatalf("expected a byline with the display name:\n%s", w.Body)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxTitleLen = 200
+
+// minSlugLen leaves room for a word and a collision suffix like "-100".
+const minSlugLen = 16
+
+// maxSlugLen caps generated slugs, and so the record filenames, well under
+// filesystem limits. Longer titles are cut at a word boundary.
+var maxSlugLen = atLeast(envInt("BLOG_MAX_SLUG_LEN", 80), minSlugLen)
+
+func atLeast(n, min int) int {
+	if n < min {
+		return min
+	}
+	return n
+}
+
+// reservedSlugs would read as one of the site's own pages, e.g. /new/.
+var reservedSlugs = map[string]bool{
+	"new": true, "edit": true, "save": true, "delete": true, "create": true, "show": true,
+	"api": true, "static": true, "feed": true, "search": true, "tag": true, "tags": true,
+	"admin": true, "random": true, "history": true, "diff": true, "preview": true,
+	"login": true, "logout": true, "sitemap": true, "robots": true,
+}
+
+// checkSlug reports whether slug may be given to a new or renamed post.
+// slugify never produces a slug that fails this, so it only matters for
+// slugs that were set explicitly.
+func checkSlug(slug string) error {
+	switch {
+	case !validSlug.MatchString(slug):
+		return fmt.Errorf("%w: %q may only contain letters, digits and hyphens", ErrInvalidSlug, slug)
+	case len(slug) > maxSlugLen:
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrInvalidSlug, slug, maxSlugLen)
+	case reservedSlugs[slug]:
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidSlug, slug)
+	}
+	return nil
+}
+
+// requireContent makes an empty post body a validation error.
+var requireContent = envOr("BLOG_REQUIRE_CONTENT", "") != ""
+
+// validateRecord checks a record submitted through a form, returning one
+// message per problem found.
+func validateRecord(r *Record) []string {
+	var errs []string
+
+	title := strings.TrimSpace(r.Title)
+	switch {
+	case title == "":
+		errs = append(errs, "Title is required")
+	case utf8.RuneCountInString(title) > maxTitleLen:
+		errs = append(errs, fmt.Sprintf("Title must be at most %d characters", maxTitleLen))
+	case r.Slug() == "":
+		errs = append(errs, "Title must contain at least one letter or number")
+	case !validSlug.MatchString(r.Slug()):
+		errs = append(errs, "Title contains characters that can't be used in a link")
+	case checkSlug(r.Slug()) != nil:
+		errs = append(errs, fmt.Sprintf("The link %q can't be used for a post", r.Slug()))
+	}
+
+	if requireContent && strings.TrimSpace(r.Content) == "" {
+		errs = append(errs, "Content is required")
+	}
+	if r.CanonicalURL != "" && !absoluteHTTPURL(r.CanonicalURL) {
+		errs = append(errs, "Canonical URL must be a full http or https address")
+	}
+	return errs
+}
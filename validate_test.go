@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateRecord(t *testing.T) {
+	tt := []struct {
+		name           string
+		title          string
+		content        string
+		requireContent bool
+		errs           []string
+	}{
+		{"valid", "Hello World", "body", false, nil},
+		{"empty title", "", "body", false, []string{"Title is required"}},
+		{"blank title", "   ", "body", false, []string{"Title is required"}},
+		{"too long", strings.Repeat("a", maxTitleLen+1), "body", false, []string{"Title must be at most 200 characters"}},
+		{"at the limit", strings.Repeat("a", maxTitleLen), "body", false, nil},
+		{"no usable characters", "?!*", "body", false, nil},
+		{"punctuation", "Version 1.0", "body", false, nil},
+		{"empty content allowed", "Hello", "", false, nil},
+		{"empty content required", "Hello", " ", true, []string{"Content is required"}},
+		{"everything wrong", "", "", true, []string{"Title is required", "Content is required"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			old := requireContent
+			requireContent = tc.requireContent
+			defer func() { requireContent = old }()
+
+			errs := validateRecord(&Record{Title: tc.title, Content: tc.content})
+			if strings.Join(errs, "|") != strings.Join(tc.errs, "|") {
+				t.Fatalf("\nexpected: %q\nactual: %q", tc.errs, errs)
+			}
+		})
+	}
+}
+
+func TestSlugLimits(t *testing.T) {
+	long := strings.Repeat("word ", 30)
+
+	tt := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"reserved", "New", "new-post"},
+		{"reserved admin", "Admin!", "admin-post"},
+		{"not reserved once longer", "New Year", "new-year"},
+		{"long", long, truncateSlug(strings.Repeat("word-", 30), maxSlugLen)},
+		{"long single word", strings.Repeat("a", 100), strings.Repeat("a", maxSlugLen)},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := slugify(tc.title)
+			if got != tc.want {
+				t.Fatalf("\nexpected: %s\nactual: %s", tc.want, got)
+			}
+			if len(got) > maxSlugLen || strings.HasSuffix(got, "-") {
+				t.Fatalf("bad slug %q", got)
+			}
+			if err := checkSlug(got); err != nil {
+				t.Fatalf("slugify produced a slug checkSlug refuses: %v", err)
+			}
+		})
+	}
+
+	if got := slugify(long); got != strings.TrimSuffix(strings.Repeat("word-", 16), "-") {
+		t.Fatalf("expected the slug to be cut at a hyphen, got %q", got)
+	}
+}
+
+// badSlugs are refused for new posts however they are created.
+var badSlugs = []string{"new", "admin", "static", strings.Repeat("x", maxSlugLen+1), "a/b"}
+
+func TestCheckSlugPaths(t *testing.T) {
+	useTempRecords(t)
+	if err := (&Record{Title: "Existing"}).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, slug := range badSlugs {
+		t.Run(slug, func(t *testing.T) {
+			if err := checkSlug(slug); !errors.Is(err, ErrInvalidSlug) {
+				t.Fatalf("checkSlug: expected ErrInvalidSlug, got %v", err)
+			}
+			if errs := validateRecord(&Record{Title: "Fine", StoredSlug: slug}); len(errs) != 1 {
+				t.Fatalf("validateRecord: expected one error, got %v", errs)
+			}
+			if err := CreateRecord(&Record{Title: "Fine", StoredSlug: slug}, true); !errors.Is(err, ErrInvalidSlug) {
+				t.Fatalf("CreateRecord: expected ErrInvalidSlug, got %v", err)
+			}
+			if err := RenameRecord("existing", &Record{Title: "Fine", StoredSlug: slug}); !errors.Is(err, ErrInvalidSlug) {
+				t.Fatalf("RenameRecord: expected ErrInvalidSlug, got %v", err)
+			}
+		})
+	}
+
+	// suffixes still fit
+	base := strings.Repeat("y", maxSlugLen)
+	for i := 0; i < 2; i++ {
+		r := &Record{Title: base}
+		if err := CreateRecord(r, true); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Slug()) > maxSlugLen {
+			t.Fatalf("suffixed slug %q is too long", r.Slug())
+		}
+	}
+
+	// the create form can't make a post at /show/new either
+	form := url.Values{"title": {"new"}, "content": {"x"}}
+	r := httptest.NewRequest("POST", "/create/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	createHandler(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/show/new-post" {
+		t.Fatalf("expected a redirect to /show/new-post, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestLongTitlesCollide(t *testing.T) {
+	useTempRecords(t)
+	old := maxSlugLen
+	maxSlugLen = 20
+	t.Cleanup(func() { maxSlugLen = old })
+
+	first := &Record{Title: "An extremely long title about slugs, part one"}
+	second := &Record{Title: "An extremely long title about slugs, part two"}
+	if err := CreateRecord(first, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateRecord(second, true); err != nil {
+		t.Fatal(err)
+	}
+	if first.Slug() != "an-extremely-long" || second.Slug() != "an-extremely-long-2" {
+		t.Fatalf("expected distinct truncated slugs, got %q and %q", first.Slug(), second.Slug())
+	}
+	if err := CreateRecord(&Record{Title: "An extremely long title about slugs, part three"}, false); err != ErrSlugExists {
+		t.Fatalf("expected ErrSlugExists without suffixes, got %v", err)
+	}
+}
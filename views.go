@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+var viewsFile = envOr("BLOG_VIEWS_FILE", "views.json")
+
+// viewDays is how many days of daily view counts are kept.
+const viewDays = 30
+
+const viewDayLayout = "2006-01-02"
+
+// viewCounter counts show page views per slug. Counts are kept out of the
+// record files so a view never rewrites (or adds a revision to) a post.
+// Besides the totals it keeps the last viewDays days' counts, by UTC day.
+type viewCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+	days   map[string]map[string]int
+	dirty  bool
+}
+
+var views = &viewCounter{counts: make(map[string]int)}
+
+// viewDay is the key of the day t falls on.
+func viewDay(t time.Time) string {
+	return t.UTC().Truncate(24 * time.Hour).Format(viewDayLayout)
+}
+
+func (v *viewCounter) add(slug string) {
+	v.addAt(slug, time.Now())
+}
+
+func (v *viewCounter) addAt(slug string, now time.Time) {
+	v.mu.Lock()
+	v.counts[slug]++
+	if v.days == nil {
+		v.days = make(map[string]map[string]int)
+	}
+	if v.days[slug] == nil {
+		v.days[slug] = make(map[string]int)
+	}
+	v.days[slug][viewDay(now)]++
+	v.dirty = true
+	v.mu.Unlock()
+}
+
+// total is slug's views ever.
+func (v *viewCounter) total(slug string) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.counts[slug]
+}
+
+// lastDays is slug's views over the n days up to and including now's.
+func (v *viewCounter) lastDays(slug string, n int, now time.Time) int {
+	day := now.UTC().Truncate(24 * time.Hour)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	total := 0
+	for i := 0; i < n; i++ {
+		total += v.days[slug][day.AddDate(0, 0, -i).Format(viewDayLayout)]
+	}
+	return total
+}
+
+// expire drops the daily counts from before the last viewDays days.
+func (v *viewCounter) expire(now time.Time) {
+	oldest := viewDay(now.AddDate(0, 0, -viewDays+1))
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for slug, days := range v.days {
+		for day := range days {
+			// the layout sorts like the dates it stands for
+			if day < oldest {
+				delete(days, day)
+				v.dirty = true
+			}
+		}
+		if len(days) == 0 {
+			delete(v.days, slug)
+		}
+	}
+}
+
+// rename moves a post's views over when its slug changes.
+func (v *viewCounter) rename(oldSlug, newSlug string) {
+	v.mu.Lock()
+	if n, ok := v.counts[oldSlug]; ok {
+		v.counts[newSlug] += n
+		delete(v.counts, oldSlug)
+		v.dirty = true
+	}
+	if days, ok := v.days[oldSlug]; ok {
+		if v.days[newSlug] == nil {
+			v.days[newSlug] = make(map[string]int)
+		}
+		for day, n := range days {
+			v.days[newSlug][day] += n
+		}
+		delete(v.days, oldSlug)
+	}
+	v.mu.Unlock()
+}
+
+// prune drops the counts of posts that no longer exist, returning how many
+// were dropped.
+func (v *viewCounter) prune(exists map[string]bool) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	n := 0
+	for slug := range v.counts {
+		if !exists[slug] {
+			delete(v.counts, slug)
+			n++
+		}
+	}
+	for slug := range v.days {
+		if !exists[slug] {
+			delete(v.days, slug)
+		}
+	}
+	if n > 0 {
+		v.dirty = true
+	}
+	return n
+}
+
+func (v *viewCounter) snapshot() map[string]int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts := make(map[string]int, len(v.counts))
+	for slug, n := range v.counts {
+		counts[slug] = n
+	}
+	return counts
+}
+
+// viewsData is the views file: the totals and the daily counts.
+type viewsData struct {
+	Counts map[string]int            `json:"counts"`
+	Days   map[string]map[string]int `json:"days"`
+}
+
+func (v *viewCounter) load(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// files from before the daily counts are just the totals
+	var saved viewsData
+	var counts map[string]int
+	if err := json.Unmarshal(data, &counts); err == nil {
+		saved.Counts = counts
+	} else if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	if saved.Counts == nil {
+		saved.Counts = make(map[string]int)
+	}
+	v.mu.Lock()
+	v.counts, v.days = saved.Counts, saved.Days
+	v.mu.Unlock()
+	return nil
+}
+
+// flush writes the counts out if they changed since the last flush.
+func (v *viewCounter) flush(filename string) error {
+	v.mu.Lock()
+	if !v.dirty {
+		v.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(viewsData{Counts: v.counts, Days: v.days})
+	v.dirty = false
+	v.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0600)
+}
+
+func (v *viewCounter) flushLoop(filename string, every time.Duration) {
+	for range time.Tick(every) {
+		v.expire(time.Now())
+		if err := v.flush(filename); err != nil {
+			errorf("unable to save view counts: %v", err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestViewCounterPersists(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "views.json")
+
+	v := &viewCounter{counts: make(map[string]int)}
+	v.add("hello")
+	v.add("hello")
+	v.add("world")
+	if err := v.flush(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &viewCounter{counts: make(map[string]int)}
+	if err := loaded.load(filename); err != nil {
+		t.Fatal(err)
+	}
+	counts := loaded.snapshot()
+	if counts["hello"] != 2 || counts["world"] != 1 {
+		t.Fatalf("unexpected counts after reload: %v", counts)
+	}
+}
+
+func TestViewCounterMissingFile(t *testing.T) {
+	v := &viewCounter{counts: make(map[string]int)}
+	if err := v.load(filepath.Join(t.TempDir(), "nope.json")); err != nil {
+		t.Fatalf("a missing file should just mean no views yet, got %v", err)
+	}
+}
+
+func TestViewCounterDays(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	v := &viewCounter{counts: make(map[string]int)}
+	for _, ago := range []int{0, 0, 6, 7, 29, 30, 45} {
+		v.addAt("hello", now.AddDate(0, 0, -ago))
+	}
+	if got := v.total("hello"); got != 7 {
+		t.Fatalf("expected 7 views in all, got %d", got)
+	}
+	if got := v.lastDays("hello", 7, now); got != 3 {
+		t.Fatalf("expected 3 views in the last 7 days, got %d", got)
+	}
+	if got := v.lastDays("hello", 30, now); got != 5 {
+		t.Fatalf("expected 5 views in the last 30 days, got %d", got)
+	}
+
+	v.expire(now)
+	if len(v.days["hello"]) != 4 || v.total("hello") != 7 {
+		t.Fatalf("expected the old days gone and the total kept, got %v", v.days["hello"])
+	}
+	v.rename("hello", "hi")
+	if got := v.lastDays("hi", 7, now); got != 3 {
+		t.Fatalf("expected the daily counts to follow a rename, got %d", got)
+	}
+}
+
+func TestViewCounterLoadsTotalsOnly(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "views.json")
+	if err := os.WriteFile(filename, []byte(`{"hello": 4, "counts": 2}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v := &viewCounter{counts: make(map[string]int)}
+	if err := v.load(filename); err != nil {
+		t.Fatal(err)
+	}
+	if v.total("hello") != 4 || v.total("counts") != 2 {
+		t.Fatalf("unexpected counts from an old file: %v", v.snapshot())
+	}
+
+	v.addAt("hello", time.Now())
+	if err := v.flush(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &viewCounter{counts: make(map[string]int)}
+	if err := loaded.load(filename); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.total("hello") != 5 || loaded.lastDays("hello", 1, time.Now()) != 1 {
+		t.Fatalf("unexpected counts after reload: %v %v", loaded.counts, loaded.days)
+	}
+}
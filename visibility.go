@@ -0,0 +1,56 @@
+package main
+
+import "net/http"
+
+// Who can see a record. Records from before visibility existed have none
+// recorded and are public.
+const (
+	visibilityPublic = "public"
+	// unlisted posts can be read by anyone with the link, but aren't listed
+	// on the index, in feeds, the sitemap or search
+	visibilityUnlisted = "unlisted"
+	// private posts are unlisted, and only logged in users can read them
+	visibilityPrivate = "private"
+)
+
+func validVisibility(v string) bool {
+	return v == visibilityPublic || v == visibilityUnlisted || v == visibilityPrivate
+}
+
+// VisibilityLevel is the record's visibility, public when none is recorded.
+func (r *Record) VisibilityLevel() string {
+	if r.Visibility == "" {
+		return visibilityPublic
+	}
+	return r.Visibility
+}
+
+// Listed reports whether the record may appear in listings.
+func (r *Record) Listed() bool {
+	return r.VisibilityLevel() == visibilityPublic
+}
+
+// Private reports whether only logged in users may see the record.
+func (r *Record) Private() bool {
+	return r.VisibilityLevel() == visibilityPrivate
+}
+
+// visibleTo reports whether r may know rec exists at all.
+func visibleTo(r *http.Request, rec *Record) bool {
+	return !rec.Private() || currentUser(r) != ""
+}
+
+// listedRecords are the published records that may be listed.
+func listedRecords() ([]*Record, error) {
+	records, err := publishedRecords()
+	if err != nil {
+		return nil, err
+	}
+	listed := records[:0]
+	for _, r := range records {
+		if r.Listed() {
+			listed = append(listed, r)
+		}
+	}
+	return listed, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVisibility(t *testing.T) {
+	useTempRecords(t)
+	sitemaps.reset()
+	t.Cleanup(sitemaps.reset)
+	useTestAuth(t)
+	basicLogin(t, "admin", "secret")
+	for _, rec := range []*Record{
+		{Title: "Open Post", Content: "zebra open", Published: true},
+		{Title: "Public Post", Content: "zebra public", Published: true, Visibility: visibilityPublic},
+		{Title: "Unlisted Post", Content: "zebra unlisted", Published: true, Visibility: visibilityUnlisted},
+		{Title: "Private Post", Content: "zebra private", Published: true, Visibility: visibilityPrivate},
+	} {
+		if err := rec.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open, err := LoadRecord("open-post")
+	if err != nil {
+		t.Fatal(err)
+	}
+	month := open.CreatedAt.UTC().Format(sitemapMonthLayout)
+
+	get := func(h http.HandlerFunc, target string, loggedIn bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		if loggedIn {
+			r = authRequest("GET", target)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	tt := []struct {
+		slug     string
+		loggedIn bool
+		// listed on the index; feeds, sitemap and search; readable at all
+		index, listed, show bool
+	}{
+		{"open-post", false, true, true, true},
+		{"open-post", true, true, true, true},
+		{"public-post", false, true, true, true},
+		{"public-post", true, true, true, true},
+		{"unlisted-post", false, false, false, true},
+		{"unlisted-post", true, false, false, true},
+		{"private-post", false, false, false, false},
+		{"private-post", true, true, false, true},
+	}
+	for _, tc := range tt {
+		name := tc.slug
+		if tc.loggedIn {
+			name += " logged in"
+		}
+		t.Run(name, func(t *testing.T) {
+			link := "/show/" + tc.slug
+			if got := strings.Contains(get(indexHandler, "/", tc.loggedIn).Body.String(), link); got != tc.index {
+				t.Errorf("on the index: %v, want %v", got, tc.index)
+			}
+			if got := strings.Contains(get(jsonFeedHandler, "/feed.json", tc.loggedIn).Body.String(), link); got != tc.listed {
+				t.Errorf("in the feed: %v, want %v", got, tc.listed)
+			}
+			if got := strings.Contains(get(monthlySitemapHandler, "/sitemap/"+month+".xml", tc.loggedIn).Body.String(), link); got != tc.listed {
+				t.Errorf("in the sitemap: %v, want %v", got, tc.listed)
+			}
+			if got := strings.Contains(get(searchHandler, "/search?q="+url.QueryEscape("zebra"), tc.loggedIn).Body.String(), link); got != tc.listed {
+				t.Errorf("in search: %v, want %v", got, tc.listed)
+			}
+			want := http.StatusNotFound
+			if tc.show {
+				want = http.StatusOK
+			}
+			if w := get(showHandler, link, tc.loggedIn); w.Code != want {
+				t.Errorf("showing it: %d, want %d", w.Code, want)
+			}
+		})
+	}
+}
+
+func TestVisibilityForm(t *testing.T) {
+	rec := &Record{Title: "Form", Visibility: visibilityPrivate}
+	parse := func(form url.Values) (*Record, []string) {
+		r := httptest.NewRequest("POST", "/save/form", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		return parseRecordForm(r, modeUpdate, rec)
+	}
+	if got, _ := parse(url.Values{"title": {"Form"}}); got.Visibility != visibilityPrivate {
+		t.Fatal("expected a form without the field to keep the visibility")
+	}
+	if got, _ := parse(url.Values{"title": {"Form"}, "visibility": {"unlisted"}}); got.Visibility != visibilityUnlisted {
+		t.Fatalf("expected unlisted, got %q", got.Visibility)
+	}
+	if got, _ := parse(url.Values{"title": {"Form"}, "visibility": {"public"}}); got.VisibilityLevel() != visibilityPublic {
+		t.Fatalf("expected public, got %q", got.Visibility)
+	}
+	if _, errs := parse(url.Values{"title": {"Form"}, "visibility": {"secret"}}); len(errs) == 0 {
+		t.Fatal("expected an unknown visibility to be refused")
+	}
+	if _, err := parseRecordFile([]byte(`{"title": "x", "visibility": "hidden"}`)); err == nil {
+		t.Fatal("expected a record file with an unknown visibility to be refused")
+	}
+}